@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition with the given type, nil if not found
+func (s *BucketStatus) GetCondition(conditionType BucketConditionType) *BucketCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsConditionTrue checks if the condition with the given type has a True status
+func (s *BucketStatus) IsConditionTrue(conditionType BucketConditionType) bool {
+	c := s.GetCondition(conditionType)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// SetCondition adds or updates a condition, the transition time is only updated when the status changes.
+// Returns true if the conditions changed
+func (s *BucketStatus) SetCondition(conditionType BucketConditionType, status corev1.ConditionStatus, reason, message string) bool {
+	c := s.GetCondition(conditionType)
+	if c == nil {
+		s.Conditions = append(s.Conditions, BucketCondition{
+			Type:               conditionType,
+			Status:             status,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		})
+		return true
+	}
+
+	if c.Status == status && c.Reason == reason && c.Message == message {
+		return false
+	}
+
+	if c.Status != status {
+		c.LastTransitionTime = metav1.Now()
+	}
+	c.Status = status
+	c.Reason = reason
+	c.Message = message
+
+	return true
+}
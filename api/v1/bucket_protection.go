@@ -0,0 +1,41 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// BucketConfirmDestroyAnnotation confirms the destruction of a protected storage bucket when set to the bucket full name
+const BucketConfirmDestroyAnnotation = "ab.leclouddev.com/confirm-destroy"
+
+// DestroysStorageBucket returns true if the on delete policy destroys the storage bucket created or adopted by the Bucket
+func (r *Bucket) DestroysStorageBucket() bool {
+	if r.Status.CreatedAt == "" || r.Spec.ManagementPolicy == BucketManagementPolicyObserveOnly {
+		return false
+	}
+	switch r.Spec.OnDeletePolicy {
+	case BucketOnDeletePolicyDestroy, BucketOnDeletePolicyArchive, BucketOnDeletePolicyRetainFor:
+		return true
+	}
+	return false
+}
+
+// DestroyConfirmed returns true if the storage bucket can be destroyed: the bucket is not protected,
+// or the confirmation annotation echoes its full name
+func (r *Bucket) DestroyConfirmed() bool {
+	if !r.Spec.DeletionProtection {
+		return true
+	}
+	return r.Spec.FullName != "" && r.Annotations[BucketConfirmDestroyAnnotation] == r.Spec.FullName
+}
@@ -0,0 +1,59 @@
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Bucket deletion protection", func() {
+	var bucket *Bucket
+
+	BeforeEach(func() {
+		bucket = &Bucket{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-bucket",
+				Namespace: "default",
+			},
+			Spec: BucketSpec{
+				Cloud:              BucketCloudGCP,
+				FullName:           "ab-default-test-bucket",
+				OnDeletePolicy:     BucketOnDeletePolicyDestroy,
+				ManagementPolicy:   BucketManagementPolicyCreate,
+				DeletionProtection: true,
+			},
+			Status: BucketStatus{
+				CreatedAt: "2020-11-02T10:30:00Z",
+			},
+		}
+	})
+
+	It("Should only destroy the created storage buckets with a destructive policy", func() {
+		Expect(bucket.DestroysStorageBucket()).To(BeTrue())
+
+		bucket.Spec.OnDeletePolicy = BucketOnDeletePolicyIgnore
+		Expect(bucket.DestroysStorageBucket()).To(BeFalse())
+
+		bucket.Spec.OnDeletePolicy = BucketOnDeletePolicyRetainFor
+		bucket.Spec.ManagementPolicy = BucketManagementPolicyObserveOnly
+		Expect(bucket.DestroysStorageBucket()).To(BeFalse())
+
+		bucket.Spec.ManagementPolicy = BucketManagementPolicyAdopt
+		bucket.Status.CreatedAt = ""
+		Expect(bucket.DestroysStorageBucket()).To(BeFalse())
+	})
+
+	It("Should require the full name to be echoed to destroy a protected bucket", func() {
+		Expect(bucket.DestroyConfirmed()).To(BeFalse())
+
+		bucket.Annotations = map[string]string{BucketConfirmDestroyAnnotation: "yes"}
+		Expect(bucket.DestroyConfirmed()).To(BeFalse())
+
+		bucket.Annotations[BucketConfirmDestroyAnnotation] = "ab-default-test-bucket"
+		Expect(bucket.DestroyConfirmed()).To(BeTrue())
+
+		bucket.Annotations = nil
+		bucket.Spec.DeletionProtection = false
+		Expect(bucket.DestroyConfirmed()).To(BeTrue())
+	})
+})
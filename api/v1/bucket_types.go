@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,21 +26,128 @@ import (
 
 // BucketSpec defines the desired state of Bucket
 type BucketSpec struct {
-	// Cloud platform
+	// Cloud platform. Required unless set by the bucket class
 	// +kubebuilder:validation:Enum=gcp
-	// +kubebuilder:validation:Required
-	Cloud BucketCloud `json:"cloud"`
+	// +optional
+	Cloud BucketCloud `json:"cloud,omitempty"`
 
-	// FullName is the cloud storage bucket full name
-	// +kubebuilder:validation:Required
-	FullName string `json:"fullName"`
+	// BucketClassName is the name of the BucketClass providing the defaults of the empty fields,
+	// applied when the Bucket object is created
+	// +optional
+	BucketClassName string `json:"bucketClassName,omitempty"`
 
-	// OnDeletePolicy defines the behavior when the Deployment/Bucket objects are deleted
-	// +kubebuilder:validation:Enum=destroy;ignore
-	// +kubebuilder:validation:Required
-	OnDeletePolicy BucketOnDeletePolicy `json:"onDeletePolicy"`
+	// FullName is the cloud storage bucket full name. Defaults to the operator bucket name template
+	// +optional
+	FullName string `json:"fullName,omitempty"`
+
+	// OnDeletePolicy defines the behavior when the Deployment/Bucket objects are deleted. Defaults to the operator on delete policy
+	// +kubebuilder:validation:Enum=destroy;ignore;retain-for;archive
+	// +optional
+	OnDeletePolicy BucketOnDeletePolicy `json:"onDeletePolicy,omitempty"`
+
+	// DeletionProtection blocks the storage bucket destruction by the destroy, archive and retain-for on delete policies
+	// until the ab.leclouddev.com/confirm-destroy annotation is set to the bucket full name
+	// +optional
+	DeletionProtection bool `json:"deletionProtection,omitempty"`
+
+	// RetentionPeriod is the grace period before the storage bucket is destroyed with the retain-for on delete policy.
+	// Defaults to the operator retention period
+	// +optional
+	RetentionPeriod *metav1.Duration `json:"retentionPeriod,omitempty"`
+
+	// Archive defines where the objects are copied before the storage bucket is destroyed with the archive on delete policy.
+	// Defaults to the operator archive bucket
+	// +optional
+	Archive *BucketArchive `json:"archive,omitempty"`
+
+	// Location is the cloud storage bucket location, the cloud default if empty
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// StorageClass is the cloud storage bucket default storage class, the cloud default if empty
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// ManagementPolicy defines how the operator manages the cloud storage bucket. Defaults to create
+	// +kubebuilder:validation:Enum=create;adopt;observe-only
+	// +optional
+	ManagementPolicy BucketManagementPolicy `json:"managementPolicy,omitempty"`
+
+	// Lifecycle defines the cloud storage bucket object lifecycle rules
+	// +optional
+	Lifecycle *BucketLifecycle `json:"lifecycle,omitempty"`
+
+	// Encryption defines the cloud storage bucket default encryption
+	// +optional
+	Encryption *BucketEncryption `json:"encryption,omitempty"`
+
+	// ClaimRef references the BucketClaim bound to the Bucket
+	// +optional
+	ClaimRef *corev1.ObjectReference `json:"claimRef,omitempty"`
+
+	// Source defines the objects copied into the storage bucket once created, e.g. a template bucket for preview environments
+	// +optional
+	Source *BucketSource `json:"source,omitempty"`
+}
+
+// BucketSource defines the origin of the objects seeding a new storage bucket, set either bucketName or backupName
+type BucketSource struct {
+	// BucketName is the name of the Bucket in the namespace whose objects are copied
+	// +optional
+	BucketName string `json:"bucketName,omitempty"`
+
+	// BackupName is the name of the BucketBackup in the namespace whose objects are restored
+	// +optional
+	BackupName string `json:"backupName,omitempty"`
+}
+
+// BucketLifecycle defines the cloud storage bucket object lifecycle rules
+type BucketLifecycle struct {
+	// DeleteAfterDays deletes the objects older than the given number of days, never if 0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DeleteAfterDays int32 `json:"deleteAfterDays,omitempty"`
+
+	// Transitions move the objects to other storage classes as they age
+	// +optional
+	Transitions []BucketLifecycleTransition `json:"transitions,omitempty"`
+}
+
+// BucketLifecycleTransition moves the objects to another storage class after a given age
+type BucketLifecycleTransition struct {
+	// AfterDays is the object age in days
+	// +kubebuilder:validation:Minimum=0
+	AfterDays int32 `json:"afterDays"`
+
+	// StorageClass is the target storage class
+	StorageClass string `json:"storageClass"`
+}
+
+// BucketEncryption defines the cloud storage bucket default encryption
+type BucketEncryption struct {
+	// KMSKeyName is the cloud KMS key encrypting the objects by default,
+	// e.g. projects/{project}/locations/{location}/keyRings/{ring}/cryptoKeys/{key} on GCP
+	KMSKeyName string `json:"kmsKeyName"`
+}
+
+// BucketArchive defines where the objects are copied before the storage bucket is destroyed
+type BucketArchive struct {
+	// BucketName is the full name of the archive storage bucket
+	// +optional
+	BucketName string `json:"bucketName,omitempty"`
+
+	// Prefix is the object name prefix of the archived objects. Defaults to "{fullName}/{deletion time}/"
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// StorageClass is the storage class of the archived objects. Defaults to ARCHIVE
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
 }
 
+// DefaultArchiveStorageClass is the default storage class of the archived objects
+const DefaultArchiveStorageClass = "ARCHIVE"
+
 type BucketCloud string
 
 const (
@@ -47,10 +155,161 @@ const (
 	BucketCloudGCP BucketCloud = "gcp"
 )
 
+type BucketManagementPolicy string
+
+const (
+	// BucketManagementPolicyCreate create the storage bucket, fails if it already exists with another owner
+	BucketManagementPolicyCreate BucketManagementPolicy = "create"
+	// BucketManagementPolicyAdopt take ownership of an existing storage bucket
+	BucketManagementPolicyAdopt BucketManagementPolicy = "adopt"
+	// BucketManagementPolicyObserveOnly report the storage bucket attributes and drift, never mutate or delete it
+	BucketManagementPolicyObserveOnly BucketManagementPolicy = "observe-only"
+)
+
 // BucketStatus defines the observed state of Bucket
 type BucketStatus struct {
 	// CreatedAt is the cloud storage bucket creation time
 	CreatedAt string `json:"createdAt,omitempty"`
+
+	// Attributes are the observed cloud storage bucket attributes, reported for adopted and observed buckets
+	// +optional
+	Attributes *BucketAttributes `json:"attributes,omitempty"`
+
+	// Conditions are the latest available observations of the bucket state
+	// +optional
+	Conditions []BucketCondition `json:"conditions,omitempty"`
+
+	// Archive reports the progress of the objects archive with the archive on delete policy
+	// +optional
+	Archive *BucketArchiveStatus `json:"archive,omitempty"`
+
+	// Deletion reports the progress of the storage bucket emptying before its deletion
+	// +optional
+	Deletion *BucketDeletionStatus `json:"deletion,omitempty"`
+
+	// Seed reports the progress of the objects copy from the source after the storage bucket creation
+	// +optional
+	Seed *BucketSeedStatus `json:"seed,omitempty"`
+}
+
+// BucketSeedStatus reports the progress of the objects copy from the source into a new storage bucket
+type BucketSeedStatus struct {
+	// StartedAt is the copy start time
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is the copy completion time
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// ObjectsCopied is the number of copied objects
+	// +optional
+	ObjectsCopied int64 `json:"objectsCopied,omitempty"`
+	// BytesCopied is the size of the copied objects
+	// +optional
+	BytesCopied int64 `json:"bytesCopied,omitempty"`
+	// Failures is the number of objects that couldn't be copied
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+	// LastFailure is the last object copy error
+	// +optional
+	LastFailure string `json:"lastFailure,omitempty"`
+	// LastObject is the name of the last copied object, the copy resumes after it
+	// +optional
+	LastObject string `json:"lastObject,omitempty"`
+}
+
+// BucketDeletionStatus reports the progress of the storage bucket emptying before its deletion
+type BucketDeletionStatus struct {
+	// StartedAt is the emptying start time
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// ObjectsDeleted is the number of deleted object versions, including the noncurrent versions
+	// +optional
+	ObjectsDeleted int64 `json:"objectsDeleted,omitempty"`
+	// BytesDeleted is the size of the deleted object versions
+	// +optional
+	BytesDeleted int64 `json:"bytesDeleted,omitempty"`
+}
+
+// BucketArchiveStatus reports the progress of the objects archive before the storage bucket deletion
+type BucketArchiveStatus struct {
+	// BucketName is the full name of the archive storage bucket
+	BucketName string `json:"bucketName"`
+	// Prefix is the object name prefix of the archived objects
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// StartedAt is the archive start time
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is the archive completion time, the storage bucket is then destroyed
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// ObjectsCopied is the number of archived objects
+	// +optional
+	ObjectsCopied int64 `json:"objectsCopied,omitempty"`
+	// BytesCopied is the size of the archived objects
+	// +optional
+	BytesCopied int64 `json:"bytesCopied,omitempty"`
+	// Failures is the number of objects that couldn't be archived by the archive pass, the storage bucket is kept and
+	// archived again when a pass completes with failures
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+	// LastFailure is the last archive error
+	// +optional
+	LastFailure string `json:"lastFailure,omitempty"`
+	// LastObject is the name of the last processed object, the archive resumes after it
+	// +optional
+	LastObject string `json:"lastObject,omitempty"`
+}
+
+type BucketConditionType string
+
+const (
+	// BucketConditionConflict the cloud storage bucket already exists and is not owned by the Bucket object
+	BucketConditionConflict BucketConditionType = "Conflict"
+	// BucketConditionNotFound the cloud storage bucket to adopt or observe doesn't exist
+	BucketConditionNotFound BucketConditionType = "NotFound"
+	// BucketConditionDrifted the observed cloud storage bucket attributes differ from the spec
+	BucketConditionDrifted BucketConditionType = "Drifted"
+	// BucketConditionQuotaExceeded the storage bucket creation or adoption is blocked by a namespace BucketQuota
+	BucketConditionQuotaExceeded BucketConditionType = "QuotaExceeded"
+	// BucketConditionDeletionBlocked the deletion of a protected bucket waits for the destroy confirmation
+	BucketConditionDeletionBlocked BucketConditionType = "DeletionBlocked"
+	// BucketConditionAnnotationsRemoved the owner workload no longer requests the bucket through its annotations
+	BucketConditionAnnotationsRemoved BucketConditionType = "AnnotationsRemoved"
+	// BucketConditionAnnotationsIgnored the owner workload annotations request a cloud or full name change, which can't apply to an existing bucket
+	BucketConditionAnnotationsIgnored BucketConditionType = "AnnotationsIgnored"
+	// BucketConditionSeeded the objects of the source are copied into the new storage bucket
+	BucketConditionSeeded BucketConditionType = "Seeded"
+)
+
+// BucketAttributes are observed cloud storage bucket attributes
+type BucketAttributes struct {
+	// Location is the cloud storage bucket location
+	// +optional
+	Location string `json:"location,omitempty"`
+	// StorageClass is the cloud storage bucket default storage class
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+	// Labels are the cloud storage bucket labels
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BucketCondition describes the state of a bucket at a certain point
+type BucketCondition struct {
+	// Type of bucket condition
+	Type BucketConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one-word CamelCase reason for the condition's last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message indicating details about the transition
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 type BucketOnDeletePolicy string
@@ -60,12 +319,20 @@ const (
 	BucketOnDeletePolicyIgnore BucketOnDeletePolicy = "ignore"
 	// BucketOnDeletePolicyDestroy destroy storage bucket on object delete
 	BucketOnDeletePolicyDestroy BucketOnDeletePolicy = "destroy"
+	// BucketOnDeletePolicyRetainFor mark the storage bucket as pending deletion on object delete,
+	// it is destroyed after the retention period unless a new Bucket adopts it
+	BucketOnDeletePolicyRetainFor BucketOnDeletePolicy = "retain-for"
+	// BucketOnDeletePolicyArchive copy the objects to an archive storage bucket, then destroy the storage bucket on object delete
+	BucketOnDeletePolicyArchive BucketOnDeletePolicy = "archive"
 )
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 // +kubebuilder:printcolumn:name="Cloud",type=string,JSONPath=`.spec.cloud`
 // +kubebuilder:printcolumn:name="FullName",type=string,JSONPath=`.spec.fullName`
+// +kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.spec.bucketClassName`,priority=1
+// +kubebuilder:printcolumn:name="Management",type=string,JSONPath=`.spec.managementPolicy`,priority=1
 // +kubebuilder:printcolumn:name="CreatedAt",type=string,JSONPath=`.status.createdAt`
 
 // Bucket is the Schema for the buckets API
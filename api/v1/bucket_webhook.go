@@ -0,0 +1,488 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/didil/autobucket-operator/lib"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var bucketlog = logf.Log.WithName("bucket-resource")
+
+// SetupWebhookWithManager registers the Bucket /convert conversion webhook when the other API versions are registered
+// in the manager scheme. The defaulting and validating webhooks are registered by the BucketDefaulter and the BucketValidator
+func (r *Bucket) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// BucketDefaults are the operator-level defaults applied to buckets
+// +kubebuilder:object:generate=false
+type BucketDefaults struct {
+	// OnDeletePolicy is the default on delete policy
+	OnDeletePolicy BucketOnDeletePolicy
+	// RetentionPeriod is the default grace period of the retain-for on delete policy
+	RetentionPeriod time.Duration
+	// ArchiveBucketName is the default archive storage bucket of the archive on delete policy
+	ArchiveBucketName string
+	// NamePrefix is the name prefix used to derive the default full name
+	NamePrefix string
+	// BucketNamer derives the default full name
+	BucketNamer lib.BucketNamer
+	// Location is the default location of created buckets, the cloud default if empty
+	Location string
+	// StorageClass is the default storage class of created buckets, the cloud default if empty
+	StorageClass string
+}
+
+// DefaultRetentionPeriod is the default grace period of the retain-for on delete policy
+const DefaultRetentionPeriod = 7 * 24 * time.Hour
+
+// getBucketClass returns the bucket class of the bucket
+func (r *Bucket) getBucketClass(ctx context.Context, reader client.Reader) (*BucketClass, error) {
+	class := &BucketClass{}
+	err := reader.Get(ctx, types.NamespacedName{Name: r.Spec.BucketClassName}, class)
+	if err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+// ApplyClass fills the empty spec fields from the bucket class
+func (r *Bucket) ApplyClass(class *BucketClass) {
+	if r.Spec.Cloud == "" {
+		r.Spec.Cloud = class.Spec.Cloud
+	}
+	if r.Spec.OnDeletePolicy == "" {
+		r.Spec.OnDeletePolicy = class.Spec.OnDeletePolicy
+	}
+	if !r.Spec.DeletionProtection {
+		r.Spec.DeletionProtection = class.Spec.DeletionProtection
+	}
+	if r.Spec.RetentionPeriod == nil && class.Spec.RetentionPeriod != nil {
+		r.Spec.RetentionPeriod = class.Spec.RetentionPeriod.DeepCopy()
+	}
+	if r.Spec.Archive == nil && class.Spec.Archive != nil {
+		r.Spec.Archive = class.Spec.Archive.DeepCopy()
+	}
+	if r.Spec.Location == "" {
+		r.Spec.Location = class.Spec.Location
+	}
+	if r.Spec.StorageClass == "" {
+		r.Spec.StorageClass = class.Spec.StorageClass
+	}
+	if r.Spec.Lifecycle == nil && class.Spec.Lifecycle != nil {
+		r.Spec.Lifecycle = class.Spec.Lifecycle.DeepCopy()
+	}
+	if r.Spec.Encryption == nil && class.Spec.Encryption != nil {
+		r.Spec.Encryption = class.Spec.Encryption.DeepCopy()
+	}
+}
+
+// ApplyDefaults fills the empty spec fields from the operator defaults
+func (r *Bucket) ApplyDefaults(defaults BucketDefaults) {
+	if r.Spec.ManagementPolicy == "" {
+		r.Spec.ManagementPolicy = BucketManagementPolicyCreate
+	}
+
+	if r.Spec.OnDeletePolicy == "" {
+		r.Spec.OnDeletePolicy = defaults.OnDeletePolicy
+	}
+
+	if r.Spec.OnDeletePolicy == BucketOnDeletePolicyRetainFor && r.Spec.RetentionPeriod == nil {
+		r.Spec.RetentionPeriod = &metav1.Duration{Duration: defaults.RetentionPeriod}
+	}
+
+	if r.Spec.OnDeletePolicy == BucketOnDeletePolicyArchive {
+		if r.Spec.Archive == nil {
+			r.Spec.Archive = &BucketArchive{}
+		}
+		if r.Spec.Archive.BucketName == "" {
+			r.Spec.Archive.BucketName = defaults.ArchiveBucketName
+		}
+		if r.Spec.Archive.StorageClass == "" {
+			r.Spec.Archive.StorageClass = DefaultArchiveStorageClass
+		}
+	}
+
+	if r.Spec.FullName == "" && r.Spec.Cloud != "" {
+		fullName, err := defaults.BucketNamer.BucketName(string(r.Spec.Cloud), "", defaults.NamePrefix, r.Namespace, r.Name)
+		if err == nil {
+			r.Spec.FullName = fullName
+		} else {
+			// leave the full name empty, the validation will reject the bucket
+			bucketlog.Error(err, "Failed to build bucket full name", "name", r.Name)
+		}
+	}
+
+	// the location and storage class are only defaulted on new buckets to be created,
+	// existing storage buckets keep their attributes
+	if r.CreationTimestamp.IsZero() && r.Spec.ManagementPolicy == BucketManagementPolicyCreate {
+		if r.Spec.Location == "" {
+			r.Spec.Location = defaults.Location
+		}
+		if r.Spec.StorageClass == "" {
+			r.Spec.StorageClass = defaults.StorageClass
+		}
+	}
+}
+
+// +kubebuilder:webhook:path=/mutate-ab-leclouddev-com-v1-bucket,mutating=true,failurePolicy=fail,groups=ab.leclouddev.com,resources=buckets,verbs=create;update,versions=v1,name=mbucket.kb.io
+
+// BucketDefaulter applies the bucket class and the operator defaults to the buckets
+// +kubebuilder:object:generate=false
+type BucketDefaulter struct {
+	// Client reads the bucket classes, the classes are only applied by the bucket controller if nil
+	Client   client.Reader
+	Defaults BucketDefaults
+	decoder  *admission.Decoder
+}
+
+var _ admission.Handler = &BucketDefaulter{}
+var _ admission.DecoderInjector = &BucketDefaulter{}
+
+// SetupWebhookWithManager registers the Bucket defaulting webhook
+func (d *BucketDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register("/mutate-ab-leclouddev-com-v1-bucket", &webhook.Admission{Handler: d})
+	return nil
+}
+
+// Handle defaults the bucket
+func (d *BucketDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	bucket := &Bucket{}
+	if err := d.decoder.Decode(req, bucket); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	d.Default(ctx, bucket)
+
+	marshaled, err := json.Marshal(bucket)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// Default applies the bucket class, then the operator defaults
+func (d *BucketDefaulter) Default(ctx context.Context, r *Bucket) {
+	bucketlog.Info("default", "name", r.Name)
+
+	// the bucket class takes precedence over the operator defaults, it is applied when the Bucket object is created
+	if r.CreationTimestamp.IsZero() && r.Spec.BucketClassName != "" && d.Client != nil {
+		class, err := r.getBucketClass(ctx, d.Client)
+		if err == nil {
+			r.ApplyClass(class)
+		} else {
+			// the validation will reject the bucket if the class doesn't exist
+			bucketlog.Error(err, "Failed to get bucket class", "name", r.Name, "class", r.Spec.BucketClassName)
+		}
+	}
+
+	r.ApplyDefaults(d.Defaults)
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (d *BucketDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ab-leclouddev-com-v1-bucket,mutating=false,failurePolicy=fail,groups=ab.leclouddev.com,resources=buckets,versions=v1,name=vbucket.kb.io
+
+// BucketValidator rejects the invalid buckets and the buckets exceeding the namespace quotas
+// +kubebuilder:object:generate=false
+type BucketValidator struct {
+	// Client reads the bucket classes and quotas, they are not checked if nil
+	Client client.Reader
+	// ClaimNamespace is the namespace of the Buckets provisioned for claims, counted against the claim namespace quotas
+	ClaimNamespace string
+	decoder        *admission.Decoder
+}
+
+var _ admission.Handler = &BucketValidator{}
+var _ admission.DecoderInjector = &BucketValidator{}
+
+// SetupWebhookWithManager registers the Bucket validating webhook
+func (v *BucketValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register("/validate-ab-leclouddev-com-v1-bucket", &webhook.Admission{Handler: v})
+	return nil
+}
+
+// Handle validates the created and updated buckets
+func (v *BucketValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	bucket := &Bucket{}
+	if err := v.decoder.DecodeRaw(req.Object, bucket); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var err error
+	switch req.Operation {
+	case admissionv1beta1.Create:
+		err = v.ValidateCreate(ctx, bucket)
+	case admissionv1beta1.Update:
+		old := &Bucket{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = v.ValidateUpdate(bucket, old)
+	}
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// ValidateCreate validates a new bucket, its bucket class and the namespace quotas
+func (v *BucketValidator) ValidateCreate(ctx context.Context, r *Bucket) error {
+	bucketlog.Info("validate create", "name", r.Name)
+
+	if r.Spec.BucketClassName != "" && v.Client != nil {
+		if _, err := r.getBucketClass(ctx, v.Client); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Bucket"}, r.Name, field.ErrorList{
+				field.NotFound(field.NewPath("spec", "bucketClassName"), r.Spec.BucketClassName),
+			})
+		}
+	}
+
+	if v.Client != nil && r.CountsAgainstQuota() {
+		if err := v.checkQuotas(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	return r.validate(nil)
+}
+
+// checkQuotas checks the new bucket against the namespace quotas, the claim namespace quotas for claim buckets
+func (v *BucketValidator) checkQuotas(ctx context.Context, r *Bucket) error {
+	namespace := r.QuotaNamespace(v.ClaimNamespace)
+
+	quotas := &BucketQuotaList{}
+	if err := v.Client.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	if len(quotas.Items) == 0 {
+		return nil
+	}
+
+	buckets, err := ListQuotaBuckets(ctx, v.Client, namespace, v.ClaimNamespace)
+	if err != nil {
+		return err
+	}
+	var count int32
+	for i := range buckets {
+		b := &buckets[i]
+		if (b.Namespace != r.Namespace || b.Name != r.Name) && b.DeletionTimestamp.IsZero() && b.CountsAgainstQuota() {
+			count++
+		}
+	}
+
+	if err := CheckBucketQuotas(quotas.Items, r, count); err != nil {
+		return apierrors.NewForbidden(schema.GroupResource{Group: GroupVersion.Group, Resource: "buckets"}, r.Name, err)
+	}
+
+	return nil
+}
+
+// ValidateUpdate validates an updated bucket against the old bucket
+func (v *BucketValidator) ValidateUpdate(r *Bucket, old *Bucket) error {
+	bucketlog.Info("validate update", "name", r.Name)
+
+	return r.validate(old)
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *BucketValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// validate checks the bucket spec, and the immutable fields against the old bucket on updates
+func (r *Bucket) validate(old *Bucket) error {
+	var oldSpec *BucketSpec
+	if old != nil {
+		oldSpec = &old.Spec
+	}
+	var allErrs field.ErrorList
+	// the spec values of a bucket being deleted are only checked when they change, so that the finalizer removal is never blocked,
+	// e.g. on buckets named before the current naming rules. The deletion protection and the immutable fields are always checked
+	if r.DeletionTimestamp == nil || old == nil || !reflect.DeepEqual(r.Spec, old.Spec) {
+		allErrs = r.Spec.validate(oldSpec, field.NewPath("spec"))
+		if r.Spec.Source != nil && r.Spec.Source.BucketName == r.Name {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "source", "bucketName"), r.Spec.Source.BucketName, "must differ from the bucket name"))
+		}
+	}
+
+	if old != nil {
+		// disabling the deletion protection is the first step of the destroy confirmation, it can't skip the second
+		if old.Spec.DeletionProtection && !r.Spec.DeletionProtection && r.Annotations[BucketConfirmDestroyAnnotation] != r.Spec.FullName {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "deletionProtection"),
+				fmt.Sprintf("can only be disabled once the %s annotation is set to the bucket full name", BucketConfirmDestroyAnnotation)))
+		}
+
+		// the bucket class can fill the empty fields until the storage bucket is created
+		classPending := old.Spec.BucketClassName != "" && old.Status.CreatedAt == ""
+		allErrs = append(allErrs, r.Spec.validateUpdate(&old.Spec, classPending, field.NewPath("spec"))...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Bucket"}, r.Name, allErrs)
+}
+
+// validate checks the spec values. The bucket names are only checked on creation or when they change,
+// so that the buckets named before the current naming rules can still be updated
+func (s *BucketSpec) validate(old *BucketSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch s.Cloud {
+	case BucketCloudGCP:
+		if old != nil && old.FullName == s.FullName {
+			break
+		}
+		if err := lib.ValidateBucketName(string(s.Cloud), s.FullName); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("fullName"), s.FullName, err.Error()))
+		}
+	case "":
+		allErrs = append(allErrs, field.Required(path.Child("cloud"), "must be set directly or by the bucket class"))
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("cloud"), s.Cloud, []string{string(BucketCloudGCP)}))
+	}
+
+	switch s.OnDeletePolicy {
+	case BucketOnDeletePolicyIgnore, BucketOnDeletePolicyDestroy, BucketOnDeletePolicyRetainFor:
+	case BucketOnDeletePolicyArchive:
+		allErrs = append(allErrs, s.validateArchive(old, path.Child("archive"))...)
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("onDeletePolicy"), s.OnDeletePolicy,
+			[]string{string(BucketOnDeletePolicyIgnore), string(BucketOnDeletePolicyDestroy), string(BucketOnDeletePolicyRetainFor),
+				string(BucketOnDeletePolicyArchive)}))
+	}
+
+	if s.RetentionPeriod != nil && s.RetentionPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("retentionPeriod"), s.RetentionPeriod.Duration.String(), "must be positive"))
+	}
+
+	switch s.ManagementPolicy {
+	case "", BucketManagementPolicyCreate, BucketManagementPolicyAdopt, BucketManagementPolicyObserveOnly:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("managementPolicy"), s.ManagementPolicy,
+			[]string{string(BucketManagementPolicyCreate), string(BucketManagementPolicyAdopt), string(BucketManagementPolicyObserveOnly)}))
+	}
+
+	if s.Lifecycle != nil {
+		for i, t := range s.Lifecycle.Transitions {
+			if t.StorageClass == "" {
+				allErrs = append(allErrs, field.Required(path.Child("lifecycle", "transitions").Index(i).Child("storageClass"), ""))
+			}
+		}
+	}
+
+	if s.Encryption != nil && s.Encryption.KMSKeyName == "" {
+		allErrs = append(allErrs, field.Required(path.Child("encryption", "kmsKeyName"), ""))
+	}
+
+	if s.Source != nil {
+		allErrs = append(allErrs, s.validateSource(path.Child("source"))...)
+	}
+
+	return allErrs
+}
+
+// validateUpdate checks that the immutable fields are unchanged,
+// empty fields can still be filled if classPending is true
+func (s *BucketSpec) validateUpdate(old *BucketSpec, classPending bool, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	immutable := func(name, value, oldValue string) {
+		if value == oldValue || (classPending && oldValue == "") {
+			return
+		}
+		allErrs = append(allErrs, field.Forbidden(path.Child(name), "field is immutable"))
+	}
+
+	immutable("cloud", string(s.Cloud), string(old.Cloud))
+	if s.FullName != old.FullName {
+		allErrs = append(allErrs, field.Forbidden(path.Child("fullName"), "field is immutable"))
+	}
+	immutable("location", s.Location, old.Location)
+	if s.BucketClassName != old.BucketClassName {
+		allErrs = append(allErrs, field.Forbidden(path.Child("bucketClassName"), "field is immutable"))
+	}
+	if (s.Source == nil) != (old.Source == nil) || (s.Source != nil && *s.Source != *old.Source) {
+		allErrs = append(allErrs, field.Forbidden(path.Child("source"), "field is immutable"))
+	}
+
+	return allErrs
+}
+
+// validateArchive checks the archive settings of the archive on delete policy
+func (s *BucketSpec) validateArchive(old *BucketSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.Archive == nil || s.Archive.BucketName == "" {
+		return append(allErrs, field.Required(path.Child("bucketName"), "must be set directly, by the bucket class or by the operator archive bucket"))
+	}
+	if s.Archive.BucketName == s.FullName {
+		allErrs = append(allErrs, field.Invalid(path.Child("bucketName"), s.Archive.BucketName, "must differ from the bucket full name"))
+	} else if s.Cloud != "" && (old == nil || old.Archive == nil || old.Archive.BucketName != s.Archive.BucketName) {
+		if err := lib.ValidateBucketName(string(s.Cloud), s.Archive.BucketName); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("bucketName"), s.Archive.BucketName, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateSource checks the source seeding a new storage bucket
+func (s *BucketSpec) validateSource(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if (s.Source.BucketName == "") == (s.Source.BackupName == "") {
+		allErrs = append(allErrs, field.Invalid(path, *s.Source, "set either bucketName or backupName"))
+	}
+	if s.ManagementPolicy != "" && s.ManagementPolicy != BucketManagementPolicyCreate {
+		allErrs = append(allErrs, field.Forbidden(path, "only created storage buckets can be seeded"))
+	}
+
+	return allErrs
+}
@@ -0,0 +1,403 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Bucket webhook", func() {
+	ctx := context.Background()
+	validator := &BucketValidator{}
+	var bucket *Bucket
+
+	BeforeEach(func() {
+		bucket = &Bucket{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-bucket",
+				Namespace: "default",
+			},
+			Spec: BucketSpec{
+				Cloud:          BucketCloudGCP,
+				FullName:       "ab-default-test-bucket",
+				OnDeletePolicy: BucketOnDeletePolicyIgnore,
+				Location:       "us-east1",
+			},
+		}
+	})
+
+	Context("When creating a bucket", func() {
+		It("Should accept a valid bucket", func() {
+			Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+		})
+
+		It("Should reject an invalid full name", func() {
+			bucket.Spec.FullName = "AB_default"
+			Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("spec.fullName")))
+		})
+
+		It("Should reject an unknown cloud", func() {
+			bucket.Spec.Cloud = "aws"
+			Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("spec.cloud")))
+		})
+
+		It("Should reject an unknown on delete policy", func() {
+			bucket.Spec.OnDeletePolicy = "archive-forever"
+			Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("spec.onDeletePolicy")))
+		})
+
+		It("Should accept a source bucket or backup", func() {
+			bucket.Spec.Source = &BucketSource{BucketName: "template"}
+			Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+
+			bucket.Spec.Source = &BucketSource{BackupName: "template-backup"}
+			Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+		})
+
+		It("Should reject an invalid source", func() {
+			bucket.Spec.Source = &BucketSource{BucketName: "template", BackupName: "template-backup"}
+			Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("set either bucketName or backupName")))
+
+			bucket.Spec.Source = &BucketSource{BucketName: bucket.Name}
+			Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("spec.source.bucketName")))
+
+			bucket.Spec.Source = &BucketSource{BucketName: "template"}
+			bucket.Spec.ManagementPolicy = BucketManagementPolicyAdopt
+			Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("only created storage buckets can be seeded")))
+		})
+	})
+
+	Context("When updating a bucket", func() {
+		It("Should accept mutable field changes", func() {
+			updated := bucket.DeepCopy()
+			updated.Spec.OnDeletePolicy = BucketOnDeletePolicyDestroy
+			Expect(validator.ValidateUpdate(updated, bucket)).To(Succeed())
+		})
+
+		It("Should reject immutable field changes", func() {
+			updated := bucket.DeepCopy()
+			updated.Spec.FullName = "ab-default-other-bucket"
+			updated.Spec.Location = "eu"
+			err := validator.ValidateUpdate(updated, bucket)
+			Expect(err).To(MatchError(ContainSubstring("spec.fullName")))
+			Expect(err).To(MatchError(ContainSubstring("spec.location")))
+		})
+
+		It("Should accept updates of buckets named before the current naming rules", func() {
+			bucket.Spec.FullName = "ab-default-googlebucket"
+			updated := bucket.DeepCopy()
+			updated.Spec.OnDeletePolicy = BucketOnDeletePolicyDestroy
+			Expect(validator.ValidateUpdate(updated, bucket)).To(Succeed())
+		})
+
+		It("Should accept the finalizer removal of an invalid bucket being deleted", func() {
+			now := metav1.Now()
+			bucket.Spec.Cloud = ""
+			bucket.Spec.FullName = "AB_legacy"
+			bucket.Finalizers = []string{"ab.leclouddev.com/bucket-finalizer"}
+			bucket.DeletionTimestamp = &now
+			updated := bucket.DeepCopy()
+			updated.Finalizers = nil
+			Expect(validator.ValidateUpdate(updated, bucket)).To(Succeed())
+		})
+
+		It("Should keep the deletion protection and the immutable fields of a bucket being deleted", func() {
+			now := metav1.Now()
+			bucket.Spec.DeletionProtection = true
+			bucket.Finalizers = []string{"ab.leclouddev.com/bucket-finalizer"}
+			bucket.DeletionTimestamp = &now
+
+			updated := bucket.DeepCopy()
+			updated.Spec.DeletionProtection = false
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.deletionProtection")))
+
+			updated.Annotations = map[string]string{BucketConfirmDestroyAnnotation: bucket.Spec.FullName}
+			Expect(validator.ValidateUpdate(updated, bucket)).To(Succeed())
+
+			updated = bucket.DeepCopy()
+			updated.Spec.FullName = "ab-default-other-bucket"
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.fullName")))
+
+			updated = bucket.DeepCopy()
+			updated.Spec.Cloud = ""
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.cloud")))
+		})
+
+		It("Should only accept disabling the deletion protection once the destroy is confirmed", func() {
+			bucket.Spec.DeletionProtection = true
+			updated := bucket.DeepCopy()
+			updated.Spec.DeletionProtection = false
+			updated.Spec.OnDeletePolicy = BucketOnDeletePolicyDestroy
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.deletionProtection")))
+
+			updated.Annotations = map[string]string{BucketConfirmDestroyAnnotation: "ab-default-other-bucket"}
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.deletionProtection")))
+
+			updated.Annotations = map[string]string{BucketConfirmDestroyAnnotation: bucket.Spec.FullName}
+			Expect(validator.ValidateUpdate(updated, bucket)).To(Succeed())
+		})
+
+		It("Should reject source changes", func() {
+			bucket.Spec.Source = &BucketSource{BucketName: "template"}
+			updated := bucket.DeepCopy()
+			updated.Spec.Source.BucketName = "other-template"
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.source")))
+
+			updated.Spec.Source = nil
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.source")))
+		})
+
+		It("Should accept the bucket class filling empty fields before the storage bucket creation", func() {
+			bucket.Spec.BucketClassName = "standard"
+			bucket.Spec.Location = ""
+			updated := bucket.DeepCopy()
+			updated.Spec.Location = "us-east1"
+			Expect(validator.ValidateUpdate(updated, bucket)).To(Succeed())
+
+			bucket.Status.CreatedAt = "2020-11-02T10:30:00Z"
+			Expect(validator.ValidateUpdate(updated, bucket)).To(MatchError(ContainSubstring("spec.location")))
+		})
+	})
+})
+
+var _ = Describe("Bucket defaulting webhook", func() {
+	ctx := context.Background()
+	defaulter := &BucketDefaulter{
+		Defaults: BucketDefaults{
+			OnDeletePolicy:    BucketOnDeletePolicyDestroy,
+			RetentionPeriod:   48 * time.Hour,
+			NamePrefix:        "ab",
+			Location:          "europe-west1",
+			StorageClass:      "NEARLINE",
+			ArchiveBucketName: "ab-archive",
+		},
+	}
+	validator := &BucketValidator{}
+	var bucket *Bucket
+
+	BeforeEach(func() {
+		bucket = &Bucket{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-bucket",
+				Namespace: "default",
+			},
+			Spec: BucketSpec{
+				Cloud: BucketCloudGCP,
+			},
+		}
+	})
+
+	It("Should fill the missing fields from the operator defaults", func() {
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.FullName).To(Equal("ab-default-test-bucket"))
+		Expect(bucket.Spec.OnDeletePolicy).To(Equal(BucketOnDeletePolicyDestroy))
+		Expect(bucket.Spec.ManagementPolicy).To(Equal(BucketManagementPolicyCreate))
+		Expect(bucket.Spec.Location).To(Equal("europe-west1"))
+		Expect(bucket.Spec.StorageClass).To(Equal("NEARLINE"))
+		Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+	})
+
+	It("Should keep the fields set by the user", func() {
+		bucket.Spec.FullName = "my-bucket"
+		bucket.Spec.OnDeletePolicy = BucketOnDeletePolicyIgnore
+		bucket.Spec.Location = "us-east1"
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.FullName).To(Equal("my-bucket"))
+		Expect(bucket.Spec.OnDeletePolicy).To(Equal(BucketOnDeletePolicyIgnore))
+		Expect(bucket.Spec.Location).To(Equal("us-east1"))
+		Expect(bucket.Spec.StorageClass).To(Equal("NEARLINE"))
+	})
+
+	It("Should default the retention period of the retain-for on delete policy", func() {
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.RetentionPeriod).To(BeNil())
+
+		bucket.Spec.OnDeletePolicy = BucketOnDeletePolicyRetainFor
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.RetentionPeriod).To(Equal(&metav1.Duration{Duration: 48 * time.Hour}))
+		Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+
+		bucket.Spec.RetentionPeriod = &metav1.Duration{}
+		Expect(validator.ValidateCreate(ctx, bucket)).NotTo(Succeed())
+	})
+
+	It("Should default the archive settings of the archive on delete policy", func() {
+		bucket.Spec.OnDeletePolicy = BucketOnDeletePolicyArchive
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.Archive).To(Equal(&BucketArchive{BucketName: "ab-archive", StorageClass: DefaultArchiveStorageClass}))
+		Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+
+		bucket.Spec.Archive.BucketName = bucket.Spec.FullName
+		Expect(validator.ValidateCreate(ctx, bucket)).NotTo(Succeed())
+
+		bucket.Spec.Archive.BucketName = ""
+		Expect(validator.ValidateCreate(ctx, bucket)).NotTo(Succeed())
+	})
+
+	It("Should not default the location of existing buckets", func() {
+		bucket.CreationTimestamp = metav1.Now()
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.Location).To(BeEmpty())
+		Expect(bucket.Spec.StorageClass).To(BeEmpty())
+	})
+
+	It("Should not default the location of observed buckets", func() {
+		bucket.Spec.ManagementPolicy = BucketManagementPolicyObserveOnly
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.Location).To(BeEmpty())
+		Expect(bucket.Spec.FullName).To(Equal("ab-default-test-bucket"))
+	})
+})
+
+var _ = Describe("Bucket class", func() {
+	ctx := context.Background()
+	var bucket *Bucket
+	var class *BucketClass
+	var defaulter *BucketDefaulter
+	var validator *BucketValidator
+
+	BeforeEach(func() {
+		class = &BucketClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "archive",
+			},
+			Spec: BucketClassSpec{
+				Cloud:          BucketCloudGCP,
+				OnDeletePolicy: BucketOnDeletePolicyDestroy,
+				Location:       "eu",
+				StorageClass:   "ARCHIVE",
+				Lifecycle:      &BucketLifecycle{DeleteAfterDays: 3650},
+				Encryption:     &BucketEncryption{KMSKeyName: "projects/p/locations/eu/keyRings/r/cryptoKeys/k"},
+			},
+		}
+		bucket = &Bucket{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-bucket",
+				Namespace: "default",
+			},
+			Spec: BucketSpec{
+				BucketClassName: "archive",
+				StorageClass:    "COLDLINE",
+			},
+		}
+
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).To(Succeed())
+		reader := fake.NewFakeClientWithScheme(scheme, class)
+		defaulter = &BucketDefaulter{
+			Client: reader,
+			Defaults: BucketDefaults{
+				OnDeletePolicy:  BucketOnDeletePolicyIgnore,
+				RetentionPeriod: DefaultRetentionPeriod,
+				NamePrefix:      "ab",
+			},
+		}
+		validator = &BucketValidator{Client: reader}
+	})
+
+	It("Should fill the empty fields from the bucket class", func() {
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.Cloud).To(Equal(BucketCloudGCP))
+		Expect(bucket.Spec.FullName).To(Equal("ab-default-test-bucket"))
+		Expect(bucket.Spec.OnDeletePolicy).To(Equal(BucketOnDeletePolicyDestroy))
+		Expect(bucket.Spec.Location).To(Equal("eu"))
+		Expect(bucket.Spec.StorageClass).To(Equal("COLDLINE"))
+		Expect(bucket.Spec.Lifecycle).To(Equal(class.Spec.Lifecycle))
+		Expect(bucket.Spec.Encryption).To(Equal(class.Spec.Encryption))
+		Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+	})
+
+	It("Should not apply the bucket class to existing buckets", func() {
+		bucket.CreationTimestamp = metav1.Now()
+		defaulter.Default(ctx, bucket)
+		Expect(bucket.Spec.Cloud).To(BeEmpty())
+		Expect(bucket.Spec.Location).To(BeEmpty())
+	})
+
+	It("Should reject an unknown bucket class", func() {
+		bucket.Spec.BucketClassName = "unknown"
+		bucket.Spec.Cloud = BucketCloudGCP
+		defaulter.Default(ctx, bucket)
+		Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("spec.bucketClassName")))
+	})
+
+	It("Should reject a bucket without cloud", func() {
+		class.Spec.Cloud = ""
+		bucket.ApplyClass(class)
+		Expect(validator.ValidateCreate(ctx, bucket)).To(MatchError(ContainSubstring("spec.cloud: Required value")))
+	})
+})
+
+var _ = Describe("Bucket admission", func() {
+	ctx := context.Background()
+	var defaulter *BucketDefaulter
+	var validator *BucketValidator
+
+	request := func(operation admissionv1beta1.Operation, bucket *Bucket, old *Bucket) admission.Request {
+		req := admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{Operation: operation}}
+		raw, err := json.Marshal(bucket)
+		Expect(err).NotTo(HaveOccurred())
+		req.Object = runtime.RawExtension{Raw: raw}
+		if old != nil {
+			raw, err := json.Marshal(old)
+			Expect(err).NotTo(HaveOccurred())
+			req.OldObject = runtime.RawExtension{Raw: raw}
+		}
+		return req
+	}
+	newBucket := func() *Bucket {
+		return &Bucket{
+			TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "Bucket"},
+			ObjectMeta: metav1.ObjectMeta{Name: "test-bucket", Namespace: "default"},
+			Spec:       BucketSpec{Cloud: BucketCloudGCP},
+		}
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).To(Succeed())
+		decoder, err := admission.NewDecoder(scheme)
+		Expect(err).NotTo(HaveOccurred())
+
+		defaulter = &BucketDefaulter{Defaults: BucketDefaults{OnDeletePolicy: BucketOnDeletePolicyDestroy, NamePrefix: "ab"}}
+		Expect(defaulter.InjectDecoder(decoder)).To(Succeed())
+		validator = &BucketValidator{}
+		Expect(validator.InjectDecoder(decoder)).To(Succeed())
+	})
+
+	It("Should patch the defaulted fields", func() {
+		resp := defaulter.Handle(ctx, request(admissionv1beta1.Create, newBucket(), nil))
+		Expect(resp.Allowed).To(BeTrue())
+		patched := map[string]interface{}{}
+		for _, patch := range resp.Patches {
+			patched[patch.Path] = patch.Value
+		}
+		Expect(patched).To(HaveKeyWithValue("/spec/fullName", "ab-default-test-bucket"))
+		Expect(patched).To(HaveKeyWithValue("/spec/onDeletePolicy", string(BucketOnDeletePolicyDestroy)))
+	})
+
+	It("Should deny the invalid buckets", func() {
+		bucket := newBucket()
+		resp := validator.Handle(ctx, request(admissionv1beta1.Create, bucket, nil))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(string(resp.Result.Reason)).To(ContainSubstring("spec.fullName"))
+
+		defaulter.Default(ctx, bucket)
+		resp = validator.Handle(ctx, request(admissionv1beta1.Create, bucket, nil))
+		Expect(resp.Allowed).To(BeTrue())
+
+		updated := bucket.DeepCopy()
+		updated.Spec.FullName = "ab-other"
+		resp = validator.Handle(ctx, request(admissionv1beta1.Update, updated, bucket))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(string(resp.Result.Reason)).To(ContainSubstring("spec.fullName"))
+	})
+})
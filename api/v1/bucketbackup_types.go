@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketBackupDestination is the location of a bucket backup
+type BucketBackupDestination struct {
+	// BucketName is the destination storage bucket full name, a managed Bucket of the namespace or an allowed backup bucket
+	// +kubebuilder:validation:MinLength=1
+	BucketName string `json:"bucketName"`
+
+	// Prefix is prepended to the backup prefix "{namespace}/{backup name}/" in the destination bucket
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// BucketBackupSpec defines the desired state of BucketBackup
+type BucketBackupSpec struct {
+	// BucketName is the name of the backed up Bucket in the namespace
+	// +kubebuilder:validation:MinLength=1
+	BucketName string `json:"bucketName"`
+
+	// Destination is the location of the backup
+	Destination BucketBackupDestination `json:"destination"`
+
+	// BaseBackupName is the name of a completed BucketBackup of the namespace with the same destination bucket.
+	// The objects unchanged since the base backup are copied from it instead of the source bucket. Full backup if empty
+	// +optional
+	BaseBackupName string `json:"baseBackupName,omitempty"`
+}
+
+type BucketBackupPhase string
+
+const (
+	// BucketBackupPhasePending the backup waits for the source bucket
+	BucketBackupPhasePending BucketBackupPhase = "Pending"
+	// BucketBackupPhaseRunning the objects are being copied
+	BucketBackupPhaseRunning BucketBackupPhase = "Running"
+	// BucketBackupPhaseCompleted all the objects were copied
+	BucketBackupPhaseCompleted BucketBackupPhase = "Completed"
+	// BucketBackupPhaseFailed the backup completed with object copy failures, or its bucket can't be backed up
+	BucketBackupPhaseFailed BucketBackupPhase = "Failed"
+)
+
+// BucketBackupStatus defines the observed state of BucketBackup
+type BucketBackupStatus struct {
+	// Phase of the backup, one of Pending, Running, Completed, Failed
+	// +optional
+	Phase BucketBackupPhase `json:"phase,omitempty"`
+
+	// Message is a human readable message indicating details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// BucketName is the destination storage bucket full name
+	// +optional
+	BucketName string `json:"bucketName,omitempty"`
+
+	// Prefix is the prefix of the backed up objects in the destination bucket
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// BasePrefix is the prefix of the base backup in the destination bucket, empty for a full backup
+	// +optional
+	BasePrefix string `json:"basePrefix,omitempty"`
+
+	// StartedAt is the time the backup started
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// CompletedAt is the time all the objects were processed
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// ObjectsCopied is the number of objects copied from the source bucket
+	// +optional
+	ObjectsCopied int64 `json:"objectsCopied,omitempty"`
+
+	// ObjectsUnchanged is the number of objects unchanged since the base backup, copied from it
+	// +optional
+	ObjectsUnchanged int64 `json:"objectsUnchanged,omitempty"`
+
+	// BytesCopied is the size of the backed up objects
+	// +optional
+	BytesCopied int64 `json:"bytesCopied,omitempty"`
+
+	// Failures is the number of objects that couldn't be copied
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+
+	// LastFailure is the last object copy error
+	// +optional
+	LastFailure string `json:"lastFailure,omitempty"`
+
+	// LastObject is the name of the last processed object, the backup resumes after it
+	// +optional
+	LastObject string `json:"lastObject,omitempty"`
+}
+
+// Finished checks if the backup completed, successfully or not
+func (s *BucketBackupStatus) Finished() bool {
+	return s.Phase == BucketBackupPhaseCompleted || s.Phase == BucketBackupPhaseFailed
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Bucket",type=string,JSONPath=`.spec.bucketName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Objects",type=integer,JSONPath=`.status.objectsCopied`
+// +kubebuilder:printcolumn:name="Failures",type=integer,JSONPath=`.status.failures`
+// +kubebuilder:printcolumn:name="Destination",type=string,JSONPath=`.status.prefix`,priority=1
+
+// BucketBackup is the Schema for the bucketbackups API, a one-shot copy of the objects of a Bucket
+type BucketBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketBackupSpec   `json:"spec,omitempty"`
+	Status BucketBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketBackupList contains a list of BucketBackup
+type BucketBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketBackup{}, &BucketBackupList{})
+}
@@ -0,0 +1,115 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultBackupScheduleKeep is the default number of backups kept by a schedule
+const DefaultBackupScheduleKeep = 7
+
+// BucketBackupScheduleSpec defines the desired state of BucketBackupSchedule
+type BucketBackupScheduleSpec struct {
+	// Schedule is the backups cron schedule, e.g. "0 3 * * *"
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// BucketName is the name of the backed up Bucket in the namespace
+	// +kubebuilder:validation:MinLength=1
+	BucketName string `json:"bucketName"`
+
+	// Destination is the location of the backups
+	Destination BucketBackupDestination `json:"destination"`
+
+	// Incremental copies the objects unchanged since the latest completed backup from it instead of the source bucket
+	// +optional
+	Incremental bool `json:"incremental,omitempty"`
+
+	// Keep is the number of finished backups kept, the older ones are deleted with their objects. Default: 7
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Keep *int32 `json:"keep,omitempty"`
+
+	// Suspend stops scheduling new backups
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// DeletionPolicy defines what happens to the backups when the schedule is deleted.
+	// Valid options: Retain (default) keeps them, Delete deletes them with their objects
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +optional
+	DeletionPolicy BackupScheduleDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// BackupScheduleDeletionPolicy describes what happens to the backups of a deleted schedule
+type BackupScheduleDeletionPolicy string
+
+const (
+	// BackupScheduleDeletionPolicyRetain keeps the backups of the deleted schedule
+	BackupScheduleDeletionPolicyRetain BackupScheduleDeletionPolicy = "Retain"
+	// BackupScheduleDeletionPolicyDelete deletes the backups of the deleted schedule, with their objects
+	BackupScheduleDeletionPolicyDelete BackupScheduleDeletionPolicy = "Delete"
+)
+
+// BucketBackupScheduleStatus defines the observed state of BucketBackupSchedule
+type BucketBackupScheduleStatus struct {
+	// LastScheduleTime is the time of the latest scheduled backup
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastBackupName is the name of the latest scheduled BucketBackup
+	// +optional
+	LastBackupName string `json:"lastBackupName,omitempty"`
+
+	// LastCompletedBackupName is the name of the latest completed BucketBackup, the base of incremental backups
+	// +optional
+	LastCompletedBackupName string `json:"lastCompletedBackupName,omitempty"`
+
+	// Message is a human readable message, e.g. an invalid schedule
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Bucket",type=string,JSONPath=`.spec.bucketName`
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+// +kubebuilder:printcolumn:name="Last Schedule",type=date,JSONPath=`.status.lastScheduleTime`
+
+// BucketBackupSchedule is the Schema for the bucketbackupschedules API, creating BucketBackups on a cron schedule
+type BucketBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketBackupScheduleSpec   `json:"spec,omitempty"`
+	Status BucketBackupScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketBackupScheduleList contains a list of BucketBackupSchedule
+type BucketBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketBackupSchedule{}, &BucketBackupScheduleList{})
+}
@@ -0,0 +1,96 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketClaimSpec defines the desired state of BucketClaim
+type BucketClaimSpec struct {
+	// Cloud platform. Required unless set by the bucket class
+	// +kubebuilder:validation:Enum=gcp
+	// +optional
+	Cloud BucketCloud `json:"cloud,omitempty"`
+
+	// BucketClassName is the name of the BucketClass of the provisioned Bucket
+	// +optional
+	BucketClassName string `json:"bucketClassName,omitempty"`
+
+	// BucketName binds the claim to an existing Bucket of the provisioning namespace instead of provisioning a new one
+	// +optional
+	BucketName string `json:"bucketName,omitempty"`
+}
+
+type BucketClaimPhase string
+
+const (
+	// BucketClaimPhasePending the claim is not yet bound
+	BucketClaimPhasePending BucketClaimPhase = "Pending"
+	// BucketClaimPhaseBound the claim is bound to a Bucket
+	BucketClaimPhaseBound BucketClaimPhase = "Bound"
+	// BucketClaimPhaseLost the bound Bucket doesn't exist anymore
+	BucketClaimPhaseLost BucketClaimPhase = "Lost"
+)
+
+// BucketClaimStatus defines the observed state of BucketClaim
+type BucketClaimStatus struct {
+	// Phase of the claim, one of Pending, Bound, Lost
+	// +optional
+	Phase BucketClaimPhase `json:"phase,omitempty"`
+
+	// Message is a human readable message indicating details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// BucketName is the name of the bound Bucket in the provisioning namespace
+	// +optional
+	BucketName string `json:"bucketName,omitempty"`
+
+	// FullName is the cloud storage bucket full name of the bound Bucket
+	// +optional
+	FullName string `json:"fullName,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Bucket",type=string,JSONPath=`.status.bucketName`
+// +kubebuilder:printcolumn:name="FullName",type=string,JSONPath=`.status.fullName`
+// +kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.spec.bucketClassName`,priority=1
+
+// BucketClaim is the Schema for the bucketclaims API, a request for a Bucket provisioned by the operator
+type BucketClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketClaimSpec   `json:"spec,omitempty"`
+	Status BucketClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketClaimList contains a list of BucketClaim
+type BucketClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketClaim{}, &BucketClaimList{})
+}
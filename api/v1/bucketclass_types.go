@@ -0,0 +1,90 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketClassSpec defines the bucket settings provided by a BucketClass
+type BucketClassSpec struct {
+	// Cloud platform
+	// +kubebuilder:validation:Enum=gcp
+	// +optional
+	Cloud BucketCloud `json:"cloud,omitempty"`
+
+	// OnDeletePolicy defines the behavior when the workload/Bucket objects are deleted
+	// +kubebuilder:validation:Enum=destroy;ignore;retain-for;archive
+	// +optional
+	OnDeletePolicy BucketOnDeletePolicy `json:"onDeletePolicy,omitempty"`
+
+	// DeletionProtection blocks the storage bucket destruction until it is confirmed
+	// +optional
+	DeletionProtection bool `json:"deletionProtection,omitempty"`
+
+	// RetentionPeriod is the grace period before the storage bucket is destroyed with the retain-for on delete policy
+	// +optional
+	RetentionPeriod *metav1.Duration `json:"retentionPeriod,omitempty"`
+
+	// Archive defines where the objects are copied before the storage bucket is destroyed with the archive on delete policy
+	// +optional
+	Archive *BucketArchive `json:"archive,omitempty"`
+
+	// Location is the cloud storage bucket location
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// StorageClass is the cloud storage bucket default storage class
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Lifecycle defines the cloud storage bucket object lifecycle rules
+	// +optional
+	Lifecycle *BucketLifecycle `json:"lifecycle,omitempty"`
+
+	// Encryption defines the cloud storage bucket default encryption
+	// +optional
+	Encryption *BucketEncryption `json:"encryption,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Cloud",type=string,JSONPath=`.spec.cloud`
+// +kubebuilder:printcolumn:name="Location",type=string,JSONPath=`.spec.location`
+// +kubebuilder:printcolumn:name="StorageClass",type=string,JSONPath=`.spec.storageClass`
+// +kubebuilder:printcolumn:name="OnDeletePolicy",type=string,JSONPath=`.spec.onDeletePolicy`
+
+// BucketClass is the Schema for the bucketclasses API, administrator-defined bucket presets
+type BucketClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BucketClassSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketClassList contains a list of BucketClass
+type BucketClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketClass{}, &BucketClassList{})
+}
@@ -0,0 +1,135 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// BucketClaimNamespaceLabel labels the Buckets provisioned for a claim with the claim namespace
+const BucketClaimNamespaceLabel = "bucketclaim_namespace"
+
+// CountsAgainstQuota checks if the bucket is limited by the namespace quotas,
+// observed buckets are neither managed nor counted
+func (r *Bucket) CountsAgainstQuota() bool {
+	return r.Spec.ManagementPolicy != BucketManagementPolicyObserveOnly
+}
+
+// QuotaNamespace returns the namespace whose quotas limit the bucket. The Buckets provisioned for claims count against
+// the claim namespace, the label is only trusted in the claim namespace where only the administrators create Buckets
+func (r *Bucket) QuotaNamespace(claimNamespace string) string {
+	if claimNamespace != "" && r.Namespace == claimNamespace && r.Labels[BucketClaimNamespaceLabel] != "" {
+		return r.Labels[BucketClaimNamespaceLabel]
+	}
+	return r.Namespace
+}
+
+// ListQuotaBuckets lists the Buckets counted against the quotas of the namespace,
+// including the Buckets of the claim namespace provisioned for the claims of the namespace
+func ListQuotaBuckets(ctx context.Context, reader client.Reader, namespace string, claimNamespace string) ([]Bucket, error) {
+	buckets := &BucketList{}
+	if err := reader.List(ctx, buckets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var items []Bucket
+	for i := range buckets.Items {
+		if buckets.Items[i].QuotaNamespace(claimNamespace) == namespace {
+			items = append(items, buckets.Items[i])
+		}
+	}
+
+	if claimNamespace != "" && claimNamespace != namespace {
+		claimBuckets := &BucketList{}
+		if err := reader.List(ctx, claimBuckets, client.InNamespace(claimNamespace), client.MatchingLabels{BucketClaimNamespaceLabel: namespace}); err != nil {
+			return nil, err
+		}
+		items = append(items, claimBuckets.Items...)
+	}
+
+	return items, nil
+}
+
+// Check returns an error describing the quota violation if the bucket is not allowed by the quota,
+// buckets is the number of the other managed Buckets in the namespace
+func (q *BucketQuota) Check(bucket *Bucket, buckets int32) error {
+	if len(q.Spec.AllowedClouds) > 0 && !containsCloud(q.Spec.AllowedClouds, bucket.Spec.Cloud) {
+		return q.errorf("cloud %q not allowed, allowed clouds: %s", bucket.Spec.Cloud, joinClouds(q.Spec.AllowedClouds))
+	}
+
+	if len(q.Spec.AllowedClasses) > 0 && !containsClass(q.Spec.AllowedClasses, bucket.Spec.BucketClassName) {
+		return q.errorf("bucket class %q not allowed, allowed classes: %s", bucket.Spec.BucketClassName, strings.Join(q.Spec.AllowedClasses, ", "))
+	}
+
+	if q.Spec.MaxBuckets != nil && buckets+1 > *q.Spec.MaxBuckets {
+		return q.errorf("exceeded max buckets: used %d, limited to %d", buckets, *q.Spec.MaxBuckets)
+	}
+
+	if q.Spec.MaxTotalBytes != nil && q.Status.TotalBytes != nil && q.Status.TotalBytes.Cmp(*q.Spec.MaxTotalBytes) >= 0 {
+		return q.errorf("exceeded max total bytes: used %s, limited to %s", q.Status.TotalBytes.String(), q.Spec.MaxTotalBytes.String())
+	}
+
+	return nil
+}
+
+// CheckBucketQuotas checks the bucket against all the namespace quotas, returns the first quota violation
+func CheckBucketQuotas(quotas []BucketQuota, bucket *Bucket, buckets int32) error {
+	if !bucket.CountsAgainstQuota() {
+		return nil
+	}
+
+	for i := range quotas {
+		if err := quotas[i].Check(bucket, buckets); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q *BucketQuota) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("bucket quota %s: %s", q.Name, fmt.Sprintf(format, args...))
+}
+
+func containsCloud(clouds []BucketCloud, cloud BucketCloud) bool {
+	for _, c := range clouds {
+		if c == cloud {
+			return true
+		}
+	}
+	return false
+}
+
+func containsClass(classes []string, class string) bool {
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func joinClouds(clouds []BucketCloud) string {
+	s := make([]string, len(clouds))
+	for i, c := range clouds {
+		s[i] = string(c)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,141 @@
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Bucket quota", func() {
+	ctx := context.Background()
+	var bucket *Bucket
+	var quota *BucketQuota
+	var validator *BucketValidator
+
+	BeforeEach(func() {
+		bucket = &Bucket{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-bucket",
+				Namespace: "default",
+			},
+			Spec: BucketSpec{
+				Cloud:          BucketCloudGCP,
+				FullName:       "ab-default-test-bucket",
+				OnDeletePolicy: BucketOnDeletePolicyIgnore,
+			},
+		}
+		quota = &BucketQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-quota",
+				Namespace: "default",
+			},
+		}
+	})
+
+	It("Should allow buckets within the quota", func() {
+		maxBuckets := int32(2)
+		quota.Spec.MaxBuckets = &maxBuckets
+		quota.Spec.AllowedClouds = []BucketCloud{BucketCloudGCP}
+		Expect(quota.Check(bucket, 1)).To(Succeed())
+	})
+
+	It("Should reject buckets exceeding the max buckets", func() {
+		maxBuckets := int32(2)
+		quota.Spec.MaxBuckets = &maxBuckets
+		Expect(quota.Check(bucket, 2)).To(MatchError("bucket quota test-quota: exceeded max buckets: used 2, limited to 2"))
+	})
+
+	It("Should reject buckets once the max total bytes is reached", func() {
+		maxTotalBytes := resource.MustParse("1Gi")
+		usedBytes := resource.MustParse("2Gi")
+		quota.Spec.MaxTotalBytes = &maxTotalBytes
+		quota.Status.TotalBytes = &usedBytes
+		Expect(quota.Check(bucket, 0)).To(MatchError(ContainSubstring("exceeded max total bytes")))
+	})
+
+	It("Should reject buckets without an allowed class", func() {
+		quota.Spec.AllowedClasses = []string{"archive"}
+		Expect(quota.Check(bucket, 0)).To(MatchError(ContainSubstring(`bucket class "" not allowed`)))
+
+		bucket.Spec.BucketClassName = "archive"
+		Expect(quota.Check(bucket, 0)).To(Succeed())
+	})
+
+	It("Should not limit observed buckets", func() {
+		maxBuckets := int32(0)
+		quota.Spec.MaxBuckets = &maxBuckets
+		bucket.Spec.ManagementPolicy = BucketManagementPolicyObserveOnly
+		Expect(CheckBucketQuotas([]BucketQuota{*quota}, bucket, 0)).To(Succeed())
+	})
+
+	Context("When creating a bucket", func() {
+		BeforeEach(func() {
+			maxBuckets := int32(1)
+			quota.Spec.MaxBuckets = &maxBuckets
+			existing := &Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "existing-bucket",
+					Namespace: "default",
+				},
+			}
+
+			scheme := runtime.NewScheme()
+			Expect(AddToScheme(scheme)).To(Succeed())
+			validator = &BucketValidator{Client: fake.NewFakeClientWithScheme(scheme, quota, existing)}
+		})
+
+		It("Should reject buckets exceeding the namespace quotas", func() {
+			err := validator.ValidateCreate(ctx, bucket)
+			Expect(apierrors.IsForbidden(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("exceeded max buckets")))
+		})
+
+		It("Should allow buckets in other namespaces", func() {
+			bucket.Namespace = "other"
+			Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+		})
+	})
+
+	Context("When provisioning a bucket for a claim", func() {
+		BeforeEach(func() {
+			maxBuckets := int32(1)
+			quota.Spec.MaxBuckets = &maxBuckets
+			claimBucket := &Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "claim-1",
+					Namespace: "claims",
+					Labels:    map[string]string{BucketClaimNamespaceLabel: "default"},
+				},
+			}
+
+			scheme := runtime.NewScheme()
+			Expect(AddToScheme(scheme)).To(Succeed())
+			validator = &BucketValidator{Client: fake.NewFakeClientWithScheme(scheme, quota, claimBucket), ClaimNamespace: "claims"}
+		})
+
+		It("Should count the claim buckets against the claim namespace quotas", func() {
+			buckets, err := ListQuotaBuckets(ctx, validator.Client, "default", "claims")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buckets).To(HaveLen(1))
+
+			bucket.Namespace = "claims"
+			bucket.Labels = map[string]string{BucketClaimNamespaceLabel: "default"}
+			err = validator.ValidateCreate(ctx, bucket)
+			Expect(apierrors.IsForbidden(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("exceeded max buckets")))
+		})
+
+		It("Should only trust the claim namespace label in the claim namespace", func() {
+			bucket.Namespace = "other"
+			bucket.Labels = map[string]string{BucketClaimNamespaceLabel: "default"}
+			Expect(bucket.QuotaNamespace("claims")).To(Equal("other"))
+			Expect(validator.ValidateCreate(ctx, bucket)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,89 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketQuotaSpec defines the bucket limits of a namespace
+type BucketQuotaSpec struct {
+	// MaxBuckets is the maximum number of managed Buckets in the namespace, unlimited if unset
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxBuckets *int32 `json:"maxBuckets,omitempty"`
+
+	// MaxTotalBytes is the maximum total size of the namespace storage buckets,
+	// no more bucket can be created once it is reached. Unlimited if unset
+	// +optional
+	MaxTotalBytes *resource.Quantity `json:"maxTotalBytes,omitempty"`
+
+	// AllowedClouds are the clouds of the namespace buckets, all clouds if empty
+	// +optional
+	AllowedClouds []BucketCloud `json:"allowedClouds,omitempty"`
+
+	// AllowedClasses are the bucket classes of the namespace buckets, buckets without class are rejected if set.
+	// All classes if empty
+	// +optional
+	AllowedClasses []string `json:"allowedClasses,omitempty"`
+}
+
+// BucketQuotaStatus defines the observed usage of a BucketQuota
+type BucketQuotaStatus struct {
+	// Buckets is the number of managed Buckets in the namespace
+	// +optional
+	Buckets int32 `json:"buckets,omitempty"`
+
+	// TotalBytes is the total size of the namespace storage buckets, measured when maxTotalBytes is set
+	// +optional
+	TotalBytes *resource.Quantity `json:"totalBytes,omitempty"`
+
+	// MeasuredAt is the last time the total size was measured
+	// +optional
+	MeasuredAt *metav1.Time `json:"measuredAt,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=bucketquotas
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="MaxBuckets",type=integer,JSONPath=`.spec.maxBuckets`
+// +kubebuilder:printcolumn:name="Buckets",type=integer,JSONPath=`.status.buckets`
+// +kubebuilder:printcolumn:name="MaxTotalBytes",type=string,JSONPath=`.spec.maxTotalBytes`
+// +kubebuilder:printcolumn:name="TotalBytes",type=string,JSONPath=`.status.totalBytes`
+
+// BucketQuota is the Schema for the bucketquotas API, the bucket limits of a namespace
+type BucketQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketQuotaSpec   `json:"spec,omitempty"`
+	Status BucketQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketQuotaList contains a list of BucketQuota
+type BucketQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketQuota{}, &BucketQuotaList{})
+}
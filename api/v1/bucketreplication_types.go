@@ -0,0 +1,147 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketReplicationSpec defines the desired state of BucketReplication
+type BucketReplicationSpec struct {
+	// SourceBucketName is the name of the replicated Bucket in the namespace
+	// +kubebuilder:validation:MinLength=1
+	SourceBucketName string `json:"sourceBucketName"`
+
+	// DestinationBucketName is the name of the Bucket in the namespace receiving the copies, e.g. in another region.
+	// It must be on the same cloud as the source Bucket
+	// +kubebuilder:validation:MinLength=1
+	DestinationBucketName string `json:"destinationBucketName"`
+
+	// Interval is the interval between the sync passes. Default: 5m
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// DeleteRemoved deletes the destination objects removed from the source bucket, making the destination an exact mirror
+	// +optional
+	DeleteRemoved bool `json:"deleteRemoved,omitempty"`
+
+	// Suspend stops the replication
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+type BucketReplicationPhase string
+
+const (
+	// BucketReplicationPhasePending the replication waits for its buckets
+	BucketReplicationPhasePending BucketReplicationPhase = "Pending"
+	// BucketReplicationPhaseSyncing a sync pass is running
+	BucketReplicationPhaseSyncing BucketReplicationPhase = "Syncing"
+	// BucketReplicationPhaseSynced the last sync pass replicated all the objects
+	BucketReplicationPhaseSynced BucketReplicationPhase = "Synced"
+	// BucketReplicationPhaseFailed the replication is invalid or the last sync pass had object replication failures
+	BucketReplicationPhaseFailed BucketReplicationPhase = "Failed"
+	// BucketReplicationPhaseSuspended the replication is suspended
+	BucketReplicationPhaseSuspended BucketReplicationPhase = "Suspended"
+)
+
+// BucketReplicationSyncStatus reports the objects replicated by a sync pass
+type BucketReplicationSyncStatus struct {
+	// StartedAt is the time the sync pass started, the objects changed since may not be replicated yet
+	StartedAt metav1.Time `json:"startedAt"`
+
+	// CompletedAt is the time the sync pass completed
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// ObjectsCompared is the number of compared objects
+	// +optional
+	ObjectsCompared int64 `json:"objectsCompared,omitempty"`
+
+	// ObjectsCopied is the number of new or changed objects copied to the destination
+	// +optional
+	ObjectsCopied int64 `json:"objectsCopied,omitempty"`
+
+	// ObjectsDeleted is the number of destination objects deleted as they were removed from the source
+	// +optional
+	ObjectsDeleted int64 `json:"objectsDeleted,omitempty"`
+
+	// BytesCopied is the size of the copied objects
+	// +optional
+	BytesCopied int64 `json:"bytesCopied,omitempty"`
+
+	// Failures is the number of objects that couldn't be replicated
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+
+	// LastFailure is the last object replication error
+	// +optional
+	LastFailure string `json:"lastFailure,omitempty"`
+
+	// LastObject is the name of the last compared object, the sync pass resumes after it
+	// +optional
+	LastObject string `json:"lastObject,omitempty"`
+}
+
+// BucketReplicationStatus defines the observed state of BucketReplication
+type BucketReplicationStatus struct {
+	// Phase of the replication, one of Pending, Syncing, Synced, Failed, Suspended
+	// +optional
+	Phase BucketReplicationPhase `json:"phase,omitempty"`
+
+	// Message is a human readable message indicating details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// CurrentSync is the running sync pass
+	// +optional
+	CurrentSync *BucketReplicationSyncStatus `json:"currentSync,omitempty"`
+
+	// LastSync is the last completed sync pass
+	// +optional
+	LastSync *BucketReplicationSyncStatus `json:"lastSync,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.sourceBucketName`
+// +kubebuilder:printcolumn:name="Destination",type=string,JSONPath=`.spec.destinationBucketName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSync.startedAt`
+
+// BucketReplication is the Schema for the bucketreplications API, continuously mirroring the objects of a Bucket to another one.
+// Both Buckets must be GCP buckets: the operator only manages GCP buckets, cross-cloud replication (e.g. GCS to S3) is out of scope
+type BucketReplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketReplicationSpec   `json:"spec,omitempty"`
+	Status BucketReplicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketReplicationList contains a list of BucketReplication
+type BucketReplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketReplication `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketReplication{}, &BucketReplicationList{})
+}
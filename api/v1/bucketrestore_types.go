@@ -0,0 +1,146 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketRestoreConflictPolicy defines how a restore handles the objects existing in the target bucket with a different content
+// +kubebuilder:validation:Enum=skip;overwrite;fail
+type BucketRestoreConflictPolicy string
+
+const (
+	// BucketRestoreConflictPolicySkip keeps the existing objects
+	BucketRestoreConflictPolicySkip BucketRestoreConflictPolicy = "skip"
+	// BucketRestoreConflictPolicyOverwrite replaces the existing objects
+	BucketRestoreConflictPolicyOverwrite BucketRestoreConflictPolicy = "overwrite"
+	// BucketRestoreConflictPolicyFail stops the restore on the first existing object
+	BucketRestoreConflictPolicyFail BucketRestoreConflictPolicy = "fail"
+)
+
+// BucketRestoreSpec defines the desired state of BucketRestore
+type BucketRestoreSpec struct {
+	// BucketName is the name of the target Bucket in the namespace
+	// +kubebuilder:validation:MinLength=1
+	BucketName string `json:"bucketName"`
+
+	// BackupName is the name of the restored completed BucketBackup in the namespace. Exclusive with pointInTime
+	// +optional
+	BackupName string `json:"backupName,omitempty"`
+
+	// PointInTime restores the target bucket object versions live at that time, the storage bucket must be versioned.
+	// Exclusive with backupName
+	// +optional
+	PointInTime *metav1.Time `json:"pointInTime,omitempty"`
+
+	// ConflictPolicy defines how the objects existing in the target bucket with a different content are handled.
+	// Defaults to overwrite for point in time restores, skip for backup restores
+	// +optional
+	ConflictPolicy BucketRestoreConflictPolicy `json:"conflictPolicy,omitempty"`
+}
+
+type BucketRestorePhase string
+
+const (
+	// BucketRestorePhasePending the restore waits for its target bucket or backup
+	BucketRestorePhasePending BucketRestorePhase = "Pending"
+	// BucketRestorePhaseRunning the objects are being restored
+	BucketRestorePhaseRunning BucketRestorePhase = "Running"
+	// BucketRestorePhaseCompleted all the objects were restored
+	BucketRestorePhaseCompleted BucketRestorePhase = "Completed"
+	// BucketRestorePhaseFailed the restore is invalid, stopped on a conflict or completed with object restore failures
+	BucketRestorePhaseFailed BucketRestorePhase = "Failed"
+)
+
+// BucketRestoreStatus defines the observed state of BucketRestore
+type BucketRestoreStatus struct {
+	// Phase of the restore, one of Pending, Running, Completed, Failed
+	// +optional
+	Phase BucketRestorePhase `json:"phase,omitempty"`
+
+	// Message is a human readable message indicating details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// StartedAt is the time the restore started
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// CompletedAt is the time the restore finished
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// ObjectsRestored is the number of restored objects
+	// +optional
+	ObjectsRestored int64 `json:"objectsRestored,omitempty"`
+
+	// ObjectsSkipped is the number of objects left alone: unchanged, or existing with the skip conflict policy
+	// +optional
+	ObjectsSkipped int64 `json:"objectsSkipped,omitempty"`
+
+	// BytesRestored is the size of the restored objects
+	// +optional
+	BytesRestored int64 `json:"bytesRestored,omitempty"`
+
+	// Failures is the number of objects that couldn't be restored
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+
+	// LastFailure is the last object restore error
+	// +optional
+	LastFailure string `json:"lastFailure,omitempty"`
+
+	// LastObject is the name of the last processed object, the restore resumes after it
+	// +optional
+	LastObject string `json:"lastObject,omitempty"`
+}
+
+// Finished checks if the restore finished, successfully or not
+func (s *BucketRestoreStatus) Finished() bool {
+	return s.Phase == BucketRestorePhaseCompleted || s.Phase == BucketRestorePhaseFailed
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Bucket",type=string,JSONPath=`.spec.bucketName`
+// +kubebuilder:printcolumn:name="Backup",type=string,JSONPath=`.spec.backupName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Restored",type=integer,JSONPath=`.status.objectsRestored`
+// +kubebuilder:printcolumn:name="Point In Time",type=date,JSONPath=`.spec.pointInTime`,priority=1
+
+// BucketRestore is the Schema for the bucketrestores API, restoring a BucketBackup or a point in time into a Bucket
+type BucketRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketRestoreSpec   `json:"spec,omitempty"`
+	Status BucketRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BucketRestoreList contains a list of BucketRestore
+type BucketRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketRestore{}, &BucketRestoreList{})
+}
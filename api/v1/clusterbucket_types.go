@@ -0,0 +1,149 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterBucketSpec defines the desired state of ClusterBucket
+type ClusterBucketSpec struct {
+	// Cloud platform. Required unless set by the bucket class
+	// +kubebuilder:validation:Enum=gcp
+	// +optional
+	Cloud BucketCloud `json:"cloud,omitempty"`
+
+	// BucketClassName is the name of the BucketClass providing the defaults of the empty fields
+	// +optional
+	BucketClassName string `json:"bucketClassName,omitempty"`
+
+	// FullName is the cloud storage bucket full name. Defaults to the operator bucket name template with the "cluster" namespace
+	// +optional
+	FullName string `json:"fullName,omitempty"`
+
+	// OnDeletePolicy defines the behavior when the ClusterBucket object is deleted. Defaults to the operator on delete policy
+	// +kubebuilder:validation:Enum=destroy;ignore;retain-for;archive
+	// +optional
+	OnDeletePolicy BucketOnDeletePolicy `json:"onDeletePolicy,omitempty"`
+
+	// Location is the cloud storage bucket location, the cloud default if empty
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// StorageClass is the cloud storage bucket default storage class, the cloud default if empty
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// ManagementPolicy defines how the operator manages the cloud storage bucket. Defaults to create
+	// +kubebuilder:validation:Enum=create;adopt;observe-only
+	// +optional
+	ManagementPolicy BucketManagementPolicy `json:"managementPolicy,omitempty"`
+
+	// AllowedNamespaces are the namespaces allowed to bind the ClusterBucket, "*" allows all namespaces
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// MaxAccess is the highest access the bindings can grant. Defaults to read-only
+	// +kubebuilder:validation:Enum=read-only;read-write
+	// +optional
+	MaxAccess BucketAccess `json:"maxAccess,omitempty"`
+
+	// AllowedServiceAccounts are the service accounts the bindings can grant access to, an email or "*@<domain>" for
+	// all the service accounts of a domain. Defaults to the service accounts of the operator GCP project
+	// +optional
+	AllowedServiceAccounts []string `json:"allowedServiceAccounts,omitempty"`
+}
+
+// ClusterBucketStatus defines the observed state of ClusterBucket
+type ClusterBucketStatus struct {
+	// BucketName is the name of the backing Bucket in the provisioning namespace
+	// +optional
+	BucketName string `json:"bucketName,omitempty"`
+
+	// Cloud is the cloud platform of the storage bucket, set directly or by the bucket class
+	// +optional
+	Cloud BucketCloud `json:"cloud,omitempty"`
+
+	// FullName is the cloud storage bucket full name
+	// +optional
+	FullName string `json:"fullName,omitempty"`
+
+	// CreatedAt is the cloud storage bucket creation time
+	// +optional
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cloud",type=string,JSONPath=`.status.cloud`
+// +kubebuilder:printcolumn:name="FullName",type=string,JSONPath=`.status.fullName`
+// +kubebuilder:printcolumn:name="CreatedAt",type=string,JSONPath=`.status.createdAt`
+
+// ClusterBucket is the Schema for the clusterbuckets API, a bucket shared by several namespaces
+type ClusterBucket struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterBucketSpec   `json:"spec,omitempty"`
+	Status ClusterBucketStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterBucketList contains a list of ClusterBucket
+type ClusterBucketList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterBucket `json:"items"`
+}
+
+// AllowsNamespace checks if the namespace is allowed to bind the cluster bucket
+func (r *ClusterBucket) AllowsNamespace(namespace string) bool {
+	for _, ns := range r.Spec.AllowedNamespaces {
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAccess checks if the bindings can grant the access
+func (r *ClusterBucket) AllowsAccess(access BucketAccess) bool {
+	return access != BucketAccessReadWrite || r.Spec.MaxAccess == BucketAccessReadWrite
+}
+
+// AllowsServiceAccount checks if the bindings can grant access to the service account,
+// defaults are the allowed service accounts if the cluster bucket doesn't set them
+func (r *ClusterBucket) AllowsServiceAccount(serviceAccount string, defaults []string) bool {
+	allowed := r.Spec.AllowedServiceAccounts
+	if len(allowed) == 0 {
+		allowed = defaults
+	}
+	for _, sa := range allowed {
+		if sa == serviceAccount || (strings.HasPrefix(sa, "*@") && strings.HasSuffix(serviceAccount, sa[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterBucket{}, &ClusterBucketList{})
+}
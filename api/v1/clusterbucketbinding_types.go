@@ -0,0 +1,117 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterBucketBindingSpec defines the desired state of ClusterBucketBinding
+type ClusterBucketBindingSpec struct {
+	// ClusterBucketName is the name of the bound ClusterBucket
+	// +kubebuilder:validation:MinLength=1
+	ClusterBucketName string `json:"clusterBucketName"`
+
+	// SecretName is the name of the connection Secret created in the binding namespace. Defaults to the binding name
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// ServiceAccount is the cloud identity of the namespace workloads granted access to the storage bucket,
+	// e.g. a GCP service account email. No access is granted if empty
+	// +optional
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+
+	// Access is the access level granted to the service account. Defaults to read-only
+	// +kubebuilder:validation:Enum=read-only;read-write
+	// +optional
+	Access BucketAccess `json:"access,omitempty"`
+}
+
+type BucketAccess string
+
+const (
+	// BucketAccessReadOnly read the storage bucket objects
+	BucketAccessReadOnly BucketAccess = "read-only"
+	// BucketAccessReadWrite read, write and delete the storage bucket objects
+	BucketAccessReadWrite BucketAccess = "read-write"
+)
+
+type ClusterBucketBindingPhase string
+
+const (
+	// ClusterBucketBindingPhasePending the cluster bucket doesn't exist or its storage bucket is not yet created
+	ClusterBucketBindingPhasePending ClusterBucketBindingPhase = "Pending"
+	// ClusterBucketBindingPhaseBound the connection secret is created and the access granted
+	ClusterBucketBindingPhaseBound ClusterBucketBindingPhase = "Bound"
+	// ClusterBucketBindingPhaseDenied the binding namespace is not allowed by the cluster bucket
+	ClusterBucketBindingPhaseDenied ClusterBucketBindingPhase = "Denied"
+	// ClusterBucketBindingPhaseFailed the binding access or service account is not allowed by the cluster bucket
+	ClusterBucketBindingPhaseFailed ClusterBucketBindingPhase = "Failed"
+)
+
+// ClusterBucketBindingStatus defines the observed state of ClusterBucketBinding
+type ClusterBucketBindingStatus struct {
+	// Phase of the binding, one of Pending, Bound, Denied, Failed
+	// +optional
+	Phase ClusterBucketBindingPhase `json:"phase,omitempty"`
+
+	// Message is a human readable message indicating details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// FullName is the cloud storage bucket full name of the bound ClusterBucket
+	// +optional
+	FullName string `json:"fullName,omitempty"`
+
+	// GrantedServiceAccount is the service account granted access to the storage bucket,
+	// the access is revoked when it changes or the binding is deleted
+	// +optional
+	GrantedServiceAccount string `json:"grantedServiceAccount,omitempty"`
+
+	// GrantedAccess is the access level granted to the service account
+	// +optional
+	GrantedAccess BucketAccess `json:"grantedAccess,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="ClusterBucket",type=string,JSONPath=`.spec.clusterBucketName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="FullName",type=string,JSONPath=`.status.fullName`
+// +kubebuilder:printcolumn:name="ServiceAccount",type=string,JSONPath=`.spec.serviceAccount`,priority=1
+
+// ClusterBucketBinding is the Schema for the clusterbucketbindings API, grants a namespace access to a ClusterBucket
+type ClusterBucketBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterBucketBindingSpec   `json:"spec,omitempty"`
+	Status ClusterBucketBindingStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterBucketBindingList contains a list of ClusterBucketBinding
+type ClusterBucketBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterBucketBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterBucketBinding{}, &ClusterBucketBindingList{})
+}
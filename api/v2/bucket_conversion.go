@@ -0,0 +1,272 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// v1CreatedAtAnnotation keeps a v1 creation time that can't be parsed, so that it survives a round trip
+const v1CreatedAtAnnotation = "ab.leclouddev.com/v1-created-at"
+
+var _ conversion.Convertible = &Bucket{}
+
+// ConvertTo converts this Bucket to the Hub version (v1)
+func (src *Bucket) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*abv1.Bucket)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Cloud = abv1.BucketCloud(src.Spec.Cloud)
+	dst.Spec.BucketClassName = src.Spec.BucketClassName
+	dst.Spec.FullName = src.Spec.FullName
+	dst.Spec.Location = src.Spec.Storage.Location
+	dst.Spec.StorageClass = src.Spec.Storage.StorageClass
+	dst.Spec.Lifecycle = nil
+	if src.Spec.Storage.Lifecycle != nil {
+		dst.Spec.Lifecycle = &abv1.BucketLifecycle{DeleteAfterDays: src.Spec.Storage.Lifecycle.DeleteAfterDays}
+		for _, t := range src.Spec.Storage.Lifecycle.Transitions {
+			dst.Spec.Lifecycle.Transitions = append(dst.Spec.Lifecycle.Transitions, abv1.BucketLifecycleTransition{
+				AfterDays:    t.AfterDays,
+				StorageClass: t.StorageClass,
+			})
+		}
+	}
+	dst.Spec.Encryption = nil
+	if src.Spec.Encryption != nil {
+		dst.Spec.Encryption = &abv1.BucketEncryption{KMSKeyName: src.Spec.Encryption.KMSKeyName}
+	}
+	dst.Spec.ClaimRef = src.Spec.ClaimRef
+	dst.Spec.Source = nil
+	if src.Spec.Source != nil {
+		dst.Spec.Source = &abv1.BucketSource{BucketName: src.Spec.Source.BucketName, BackupName: src.Spec.Source.BackupName}
+	}
+	dst.Spec.OnDeletePolicy = abv1.BucketOnDeletePolicy(src.Spec.Policies.OnDelete)
+	dst.Spec.DeletionProtection = src.Spec.Policies.DeletionProtection
+	dst.Spec.RetentionPeriod = src.Spec.Policies.RetentionPeriod
+	dst.Spec.Archive = nil
+	if src.Spec.Policies.Archive != nil {
+		dst.Spec.Archive = &abv1.BucketArchive{
+			BucketName:   src.Spec.Policies.Archive.BucketName,
+			Prefix:       src.Spec.Policies.Archive.Prefix,
+			StorageClass: src.Spec.Policies.Archive.StorageClass,
+		}
+	}
+	dst.Spec.ManagementPolicy = abv1.BucketManagementPolicy(src.Spec.Policies.Management)
+
+	dst.Status.CreatedAt = ""
+	if src.Status.CreatedAt != nil {
+		dst.Status.CreatedAt = src.Status.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	if createdAt, ok := src.Annotations[v1CreatedAtAnnotation]; ok {
+		dst.Annotations = copyAnnotationsWithout(src.Annotations, v1CreatedAtAnnotation)
+		dst.Status.CreatedAt = createdAt
+	}
+
+	dst.Status.Attributes = nil
+	if src.Status.Attributes != nil {
+		dst.Status.Attributes = &abv1.BucketAttributes{
+			Location:     src.Status.Attributes.Location,
+			StorageClass: src.Status.Attributes.StorageClass,
+			Labels:       src.Status.Attributes.Labels,
+		}
+	}
+
+	dst.Status.Conditions = nil
+	for _, c := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, abv1.BucketCondition{
+			Type:               abv1.BucketConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+
+	dst.Status.Archive = nil
+	if src.Status.Archive != nil {
+		dst.Status.Archive = &abv1.BucketArchiveStatus{
+			BucketName:    src.Status.Archive.BucketName,
+			Prefix:        src.Status.Archive.Prefix,
+			StartedAt:     src.Status.Archive.StartedAt,
+			CompletedAt:   src.Status.Archive.CompletedAt,
+			ObjectsCopied: src.Status.Archive.ObjectsCopied,
+			BytesCopied:   src.Status.Archive.BytesCopied,
+			Failures:      src.Status.Archive.Failures,
+			LastFailure:   src.Status.Archive.LastFailure,
+			LastObject:    src.Status.Archive.LastObject,
+		}
+	}
+
+	dst.Status.Deletion = nil
+	if src.Status.Deletion != nil {
+		dst.Status.Deletion = &abv1.BucketDeletionStatus{
+			StartedAt:      src.Status.Deletion.StartedAt,
+			ObjectsDeleted: src.Status.Deletion.ObjectsDeleted,
+			BytesDeleted:   src.Status.Deletion.BytesDeleted,
+		}
+	}
+
+	dst.Status.Seed = nil
+	if src.Status.Seed != nil {
+		dst.Status.Seed = &abv1.BucketSeedStatus{
+			StartedAt:     src.Status.Seed.StartedAt,
+			CompletedAt:   src.Status.Seed.CompletedAt,
+			ObjectsCopied: src.Status.Seed.ObjectsCopied,
+			BytesCopied:   src.Status.Seed.BytesCopied,
+			Failures:      src.Status.Seed.Failures,
+			LastFailure:   src.Status.Seed.LastFailure,
+			LastObject:    src.Status.Seed.LastObject,
+		}
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version
+func (dst *Bucket) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*abv1.Bucket)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Cloud = BucketCloud(src.Spec.Cloud)
+	dst.Spec.BucketClassName = src.Spec.BucketClassName
+	dst.Spec.FullName = src.Spec.FullName
+	dst.Spec.Storage = BucketStorage{
+		Location:     src.Spec.Location,
+		StorageClass: src.Spec.StorageClass,
+	}
+	if src.Spec.Lifecycle != nil {
+		dst.Spec.Storage.Lifecycle = &BucketLifecycle{DeleteAfterDays: src.Spec.Lifecycle.DeleteAfterDays}
+		for _, t := range src.Spec.Lifecycle.Transitions {
+			dst.Spec.Storage.Lifecycle.Transitions = append(dst.Spec.Storage.Lifecycle.Transitions, BucketLifecycleTransition{
+				AfterDays:    t.AfterDays,
+				StorageClass: t.StorageClass,
+			})
+		}
+	}
+	dst.Spec.Encryption = nil
+	if src.Spec.Encryption != nil {
+		dst.Spec.Encryption = &BucketEncryption{KMSKeyName: src.Spec.Encryption.KMSKeyName}
+	}
+	dst.Spec.ClaimRef = src.Spec.ClaimRef
+	dst.Spec.Source = nil
+	if src.Spec.Source != nil {
+		dst.Spec.Source = &BucketSource{BucketName: src.Spec.Source.BucketName, BackupName: src.Spec.Source.BackupName}
+	}
+	dst.Spec.Policies = BucketPolicies{
+		OnDelete:           BucketOnDeletePolicy(src.Spec.OnDeletePolicy),
+		DeletionProtection: src.Spec.DeletionProtection,
+		RetentionPeriod:    src.Spec.RetentionPeriod,
+		Management:         BucketManagementPolicy(src.Spec.ManagementPolicy),
+	}
+	if src.Spec.Archive != nil {
+		dst.Spec.Policies.Archive = &BucketArchive{
+			BucketName:   src.Spec.Archive.BucketName,
+			Prefix:       src.Spec.Archive.Prefix,
+			StorageClass: src.Spec.Archive.StorageClass,
+		}
+	}
+
+	dst.Status.CreatedAt = nil
+	if src.Status.CreatedAt != "" {
+		createdAt, err := time.Parse(time.RFC3339, src.Status.CreatedAt)
+		if err == nil && createdAt.UTC().Format(time.RFC3339) == src.Status.CreatedAt {
+			t := metav1.NewTime(createdAt)
+			dst.Status.CreatedAt = &t
+		} else {
+			// keep the original value, the conversion must not lose information
+			dst.Annotations = copyAnnotationsWithout(src.Annotations, "")
+			dst.Annotations[v1CreatedAtAnnotation] = src.Status.CreatedAt
+		}
+	}
+
+	dst.Status.Attributes = nil
+	if src.Status.Attributes != nil {
+		dst.Status.Attributes = &BucketAttributes{
+			Location:     src.Status.Attributes.Location,
+			StorageClass: src.Status.Attributes.StorageClass,
+			Labels:       src.Status.Attributes.Labels,
+		}
+	}
+
+	dst.Status.Conditions = nil
+	for _, c := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, BucketCondition{
+			Type:               BucketConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+
+	dst.Status.Archive = nil
+	if src.Status.Archive != nil {
+		dst.Status.Archive = &BucketArchiveStatus{
+			BucketName:    src.Status.Archive.BucketName,
+			Prefix:        src.Status.Archive.Prefix,
+			StartedAt:     src.Status.Archive.StartedAt,
+			CompletedAt:   src.Status.Archive.CompletedAt,
+			ObjectsCopied: src.Status.Archive.ObjectsCopied,
+			BytesCopied:   src.Status.Archive.BytesCopied,
+			Failures:      src.Status.Archive.Failures,
+			LastFailure:   src.Status.Archive.LastFailure,
+			LastObject:    src.Status.Archive.LastObject,
+		}
+	}
+
+	dst.Status.Deletion = nil
+	if src.Status.Deletion != nil {
+		dst.Status.Deletion = &BucketDeletionStatus{
+			StartedAt:      src.Status.Deletion.StartedAt,
+			ObjectsDeleted: src.Status.Deletion.ObjectsDeleted,
+			BytesDeleted:   src.Status.Deletion.BytesDeleted,
+		}
+	}
+
+	dst.Status.Seed = nil
+	if src.Status.Seed != nil {
+		dst.Status.Seed = &BucketSeedStatus{
+			StartedAt:     src.Status.Seed.StartedAt,
+			CompletedAt:   src.Status.Seed.CompletedAt,
+			ObjectsCopied: src.Status.Seed.ObjectsCopied,
+			BytesCopied:   src.Status.Seed.BytesCopied,
+			Failures:      src.Status.Seed.Failures,
+			LastFailure:   src.Status.Seed.LastFailure,
+			LastObject:    src.Status.Seed.LastObject,
+		}
+	}
+
+	return nil
+}
+
+// copyAnnotationsWithout returns a copy of the annotations without the given key,
+// the object meta is shared between the versions and must not be mutated
+func copyAnnotationsWithout(annotations map[string]string, key string) map[string]string {
+	res := map[string]string{}
+	for k, v := range annotations {
+		if k != key {
+			res[k] = v
+		}
+	}
+	return res
+}
@@ -0,0 +1,178 @@
+package v2
+
+import (
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Bucket conversion", func() {
+	created := metav1.NewTime(time.Date(2020, 11, 2, 10, 30, 0, 0, time.UTC))
+
+	v1Bucket := func() *abv1.Bucket {
+		return &abv1.Bucket{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-bucket",
+				Namespace:   "default",
+				Annotations: map[string]string{"team": "storage"},
+			},
+			Spec: abv1.BucketSpec{
+				Cloud:            abv1.BucketCloudGCP,
+				FullName:         "ab-default-test-bucket",
+				OnDeletePolicy:   abv1.BucketOnDeletePolicyDestroy,
+				Location:         "us-east1",
+				StorageClass:     "NEARLINE",
+				ManagementPolicy: abv1.BucketManagementPolicyAdopt,
+				BucketClassName:  "standard",
+				Lifecycle: &abv1.BucketLifecycle{
+					DeleteAfterDays: 365,
+					Transitions:     []abv1.BucketLifecycleTransition{{AfterDays: 30, StorageClass: "COLDLINE"}},
+				},
+				Encryption: &abv1.BucketEncryption{KMSKeyName: "projects/p/locations/us/keyRings/r/cryptoKeys/k"},
+				ClaimRef:   &corev1.ObjectReference{Kind: "BucketClaim", Namespace: "team-a", Name: "uploads", UID: "1234"},
+			},
+			Status: abv1.BucketStatus{
+				CreatedAt: created.Format(time.RFC3339),
+				Attributes: &abv1.BucketAttributes{
+					Location:     "US-EAST1",
+					StorageClass: "NEARLINE",
+					Labels:       map[string]string{"autobucket-cluster": "test"},
+				},
+				Conditions: []abv1.BucketCondition{
+					{Type: abv1.BucketConditionDrifted, Status: corev1.ConditionFalse, LastTransitionTime: created, Reason: "InSync"},
+				},
+			},
+		}
+	}
+
+	It("Should convert from v1 to a structured v2 spec", func() {
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(v1Bucket())).To(Succeed())
+
+		Expect(bucket.Spec.FullName).To(Equal("ab-default-test-bucket"))
+		Expect(bucket.Spec.BucketClassName).To(Equal("standard"))
+		Expect(bucket.Spec.Storage.Location).To(Equal("us-east1"))
+		Expect(bucket.Spec.Storage.StorageClass).To(Equal("NEARLINE"))
+		Expect(bucket.Spec.Storage.Lifecycle.Transitions).To(Equal([]BucketLifecycleTransition{{AfterDays: 30, StorageClass: "COLDLINE"}}))
+		Expect(bucket.Spec.Encryption.KMSKeyName).To(Equal("projects/p/locations/us/keyRings/r/cryptoKeys/k"))
+		Expect(bucket.Spec.Policies).To(Equal(BucketPolicies{OnDelete: BucketOnDeletePolicyDestroy, Management: BucketManagementPolicyAdopt}))
+		Expect(bucket.Status.CreatedAt).NotTo(BeNil())
+		Expect(bucket.Status.CreatedAt.Time.Equal(created.Time)).To(BeTrue())
+		Expect(bucket.Status.Conditions).To(HaveLen(1))
+		Expect(bucket.Status.Conditions[0].Type).To(Equal(BucketConditionDrifted))
+	})
+
+	It("Should round trip v1 -> v2 -> v1", func() {
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(v1Bucket())).To(Succeed())
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		Expect(hub).To(Equal(v1Bucket()))
+	})
+
+	It("Should round trip v2 -> v1 -> v2", func() {
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(v1Bucket())).To(Succeed())
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		roundTripped := &Bucket{}
+		Expect(roundTripped.ConvertFrom(hub)).To(Succeed())
+		Expect(roundTripped).To(Equal(bucket))
+	})
+
+	It("Should round trip an unparseable v1 creation time", func() {
+		original := v1Bucket()
+		original.Status.CreatedAt = "2 November 2020"
+
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(original)).To(Succeed())
+		Expect(bucket.Status.CreatedAt).To(BeNil())
+		Expect(original.Annotations).NotTo(HaveKey(v1CreatedAtAnnotation))
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		Expect(hub).To(Equal(original))
+	})
+
+	It("Should round trip the retention period and deletion protection", func() {
+		original := v1Bucket()
+		original.Spec.OnDeletePolicy = abv1.BucketOnDeletePolicyRetainFor
+		original.Spec.RetentionPeriod = &metav1.Duration{Duration: 72 * time.Hour}
+		original.Spec.DeletionProtection = true
+
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(original)).To(Succeed())
+		Expect(bucket.Spec.Policies.OnDelete).To(Equal(BucketOnDeletePolicyRetainFor))
+		Expect(bucket.Spec.Policies.RetentionPeriod.Duration).To(Equal(72 * time.Hour))
+		Expect(bucket.Spec.Policies.DeletionProtection).To(BeTrue())
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		Expect(hub).To(Equal(original))
+	})
+
+	It("Should round trip the archive settings and the archive and deletion progress", func() {
+		original := v1Bucket()
+		original.Spec.OnDeletePolicy = abv1.BucketOnDeletePolicyArchive
+		original.Spec.Archive = &abv1.BucketArchive{BucketName: "ab-archive", Prefix: "uploads/", StorageClass: "ARCHIVE"}
+		original.Status.Archive = &abv1.BucketArchiveStatus{
+			BucketName:    "ab-archive",
+			Prefix:        "uploads/",
+			StartedAt:     &created,
+			ObjectsCopied: 12,
+			BytesCopied:   4096,
+			Failures:      2,
+			LastFailure:   "images/9.png: forbidden",
+			LastObject:    "images/12.png",
+		}
+		original.Status.Deletion = &abv1.BucketDeletionStatus{StartedAt: &created, ObjectsDeleted: 3, BytesDeleted: 1024}
+
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(original)).To(Succeed())
+		Expect(bucket.Spec.Policies.Archive).To(Equal(&BucketArchive{BucketName: "ab-archive", Prefix: "uploads/", StorageClass: "ARCHIVE"}))
+		Expect(bucket.Status.Archive.ObjectsCopied).To(Equal(int64(12)))
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		Expect(hub).To(Equal(original))
+	})
+
+	It("Should round trip the source and the seed progress", func() {
+		original := v1Bucket()
+		original.Spec.ManagementPolicy = abv1.BucketManagementPolicyCreate
+		original.Spec.Source = &abv1.BucketSource{BackupName: "template-backup"}
+		original.Status.Seed = &abv1.BucketSeedStatus{
+			StartedAt:     &created,
+			CompletedAt:   &created,
+			ObjectsCopied: 7,
+			BytesCopied:   2048,
+			Failures:      1,
+			LastFailure:   "images/3.png: forbidden",
+			LastObject:    "images/7.png",
+		}
+
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(original)).To(Succeed())
+		Expect(bucket.Spec.Source).To(Equal(&BucketSource{BackupName: "template-backup"}))
+		Expect(bucket.Status.Seed.ObjectsCopied).To(Equal(int64(7)))
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		Expect(hub).To(Equal(original))
+	})
+
+	It("Should round trip an empty bucket", func() {
+		bucket := &Bucket{}
+		Expect(bucket.ConvertFrom(&abv1.Bucket{})).To(Succeed())
+
+		hub := &abv1.Bucket{}
+		Expect(bucket.ConvertTo(hub)).To(Succeed())
+		Expect(hub).To(Equal(&abv1.Bucket{}))
+	})
+})
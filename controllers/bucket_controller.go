@@ -18,11 +18,17 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -34,13 +40,23 @@ import (
 // BucketReconciler reconciles a Bucket object
 type BucketReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
-	GCPSvc services.GCPSvc
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	GCPSvc      services.GCPSvc
+	ClusterName string
+	// EmptyWorkers is the number of objects deleted in parallel when emptying a storage bucket
+	EmptyWorkers int
+	// ClaimNamespace is the namespace of the Buckets provisioned for claims, counted against the claim namespace quotas
+	ClaimNamespace string
+	// BucketDefaults are the operator defaults applied to the Buckets
+	BucketDefaults abv1.BucketDefaults
 }
 
 // +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackups,verbs=get;list;watch
 
 func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -62,6 +78,29 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
+	// apply the bucket class and the operator defaults in case the defaulting webhook is not deployed
+	if bucket.ObjectMeta.DeletionTimestamp.IsZero() {
+		defaulted := bucket.DeepCopy()
+		if bucket.Spec.BucketClassName != "" && bucket.Status.CreatedAt == "" {
+			class := &abv1.BucketClass{}
+			if err := r.Get(ctx, types.NamespacedName{Name: bucket.Spec.BucketClassName}, class); err != nil {
+				log.Error(err, "Failed to get bucket class", "BucketClass.Name", bucket.Spec.BucketClassName)
+				return ctrl.Result{}, err
+			}
+			defaulted.ApplyClass(class)
+		}
+		defaulted.ApplyDefaults(r.BucketDefaults)
+		if !reflect.DeepEqual(defaulted.Spec, bucket.Spec) {
+			if err := r.Update(ctx, defaulted); err != nil {
+				log.Error(err, "Failed to update bucket defaults")
+				return ctrl.Result{}, err
+			}
+
+			// Object updated - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
 	// examine DeletionTimestamp to determine if object is under deletion
 	if bucket.ObjectMeta.DeletionTimestamp.IsZero() {
 		// The object is not being deleted, so if it does not have our finalizer,
@@ -81,14 +120,46 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	} else {
 		// The object is being deleted
 		if containsString(bucket.ObjectMeta.Finalizers, bucketFinalizerName) {
-			// our finalizer is present, delete bucket
-			if bucket.Spec.OnDeletePolicy == abv1.BucketOnDeletePolicyDestroy {
+			// a protected storage bucket is only destroyed once confirmed, keep the object until then
+			if bucket.DestroysStorageBucket() && !bucket.DestroyConfirmed() {
+				log.Info("Bucket is protected against deletion. Waiting for the destroy confirmation", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+				return r.setDeletionBlocked(ctx, log, bucket)
+			}
+
+			// our finalizer is present, delete bucket if it was created or adopted by this object
+			if (bucket.Spec.OnDeletePolicy == abv1.BucketOnDeletePolicyDestroy || bucket.Spec.OnDeletePolicy == abv1.BucketOnDeletePolicyArchive) &&
+				bucket.Status.CreatedAt != "" && bucket.Spec.ManagementPolicy != abv1.BucketManagementPolicyObserveOnly {
+				if bucket.Spec.OnDeletePolicy == abv1.BucketOnDeletePolicyArchive {
+					// copy the objects to the archive bucket first, one batch per reconcile
+					archived, err := r.archiveBucket(ctx, log, bucket)
+					if err != nil {
+						log.Error(err, "Failed to archive Bucket", "Bucket.Name", bucket.Name)
+						return ctrl.Result{}, err
+					}
+					if !archived {
+						return ctrl.Result{Requeue: true}, nil
+					}
+				}
+
+				// empty the storage bucket first, within a time budget per reconcile
+				emptied, err := r.emptyBucket(ctx, log, bucket)
+				if err != nil {
+					log.Error(err, "Failed to empty Bucket", "Bucket.Name", bucket.Name)
+					return ctrl.Result{}, err
+				}
+				if !emptied {
+					return ctrl.Result{Requeue: true}, nil
+				}
+
 				log.Info("Deleting Storage Bucket", "Bucket.Cloud", bucket.Spec.Cloud, "Bucket.Name", bucket.Name)
 
 				switch bucket.Spec.Cloud {
 				case abv1.BucketCloudGCP:
 					err := r.deleteGCPBucket(ctx, bucket)
-					if err != nil {
+					if err == services.ErrBucketConflict {
+						// the storage bucket ownership changed, leave it alone and release the object
+						log.Info("Storage Bucket is not owned by this Bucket. Skipping deletion", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+					} else if err != nil {
 						log.Error(err, "Failed to delete gcp Bucket", "Bucket.Name", bucket.Name)
 						return ctrl.Result{}, err
 					}
@@ -96,6 +167,36 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 					log.Info("Bucket Cloud unknown.", "Bucket.Cloud", bucket.Spec.Cloud)
 					return ctrl.Result{}, nil
 				}
+			} else if bucket.Spec.OnDeletePolicy == abv1.BucketOnDeletePolicyRetainFor && bucket.Status.CreatedAt != "" &&
+				bucket.Spec.ManagementPolicy != abv1.BucketManagementPolicyObserveOnly {
+				// mark the storage bucket as pending deletion, the bucket sweeper destroys it once the retention period is over
+				deleteAfter := time.Now().Add(bucketRetentionPeriod(bucket))
+				log.Info("Retaining Storage Bucket", "Bucket.Cloud", bucket.Spec.Cloud, "Bucket.Name", bucket.Name, "DeleteAfter", deleteAfter.Format(time.RFC3339))
+
+				switch bucket.Spec.Cloud {
+				case abv1.BucketCloudGCP:
+					err := r.GCPSvc.RetainGCPBucket(ctx, bucket.Spec.FullName, r.bucketOwner(bucket), deleteAfter)
+					if err == services.ErrBucketConflict {
+						// the storage bucket ownership changed, leave it alone and release the object
+						log.Info("Storage Bucket is not owned by this Bucket. Skipping retention", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+					} else if err != nil {
+						log.Error(err, "Failed to retain gcp Bucket", "Bucket.Name", bucket.Name)
+						return ctrl.Result{}, err
+					}
+				default:
+					log.Info("Bucket Cloud unknown.", "Bucket.Cloud", bucket.Spec.Cloud)
+					return ctrl.Result{}, nil
+				}
+			} else if bucket.Spec.OnDeletePolicy == abv1.BucketOnDeletePolicyIgnore && bucket.Status.CreatedAt != "" &&
+				bucket.Spec.ManagementPolicy != abv1.BucketManagementPolicyObserveOnly && bucket.Spec.Cloud == abv1.BucketCloudGCP {
+				// keep the storage bucket, a recreated Bucket with the same namespace and name re-adopts it
+				err := r.GCPSvc.ReleaseGCPBucket(ctx, bucket.Spec.FullName, r.bucketOwner(bucket))
+				if err == services.ErrBucketConflict {
+					log.Info("Storage Bucket is not owned by this Bucket. Skipping release", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+				} else if err != nil {
+					log.Error(err, "Failed to release gcp Bucket", "Bucket.Name", bucket.Name)
+					return ctrl.Result{}, err
+				}
 			}
 
 			// remove our finalizer from the list and update it.
@@ -110,6 +211,29 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, nil
 	}
 
+	// check the namespace quotas before creating or adopting the storage bucket
+	if bucket.Status.CreatedAt == "" && bucket.CountsAgainstQuota() {
+		violation, err := r.quotaViolation(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to check bucket quotas")
+			return ctrl.Result{}, err
+		}
+		if violation != "" {
+			log.Info("Bucket quota exceeded", "Bucket.Name", bucket.Name, "reason", violation)
+			return r.setQuotaExceeded(ctx, log, bucket, violation)
+		}
+	}
+
+	switch bucket.Spec.ManagementPolicy {
+	case abv1.BucketManagementPolicyAdopt:
+		if bucket.Status.CreatedAt == "" {
+			return r.adoptBucket(ctx, log, bucket)
+		}
+		return r.observeBucket(ctx, log, bucket)
+	case abv1.BucketManagementPolicyObserveOnly:
+		return r.observeBucket(ctx, log, bucket)
+	}
+
 	// check if the storage bucket has been created yet
 	if bucket.Status.CreatedAt == "" {
 		// bucket not yet created
@@ -118,6 +242,10 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		switch bucket.Spec.Cloud {
 		case abv1.BucketCloudGCP:
 			err := r.createGCPBucket(ctx, bucket)
+			if err == services.ErrBucketConflict {
+				log.Info("Storage Bucket already exists and is not owned by this Bucket", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+				return r.setConflict(ctx, log, bucket)
+			}
 			if err != nil {
 				log.Error(err, "Failed to create gcp Bucket", "Bucket.Name", bucket.Name)
 				return ctrl.Result{}, err
@@ -128,6 +256,8 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 
 		bucket.Status.CreatedAt = time.Now().Format(time.RFC3339)
+		bucket.Status.SetCondition(abv1.BucketConditionConflict, corev1.ConditionFalse, "Owned", "")
+		clearQuotaExceeded(bucket)
 		err = r.Client.Status().Update(ctx, bucket)
 		if err != nil {
 			log.Error(err, "Failed to update bucket status")
@@ -138,14 +268,577 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{Requeue: true}, nil
 	}
 
+	// copy the source objects into the new storage bucket
+	if bucket.Spec.Source != nil && (bucket.Status.Seed == nil || bucket.Status.Seed.CompletedAt == nil) {
+		return r.seedBucket(ctx, log, bucket)
+	}
+
 	return ctrl.Result{}, nil
 }
 
 const bucketFinalizerName = "ab.leclouddev.com/bucket-finalizer"
 
+// bucketObserveInterval is the interval between attributes refreshes of adopted and observed buckets
+const bucketObserveInterval = 10 * time.Minute
+
+// bucketQuotaRetryInterval is the interval between quota checks of buckets blocked by a namespace quota
+const bucketQuotaRetryInterval = time.Minute
+
+// bucketRetentionPeriod returns the grace period of the retain-for on delete policy
+func bucketRetentionPeriod(bucket *abv1.Bucket) time.Duration {
+	if bucket.Spec.RetentionPeriod == nil {
+		// the bucket was deleted before being defaulted
+		return abv1.DefaultRetentionPeriod
+	}
+	return bucket.Spec.RetentionPeriod.Duration
+}
+
+// bucketEmptyBudget is the time spent emptying a storage bucket per reconcile, the emptying resumes on the next reconcile
+const bucketEmptyBudget = 30 * time.Second
+
+// emptyBucket deletes the storage bucket object versions within the time budget and reports the progress in the status,
+// returns true once the storage bucket is empty
+func (r *BucketReconciler) emptyBucket(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (bool, error) {
+	if bucket.Spec.Cloud != abv1.BucketCloudGCP {
+		// nothing to empty, the deletion reports the unknown cloud
+		return true, nil
+	}
+
+	previous := bucket.Status.DeepCopy()
+	if bucket.Status.Deletion == nil {
+		now := metav1.Now()
+		bucket.Status.Deletion = &abv1.BucketDeletionStatus{StartedAt: &now}
+		log.Info("Emptying Storage Bucket", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+	}
+
+	budgetCtx, cancel := context.WithTimeout(ctx, bucketEmptyBudget)
+	defer cancel()
+	progress, err := r.GCPSvc.EmptyGCPBucket(budgetCtx, bucket.Spec.FullName, r.bucketOwner(bucket), r.EmptyWorkers)
+	if err == services.ErrBucketConflict {
+		// not ours to empty, the deletion handles the foreign storage bucket
+		return true, nil
+	}
+	if progress != nil {
+		// save the progress even on errors, the deleted objects are gone
+		bucket.Status.Deletion.ObjectsDeleted += progress.Objects
+		bucket.Status.Deletion.BytesDeleted += progress.Bytes
+	}
+	// only update on changes to avoid triggering a new reconcile loop
+	if !reflect.DeepEqual(previous, &bucket.Status) {
+		if updateErr := r.Client.Status().Update(ctx, bucket); updateErr != nil {
+			if err == nil {
+				err = updateErr
+			}
+			return false, err
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return progress.Done, nil
+}
+
+// bucketArchiveBatchSize is the number of objects archived per reconcile, the progress is saved in the status between batches
+const bucketArchiveBatchSize = 1000
+
+// archiveBucket copies a batch of objects to the archive bucket and reports the progress in the status,
+// returns true once all the objects are archived
+func (r *BucketReconciler) archiveBucket(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (bool, error) {
+	if bucket.Status.Archive != nil && bucket.Status.Archive.CompletedAt != nil {
+		return true, nil
+	}
+	if bucket.Spec.Cloud != abv1.BucketCloudGCP {
+		// nothing to archive, the deletion reports the unknown cloud
+		return true, nil
+	}
+
+	if bucket.Status.Archive == nil {
+		// keep the storage bucket until an archive bucket is set, the deletion is retried
+		if bucket.Spec.Archive == nil || bucket.Spec.Archive.BucketName == "" {
+			return false, fmt.Errorf("no archive bucket set for bucket %s", bucket.Name)
+		}
+
+		now := metav1.Now()
+		prefix := bucket.Spec.Archive.Prefix
+		if prefix == "" {
+			prefix = bucket.Spec.FullName + "/" + now.UTC().Format("20060102T150405Z") + "/"
+		}
+		bucket.Status.Archive = &abv1.BucketArchiveStatus{
+			BucketName: bucket.Spec.Archive.BucketName,
+			Prefix:     prefix,
+			StartedAt:  &now,
+		}
+		log.Info("Archiving Storage Bucket", "Bucket.Name", bucket.Name, "Archive.BucketName", bucket.Status.Archive.BucketName, "Archive.Prefix", prefix)
+	}
+
+	archive := services.BucketArchive{
+		BucketName: bucket.Status.Archive.BucketName,
+		Prefix:     bucket.Status.Archive.Prefix,
+	}
+	if bucket.Spec.Archive != nil {
+		archive.StorageClass = bucket.Spec.Archive.StorageClass
+	}
+	progress, err := r.GCPSvc.ArchiveGCPBucketObjects(ctx, bucket.Spec.FullName, r.bucketOwner(bucket), archive,
+		bucket.Status.Archive.LastObject, bucketArchiveBatchSize)
+	if err == services.ErrBucketNotFound || err == services.ErrBucketConflict {
+		// nothing of ours to archive, the deletion handles the missing or foreign storage bucket
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	status := bucket.Status.Archive
+	if status.LastObject == "" {
+		// a new archive pass, the objects of a failed pass are all archived again
+		status.ObjectsCopied = 0
+		status.BytesCopied = 0
+		status.Failures = 0
+	}
+	status.ObjectsCopied += progress.Objects
+	status.BytesCopied += progress.Bytes
+	status.Failures += progress.Failures
+	if progress.LastError != "" {
+		status.LastFailure = progress.LastError
+	}
+	if progress.LastObject != "" {
+		status.LastObject = progress.LastObject
+	}
+
+	var failed error
+	if progress.Done {
+		if status.Failures > 0 {
+			// keep the storage bucket until all its objects are archived, the deletion is retried with a new pass
+			failed = fmt.Errorf("%d objects of bucket %s couldn't be archived, last failure: %s", status.Failures, bucket.Name, status.LastFailure)
+			status.LastObject = ""
+		} else {
+			now := metav1.Now()
+			status.CompletedAt = &now
+			log.Info("Archived Storage Bucket", "Bucket.Name", bucket.Name, "Archive.Objects", status.ObjectsCopied)
+		}
+	}
+	if err := r.Client.Status().Update(ctx, bucket); err != nil {
+		return false, err
+	}
+	if failed != nil {
+		return false, failed
+	}
+
+	return progress.Done, nil
+}
+
+// bucketSeedBatchSize is the number of source objects copied per reconcile, the progress is saved in the status between batches
+const bucketSeedBatchSize = 1000
+
+// bucketSeedRetryInterval is the interval between checks of a source bucket or backup that is not ready yet
+const bucketSeedRetryInterval = time.Minute
+
+// seedBucket copies a batch of objects from the source bucket or backup into the new storage bucket
+// and reports the progress in the status, one batch per reconcile
+func (r *BucketReconciler) seedBucket(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (ctrl.Result, error) {
+	if bucket.Spec.Cloud != abv1.BucketCloudGCP {
+		log.Info("Bucket Cloud unknown.", "Bucket.Cloud", bucket.Spec.Cloud)
+		return ctrl.Result{}, nil
+	}
+
+	source := bucket.Spec.Source
+	startAfter := ""
+	if bucket.Status.Seed != nil {
+		startAfter = bucket.Status.Seed.LastObject
+	}
+
+	// the batch counts, accumulated in the status
+	batch := abv1.BucketSeedStatus{}
+	var done bool
+	var sourceDesc string
+	if source.BucketName != "" {
+		sourceDesc = fmt.Sprintf("bucket %q", source.BucketName)
+		sourceBucket := &abv1.Bucket{}
+		err := r.Get(ctx, types.NamespacedName{Name: source.BucketName, Namespace: bucket.Namespace}, sourceBucket)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return r.setSeedPending(ctx, log, bucket, fmt.Sprintf("source %s not found", sourceDesc))
+			}
+			log.Error(err, "Failed to get source Bucket")
+			return ctrl.Result{}, err
+		}
+		if sourceBucket.Spec.Cloud != bucket.Spec.Cloud {
+			return r.setSeedFailed(ctx, log, bucket, "SourceInvalid", fmt.Sprintf("source %s cloud is %s, only %s buckets can seed the bucket",
+				sourceDesc, sourceBucket.Spec.Cloud, bucket.Spec.Cloud))
+		}
+		if sourceBucket.Status.CreatedAt == "" {
+			return r.setSeedPending(ctx, log, bucket, fmt.Sprintf("source %s storage bucket not ready", sourceDesc))
+		}
+
+		progress, err := r.GCPSvc.ReplicateGCPBucketObjects(ctx, sourceBucket.Spec.FullName, bucket.Spec.FullName, r.bucketOwner(bucket),
+			false, startAfter, bucketSeedBatchSize)
+		if err == services.ErrBucketNotFound {
+			return r.setSeedPending(ctx, log, bucket, fmt.Sprintf("source %s storage bucket not found", sourceDesc))
+		}
+		if err == services.ErrBucketConflict {
+			return r.setSeedFailed(ctx, log, bucket, "NotOwned", fmt.Sprintf("storage bucket %q is not owned by the Bucket", bucket.Spec.FullName))
+		}
+		if err != nil {
+			log.Error(err, "Failed to copy the source Bucket objects", "Bucket.Name", bucket.Name, "Source", source.BucketName)
+			return ctrl.Result{}, err
+		}
+		batch = abv1.BucketSeedStatus{
+			ObjectsCopied: progress.Copied,
+			BytesCopied:   progress.Bytes,
+			Failures:      progress.Failures,
+			LastFailure:   progress.LastError,
+			LastObject:    progress.LastObject,
+		}
+		done = progress.Done
+	} else {
+		sourceDesc = fmt.Sprintf("backup %q", source.BackupName)
+		backup := &abv1.BucketBackup{}
+		err := r.Get(ctx, types.NamespacedName{Name: source.BackupName, Namespace: bucket.Namespace}, backup)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return r.setSeedPending(ctx, log, bucket, fmt.Sprintf("source %s not found", sourceDesc))
+			}
+			log.Error(err, "Failed to get source BucketBackup")
+			return ctrl.Result{}, err
+		}
+		if backup.Status.Phase == abv1.BucketBackupPhaseFailed {
+			return r.setSeedFailed(ctx, log, bucket, "SourceInvalid", fmt.Sprintf("source %s failed, only completed backups can seed the bucket", sourceDesc))
+		}
+		if backup.Status.Phase != abv1.BucketBackupPhaseCompleted {
+			return r.setSeedPending(ctx, log, bucket, fmt.Sprintf("source %s not completed", sourceDesc))
+		}
+
+		restoreSource := services.RestoreSource{BucketName: backup.Status.BucketName, Prefix: backup.Status.Prefix}
+		progress, err := r.GCPSvc.RestoreGCPBucketObjects(ctx, bucket.Spec.FullName, r.bucketOwner(bucket), restoreSource,
+			services.RestoreConflictSkip, startAfter, bucketSeedBatchSize)
+		if err == services.ErrSourceBucketNotFound {
+			return r.setSeedFailed(ctx, log, bucket, "SourceInvalid", fmt.Sprintf("source %s storage bucket %q not found", sourceDesc, backup.Status.BucketName))
+		}
+		if err == services.ErrBucketNotFound {
+			return r.setSeedPending(ctx, log, bucket, fmt.Sprintf("storage bucket %q not found", bucket.Spec.FullName))
+		}
+		if err == services.ErrBucketConflict {
+			return r.setSeedFailed(ctx, log, bucket, "NotOwned", fmt.Sprintf("storage bucket %q is not owned by the Bucket", bucket.Spec.FullName))
+		}
+		if err != nil {
+			log.Error(err, "Failed to restore the source BucketBackup objects", "Bucket.Name", bucket.Name, "Source", source.BackupName)
+			return ctrl.Result{}, err
+		}
+		batch = abv1.BucketSeedStatus{
+			ObjectsCopied: progress.Objects,
+			BytesCopied:   progress.Bytes,
+			Failures:      progress.Failures,
+			LastFailure:   progress.LastError,
+			LastObject:    progress.LastObject,
+		}
+		done = progress.Done
+	}
+
+	if bucket.Status.Seed == nil {
+		now := metav1.Now()
+		bucket.Status.Seed = &abv1.BucketSeedStatus{StartedAt: &now}
+		log.Info("Seeding Storage Bucket", "Bucket.Name", bucket.Name, "Source", sourceDesc)
+	}
+	seed := bucket.Status.Seed
+	seed.ObjectsCopied += batch.ObjectsCopied
+	seed.BytesCopied += batch.BytesCopied
+	seed.Failures += batch.Failures
+	if batch.LastFailure != "" {
+		seed.LastFailure = batch.LastFailure
+	}
+	if batch.LastObject != "" {
+		seed.LastObject = batch.LastObject
+	}
+
+	if !done {
+		bucket.Status.SetCondition(abv1.BucketConditionSeeded, corev1.ConditionFalse, "Seeding", "copying the objects of the source "+sourceDesc)
+	} else {
+		now := metav1.Now()
+		seed.CompletedAt = &now
+		if seed.Failures == 0 {
+			bucket.Status.SetCondition(abv1.BucketConditionSeeded, corev1.ConditionTrue, "Seeded",
+				fmt.Sprintf("%d objects copied from the source %s", seed.ObjectsCopied, sourceDesc))
+		} else {
+			bucket.Status.SetCondition(abv1.BucketConditionSeeded, corev1.ConditionFalse, "ObjectsFailed",
+				fmt.Sprintf("%d objects of the source %s couldn't be copied, last failure: %s", seed.Failures, sourceDesc, seed.LastFailure))
+		}
+		log.Info("Seeded Storage Bucket", "Bucket.Name", bucket.Name, "Seed.Objects", seed.ObjectsCopied, "Seed.Failures", seed.Failures)
+	}
+	if err := r.Client.Status().Update(ctx, bucket); err != nil {
+		log.Error(err, "Failed to update bucket status")
+		return ctrl.Result{}, err
+	}
+
+	if !done {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// setSeedPending marks the seeding as waiting for its source bucket or backup, and retries periodically
+func (r *BucketReconciler) setSeedPending(ctx context.Context, log logr.Logger, bucket *abv1.Bucket, message string) (ctrl.Result, error) {
+	if bucket.Status.SetCondition(abv1.BucketConditionSeeded, corev1.ConditionFalse, "SourceNotReady", message) {
+		log.Info("Seeding pending", "reason", message)
+		err := r.Client.Status().Update(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: bucketSeedRetryInterval}, nil
+}
+
+// setSeedFailed marks the seeding as failed on a source that can't seed the bucket, or a storage bucket not owned by the bucket,
+// the seeding isn't retried
+func (r *BucketReconciler) setSeedFailed(ctx context.Context, log logr.Logger, bucket *abv1.Bucket, reason string, message string) (ctrl.Result, error) {
+	if bucket.Status.Seed == nil {
+		bucket.Status.Seed = &abv1.BucketSeedStatus{}
+	}
+	now := metav1.Now()
+	bucket.Status.Seed.CompletedAt = &now
+	bucket.Status.SetCondition(abv1.BucketConditionSeeded, corev1.ConditionFalse, reason, message)
+	log.Info("Seeding failed", "reason", message)
+	if err := r.Client.Status().Update(ctx, bucket); err != nil {
+		log.Error(err, "Failed to update bucket status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// adoptBucket takes ownership of an existing storage bucket
+func (r *BucketReconciler) adoptBucket(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (ctrl.Result, error) {
+	log.Info("Adopting Bucket", "Bucket.Cloud", bucket.Spec.Cloud, "Bucket.Name", bucket.Name)
+
+	var attrs *services.BucketAttrs
+	var err error
+	switch bucket.Spec.Cloud {
+	case abv1.BucketCloudGCP:
+		attrs, err = r.GCPSvc.AdoptBucket(ctx, bucket.Spec.FullName, r.bucketOwner(bucket))
+	default:
+		log.Info("Bucket Cloud unknown.", "Bucket.Cloud", bucket.Spec.Cloud)
+		return ctrl.Result{}, nil
+	}
+	if err == services.ErrBucketConflict {
+		log.Info("Storage Bucket is owned by another Bucket", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+		return r.setConflict(ctx, log, bucket)
+	}
+	if err == services.ErrBucketNotFound {
+		log.Info("Storage Bucket to adopt not found", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+		return r.setNotFound(ctx, log, bucket)
+	}
+	if err != nil {
+		log.Error(err, "Failed to adopt Bucket", "Bucket.Name", bucket.Name)
+		return ctrl.Result{}, err
+	}
+
+	bucket.Status.CreatedAt = attrs.Created.Format(time.RFC3339)
+	bucket.Status.SetCondition(abv1.BucketConditionConflict, corev1.ConditionFalse, "Owned", "")
+	bucket.Status.SetCondition(abv1.BucketConditionNotFound, corev1.ConditionFalse, "Found", "")
+	clearQuotaExceeded(bucket)
+	err = r.Client.Status().Update(ctx, bucket)
+	if err != nil {
+		log.Error(err, "Failed to update bucket status")
+		return ctrl.Result{}, err
+	}
+
+	// Status updated - return and requeue
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// observeBucket reports the storage bucket attributes and drift, without mutating the storage bucket
+func (r *BucketReconciler) observeBucket(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (ctrl.Result, error) {
+	var attrs *services.BucketAttrs
+	var err error
+	switch bucket.Spec.Cloud {
+	case abv1.BucketCloudGCP:
+		attrs, err = r.GCPSvc.GetBucketAttrs(ctx, bucket.Spec.FullName)
+	default:
+		log.Info("Bucket Cloud unknown.", "Bucket.Cloud", bucket.Spec.Cloud)
+		return ctrl.Result{}, nil
+	}
+	if err == services.ErrBucketNotFound {
+		log.Info("Observed Storage Bucket not found", "Bucket.Name", bucket.Name, "Bucket.FullName", bucket.Spec.FullName)
+		return r.setNotFound(ctx, log, bucket)
+	}
+	if err != nil {
+		log.Error(err, "Failed to get Bucket attributes", "Bucket.Name", bucket.Name)
+		return ctrl.Result{}, err
+	}
+
+	status := bucket.Status.DeepCopy()
+	if status.CreatedAt == "" {
+		status.CreatedAt = attrs.Created.Format(time.RFC3339)
+	}
+	status.Attributes = &abv1.BucketAttributes{
+		Location:     attrs.Location,
+		StorageClass: attrs.StorageClass,
+		Labels:       attrs.Labels,
+	}
+	status.SetCondition(abv1.BucketConditionNotFound, corev1.ConditionFalse, "Found", "")
+	if drift := bucketDrift(&bucket.Spec, attrs); len(drift) > 0 {
+		status.SetCondition(abv1.BucketConditionDrifted, corev1.ConditionTrue, "AttributesDrifted", strings.Join(drift, ", "))
+	} else {
+		status.SetCondition(abv1.BucketConditionDrifted, corev1.ConditionFalse, "InSync", "")
+	}
+
+	// only update on changes to avoid triggering a new reconcile loop
+	if !reflect.DeepEqual(status, &bucket.Status) {
+		bucket.Status = *status
+		err = r.Client.Status().Update(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: bucketObserveInterval}, nil
+}
+
+// bucketDrift returns the differences between the spec and the observed storage bucket attributes
+func bucketDrift(spec *abv1.BucketSpec, attrs *services.BucketAttrs) []string {
+	var drift []string
+	if spec.Location != "" && !strings.EqualFold(spec.Location, attrs.Location) {
+		drift = append(drift, fmt.Sprintf("location: expected %s, observed %s", spec.Location, attrs.Location))
+	}
+	if spec.StorageClass != "" && !strings.EqualFold(spec.StorageClass, attrs.StorageClass) {
+		drift = append(drift, fmt.Sprintf("storageClass: expected %s, observed %s", spec.StorageClass, attrs.StorageClass))
+	}
+	return drift
+}
+
+// setNotFound marks the storage bucket to adopt or observe as not found
+func (r *BucketReconciler) setNotFound(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (ctrl.Result, error) {
+	status := bucket.Status.DeepCopy()
+	status.Attributes = nil
+	status.SetCondition(abv1.BucketConditionNotFound, corev1.ConditionTrue, "NotFound",
+		"storage bucket "+bucket.Spec.FullName+" not found")
+
+	// only update on changes to avoid triggering a new reconcile loop
+	if !reflect.DeepEqual(status, &bucket.Status) {
+		bucket.Status = *status
+		err := r.Client.Status().Update(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// check again later, the storage bucket might be created out of band
+	return ctrl.Result{RequeueAfter: bucketObserveInterval}, nil
+}
+
+// setConflict marks the bucket as conflicting with a storage bucket it doesn't own
+func (r *BucketReconciler) setConflict(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (ctrl.Result, error) {
+	if bucket.Status.SetCondition(abv1.BucketConditionConflict, corev1.ConditionTrue, "OwnedByOther",
+		"storage bucket "+bucket.Spec.FullName+" already exists and is not owned by this Bucket") {
+		err := r.Client.Status().Update(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// don't requeue, the bucket must be recreated with another full name
+	return ctrl.Result{}, nil
+}
+
+// quotaViolation returns the namespace quota violation of a storage bucket to create or adopt, empty if allowed.
+// Only the Buckets whose storage bucket is created or adopted count, the buckets waiting for their creation don't block each other.
+// The Buckets provisioned for claims count against the claim namespace quotas
+func (r *BucketReconciler) quotaViolation(ctx context.Context, bucket *abv1.Bucket) (string, error) {
+	namespace := bucket.QuotaNamespace(r.ClaimNamespace)
+
+	quotas := &abv1.BucketQuotaList{}
+	if err := r.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		return "", err
+	}
+	if len(quotas.Items) == 0 {
+		return "", nil
+	}
+
+	buckets, err := abv1.ListQuotaBuckets(ctx, r, namespace, r.ClaimNamespace)
+	if err != nil {
+		return "", err
+	}
+	var count int32
+	for i := range buckets {
+		b := &buckets[i]
+		if b.UID != bucket.UID && b.Status.CreatedAt != "" && b.CountsAgainstQuota() {
+			count++
+		}
+	}
+
+	if err := abv1.CheckBucketQuotas(quotas.Items, bucket, count); err != nil {
+		return err.Error(), nil
+	}
+
+	return "", nil
+}
+
+// setQuotaExceeded marks the bucket as blocked by a namespace quota
+func (r *BucketReconciler) setQuotaExceeded(ctx context.Context, log logr.Logger, bucket *abv1.Bucket, message string) (ctrl.Result, error) {
+	if bucket.Status.SetCondition(abv1.BucketConditionQuotaExceeded, corev1.ConditionTrue, "QuotaExceeded", message) {
+		err := r.Client.Status().Update(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// check again later, the quota might be raised or other buckets deleted
+	return ctrl.Result{RequeueAfter: bucketQuotaRetryInterval}, nil
+}
+
+// clearQuotaExceeded clears the quota exceeded condition set by a previous reconcile
+func clearQuotaExceeded(bucket *abv1.Bucket) {
+	if bucket.Status.GetCondition(abv1.BucketConditionQuotaExceeded) != nil {
+		bucket.Status.SetCondition(abv1.BucketConditionQuotaExceeded, corev1.ConditionFalse, "WithinQuota", "")
+	}
+}
+
+// setDeletionBlocked marks the deletion of a protected bucket as waiting for the destroy confirmation
+func (r *BucketReconciler) setDeletionBlocked(ctx context.Context, log logr.Logger, bucket *abv1.Bucket) (ctrl.Result, error) {
+	if bucket.Status.SetCondition(abv1.BucketConditionDeletionBlocked, corev1.ConditionTrue, "DeletionProtected",
+		fmt.Sprintf("bucket is protected against deletion, set the %s annotation to %q to destroy the storage bucket",
+			abv1.BucketConfirmDestroyAnnotation, bucket.Spec.FullName)) {
+		err := r.Client.Status().Update(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// don't requeue, the confirmation annotation or the spec change triggers a new reconcile
+	return ctrl.Result{}, nil
+}
+
+// bucketOwner returns the ownership stamped on the storage bucket
+func (r *BucketReconciler) bucketOwner(bucket *abv1.Bucket) services.BucketOwner {
+	return services.BucketOwner{ClusterID: r.ClusterName, UID: string(bucket.UID), Namespace: bucket.Namespace, Name: bucket.Name}
+}
+
 func (r *BucketReconciler) createGCPBucket(ctx context.Context, bucket *abv1.Bucket) error {
 	// create bucket
-	err := r.GCPSvc.CreateBucket(ctx, bucket.Spec.FullName)
+	attrs := services.BucketAttrs{
+		Location:     bucket.Spec.Location,
+		StorageClass: bucket.Spec.StorageClass,
+	}
+	if bucket.Spec.Lifecycle != nil {
+		attrs.Lifecycle = &services.BucketLifecycle{DeleteAfterDays: bucket.Spec.Lifecycle.DeleteAfterDays}
+		for _, t := range bucket.Spec.Lifecycle.Transitions {
+			attrs.Lifecycle.Transitions = append(attrs.Lifecycle.Transitions, services.BucketLifecycleTransition{
+				AfterDays:    t.AfterDays,
+				StorageClass: t.StorageClass,
+			})
+		}
+	}
+	if bucket.Spec.Encryption != nil {
+		attrs.KMSKeyName = bucket.Spec.Encryption.KMSKeyName
+	}
+	err := r.GCPSvc.CreateBucket(ctx, bucket.Spec.FullName, attrs, r.bucketOwner(bucket))
 	if err != nil {
 		return err
 	}
@@ -155,7 +848,7 @@ func (r *BucketReconciler) createGCPBucket(ctx context.Context, bucket *abv1.Buc
 
 func (r *BucketReconciler) deleteGCPBucket(ctx context.Context, bucket *abv1.Bucket) error {
 	// delete bucket
-	err := r.GCPSvc.DeleteGCPBucket(ctx, bucket.Spec.FullName)
+	err := r.GCPSvc.DeleteGCPBucket(ctx, bucket.Spec.FullName, r.bucketOwner(bucket))
 	if err != nil {
 		return err
 	}
@@ -2,14 +2,23 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+	"github.com/didil/autobucket-operator/testsupport/mocks"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var _ = Describe("Bucket controller", func() {
@@ -29,7 +38,7 @@ var _ = Describe("Bucket controller", func() {
 		It("Should create the storage bucket", func() {
 			ctx := context.Background()
 
-			gcpSvc.On("CreateBucket", mock.AnythingOfType("*context.emptyCtx"), BucketFullName).Return(nil)
+			gcpSvc.On("CreateBucket", mock.AnythingOfType("*context.emptyCtx"), BucketFullName, mock.Anything, mock.Anything).Return(nil)
 
 			bucket = &abv1.Bucket{
 				ObjectMeta: metav1.ObjectMeta{
@@ -89,4 +98,563 @@ var _ = Describe("Bucket controller", func() {
 		})
 	})
 
+	Context("When the storage bucket is owned by someone else", func() {
+		var bucket *abv1.Bucket
+
+		It("Should set the conflict condition", func() {
+			ctx := context.Background()
+
+			const ConflictFullName = "ab-default-conflict-bucket"
+			gcpSvc.On("CreateBucket", mock.Anything, ConflictFullName, mock.Anything, mock.Anything).Return(services.ErrBucketConflict)
+
+			bucket = &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "conflict-bucket",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       ConflictFullName,
+					OnDeletePolicy: abv1.BucketOnDeletePolicyDestroy,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			Eventually(func() bool {
+				updatedBucket := &abv1.Bucket{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket)
+				if err != nil {
+					return false
+				}
+
+				return updatedBucket.Status.IsConditionTrue(abv1.BucketConditionConflict) && updatedBucket.Status.CreatedAt == ""
+			}, timeout, interval).Should(BeTrue())
+
+			// check the ownership passed to the service
+			for _, call := range gcpSvc.Calls {
+				if call.Method == "CreateBucket" && call.Arguments[1].(string) == ConflictFullName {
+					owner := call.Arguments[3].(services.BucketOwner)
+					Expect(owner.ClusterID).To(Equal("test-cluster"))
+					Expect(owner.UID).ToNot(BeEmpty())
+				}
+			}
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+
+			// the storage bucket was never created, it must not be deleted
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, &abv1.Bucket{})
+				return err != nil
+			}, timeout, interval).Should(BeTrue())
+			for _, call := range gcpSvc.Calls {
+				Expect(call.Method).ToNot(Equal("DeleteGCPBucket"))
+			}
+		})
+	})
+
+	Context("When observing an existing bucket", func() {
+		var bucket *abv1.Bucket
+
+		It("Should report the attributes and drift without mutating the storage bucket", func() {
+			ctx := context.Background()
+
+			const ObservedFullName = "legacy-observed-bucket"
+			created := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+			gcpSvc.On("GetBucketAttrs", mock.Anything, ObservedFullName).Return(&services.BucketAttrs{
+				Location:     "US-EAST1",
+				StorageClass: "STANDARD",
+				Created:      created,
+			}, nil)
+
+			bucket = &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "observed-bucket",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:            abv1.BucketCloudGCP,
+					FullName:         ObservedFullName,
+					OnDeletePolicy:   abv1.BucketOnDeletePolicyDestroy,
+					Location:         "us-east1",
+					StorageClass:     "NEARLINE",
+					ManagementPolicy: abv1.BucketManagementPolicyObserveOnly,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			Eventually(func() bool {
+				updatedBucket := &abv1.Bucket{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket)
+				if err != nil {
+					return false
+				}
+
+				status := updatedBucket.Status
+				if status.Attributes == nil || status.Attributes.StorageClass != "STANDARD" {
+					return false
+				}
+				if status.CreatedAt != created.Format(time.RFC3339) {
+					return false
+				}
+				drifted := status.GetCondition(abv1.BucketConditionDrifted)
+
+				return drifted != nil && drifted.Status == "True" && drifted.Message == "storageClass: expected NEARLINE, observed STANDARD"
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+
+			// observed buckets are never deleted
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, &abv1.Bucket{})
+				return err != nil
+			}, timeout, interval).Should(BeTrue())
+			for _, call := range gcpSvc.Calls {
+				if call.Method == "CreateBucket" || call.Method == "AdoptBucket" || call.Method == "DeleteGCPBucket" {
+					Expect(call.Arguments[1].(string)).ToNot(Equal(bucket.Spec.FullName))
+				}
+			}
+		})
+	})
+
+	Context("When deleting a bucket with the retain-for on delete policy", func() {
+		It("Should mark the storage bucket as pending deletion and release the object", func() {
+			ctx := context.Background()
+
+			fullName := "ab-default-test-bucket-retained"
+			gcpSvc.On("CreateBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("RetainGCPBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-bucket-retained",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:           abv1.BucketCloudGCP,
+					FullName:        fullName,
+					OnDeletePolicy:  abv1.BucketOnDeletePolicyRetainFor,
+					RetentionPeriod: &metav1.Duration{Duration: 72 * time.Hour},
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			Eventually(func() string {
+				updatedBucket := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket); err != nil {
+					return ""
+				}
+				return updatedBucket.Status.CreatedAt
+			}, timeout, interval).ShouldNot(BeEmpty())
+
+			deletedAt := time.Now()
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, &abv1.Bucket{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			var deleteAfter time.Time
+			for _, call := range gcpSvc.Calls {
+				if call.Method == "RetainGCPBucket" && call.Arguments[1].(string) == fullName {
+					deleteAfter = call.Arguments[3].(time.Time)
+				}
+			}
+			Expect(deleteAfter).To(BeTemporally("~", deletedAt.Add(72*time.Hour), 30*time.Second))
+			gcpSvc.AssertNotCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, fullName, mock.Anything)
+		})
+	})
+
+	Context("When deleting and recreating a bucket with the ignore on delete policy", func() {
+		It("Should release the storage bucket to the recreated bucket with the same namespace and name", func() {
+			ctx := context.Background()
+
+			fullName := "ab-default-test-bucket-recreated"
+			gcpSvc.On("CreateBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+
+			newBucket := func() *abv1.Bucket {
+				return &abv1.Bucket{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test-bucket-recreated",
+						Namespace: NamespaceName,
+					},
+					Spec: abv1.BucketSpec{
+						Cloud:          abv1.BucketCloudGCP,
+						FullName:       fullName,
+						OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+					},
+				}
+			}
+			createdBucket := func() *abv1.Bucket {
+				updatedBucket := &abv1.Bucket{}
+				Eventually(func() string {
+					if err := k8sClient.Get(ctx, types.NamespacedName{Name: "test-bucket-recreated", Namespace: NamespaceName}, updatedBucket); err != nil {
+						return ""
+					}
+					return updatedBucket.Status.CreatedAt
+				}, timeout, interval).ShouldNot(BeEmpty())
+				return updatedBucket
+			}
+
+			bucket := newBucket()
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+			first := createdBucket()
+
+			Expect(k8sClient.Delete(ctx, first)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: first.Name, Namespace: first.Namespace}, &abv1.Bucket{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+			gcpSvc.AssertCalled(GinkgoT(), "ReleaseGCPBucket", mock.Anything, fullName, services.BucketOwner{
+				ClusterID: "test-cluster", UID: string(first.UID), Namespace: NamespaceName, Name: first.Name,
+			})
+			gcpSvc.AssertNotCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, fullName, mock.Anything)
+
+			// the recreated bucket gets a new uid, the storage bucket is re-adopted by namespace and name
+			Expect(k8sClient.Create(ctx, newBucket())).Should(Succeed())
+			second := createdBucket()
+			Expect(second.UID).NotTo(Equal(first.UID))
+			Expect(second.Status.IsConditionTrue(abv1.BucketConditionConflict)).To(BeFalse())
+			gcpSvc.AssertCalled(GinkgoT(), "CreateBucket", mock.Anything, fullName, mock.Anything, services.BucketOwner{
+				ClusterID: "test-cluster", UID: string(second.UID), Namespace: NamespaceName, Name: second.Name,
+			})
+		})
+	})
+
+	Context("When deleting a bucket with the archive on delete policy", func() {
+		It("Should archive the objects in batches, then empty and destroy the storage bucket", func() {
+			ctx := context.Background()
+
+			fullName := "ab-default-test-bucket-archived"
+			archive := services.BucketArchive{BucketName: "ab-archive", Prefix: "archived/", StorageClass: "ARCHIVE"}
+			gcpSvc.On("CreateBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("ArchiveGCPBucketObjects", mock.Anything, fullName, mock.Anything, archive, "", mock.Anything).
+				Return(&services.ArchiveProgress{Objects: 1000, Bytes: 4096, LastObject: "obj-0999"}, nil)
+			gcpSvc.On("ArchiveGCPBucketObjects", mock.Anything, fullName, mock.Anything, archive, "obj-0999", mock.Anything).
+				Return(&services.ArchiveProgress{Objects: 10, Bytes: 40, LastObject: "obj-1009", Done: true}, nil)
+			gcpSvc.On("EmptyGCPBucket", mock.Anything, fullName, mock.Anything, mock.Anything).
+				Return(&services.EmptyProgress{Objects: 800, Bytes: 2048}, nil).Once()
+			gcpSvc.On("EmptyGCPBucket", mock.Anything, fullName, mock.Anything, mock.Anything).
+				Return(&services.EmptyProgress{Objects: 210, Bytes: 2088, Done: true}, nil)
+			gcpSvc.On("DeleteGCPBucket", mock.Anything, fullName, mock.Anything).Return(nil)
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-bucket-archived",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       fullName,
+					OnDeletePolicy: abv1.BucketOnDeletePolicyArchive,
+					Archive:        &abv1.BucketArchive{BucketName: "ab-archive", Prefix: "archived/", StorageClass: "ARCHIVE"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			Eventually(func() string {
+				updatedBucket := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket); err != nil {
+					return ""
+				}
+				return updatedBucket.Status.CreatedAt
+			}, timeout, interval).ShouldNot(BeEmpty())
+
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, &abv1.Bucket{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			gcpSvc.AssertCalled(GinkgoT(), "ArchiveGCPBucketObjects", mock.Anything, fullName, mock.Anything, archive, "obj-0999", mock.Anything)
+			gcpSvc.AssertNumberOfCalls(GinkgoT(), "EmptyGCPBucket", 2)
+			gcpSvc.AssertCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, fullName, mock.Anything)
+		})
+	})
+
+	Context("When deleting a protected bucket", func() {
+		It("Should only destroy the storage bucket once the deletion is confirmed", func() {
+			ctx := context.Background()
+
+			fullName := "ab-default-test-bucket-protected"
+			gcpSvc.On("CreateBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("EmptyGCPBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(&services.EmptyProgress{Done: true}, nil)
+			gcpSvc.On("DeleteGCPBucket", mock.Anything, fullName, mock.Anything).Return(nil)
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-bucket-protected",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:              abv1.BucketCloudGCP,
+					FullName:           fullName,
+					OnDeletePolicy:     abv1.BucketOnDeletePolicyDestroy,
+					DeletionProtection: true,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			Eventually(func() string {
+				updatedBucket := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket); err != nil {
+					return ""
+				}
+				return updatedBucket.Status.CreatedAt
+			}, timeout, interval).ShouldNot(BeEmpty())
+
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+
+			updatedBucket := &abv1.Bucket{}
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket); err != nil {
+					return false
+				}
+				c := updatedBucket.Status.GetCondition(abv1.BucketConditionDeletionBlocked)
+				return c != nil && c.Status == corev1.ConditionTrue
+			}, timeout, interval).Should(BeTrue())
+			gcpSvc.AssertNotCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, fullName, mock.Anything)
+
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket); err != nil {
+					return err
+				}
+				updatedBucket.Annotations = map[string]string{abv1.BucketConfirmDestroyAnnotation: fullName}
+				return k8sClient.Update(ctx, updatedBucket)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, &abv1.Bucket{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+			gcpSvc.AssertCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, fullName, mock.Anything)
+		})
+	})
+
+	Context("When creating a bucket with a source bucket", func() {
+		It("Should copy the source objects after the storage bucket creation and set the seeded condition", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-test-bucket-template", mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-test-bucket-preview", mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("ReplicateGCPBucketObjects", mock.Anything, "ab-default-test-bucket-template", "ab-default-test-bucket-preview", mock.Anything, false, "", bucketSeedBatchSize).
+				Return(&services.ReplicationProgress{Objects: 1000, Copied: 1000, Bytes: 4096, LastObject: "images/999.png"}, nil).Once()
+			gcpSvc.On("ReplicateGCPBucketObjects", mock.Anything, "ab-default-test-bucket-template", "ab-default-test-bucket-preview", mock.Anything, false, "images/999.png", bucketSeedBatchSize).
+				Return(&services.ReplicationProgress{Objects: 2, Copied: 2, Bytes: 1024, LastObject: "index.html", Done: true}, nil).Once()
+
+			template := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-bucket-template",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-test-bucket-template",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+				},
+			}
+			Expect(k8sClient.Create(ctx, template)).Should(Succeed())
+			Eventually(func() string {
+				updatedBucket := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: template.Name, Namespace: template.Namespace}, updatedBucket); err != nil {
+					return ""
+				}
+				return updatedBucket.Status.CreatedAt
+			}, timeout, interval).ShouldNot(BeEmpty())
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-bucket-preview",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-test-bucket-preview",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+					Source:         &abv1.BucketSource{BucketName: template.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			Eventually(func() error {
+				updatedBucket := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: bucket.Namespace}, updatedBucket); err != nil {
+					return err
+				}
+				if !updatedBucket.Status.IsConditionTrue(abv1.BucketConditionSeeded) {
+					return fmt.Errorf("not seeded: %v", updatedBucket.Status.Conditions)
+				}
+				seed := updatedBucket.Status.Seed
+				if seed.ObjectsCopied != 1002 || seed.BytesCopied != 5120 || seed.LastObject != "index.html" {
+					return fmt.Errorf("wrong seed status %+v", seed)
+				}
+				return nil
+			}, timeout, interval).Should(BeNil())
+		})
+	})
+
+	Context("When the source can't seed the bucket", func() {
+		var bucket *abv1.Bucket
+		var svc *mocks.GCPSvc
+
+		seed := func(objs ...runtime.Object) *abv1.Bucket {
+			ctx := context.Background()
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, append(objs, bucket)...)
+			r := &BucketReconciler{Client: c, Log: ctrl.Log.WithName("bucket"), Scheme: scheme.Scheme, GCPSvc: svc}
+
+			result, err := r.seedBucket(ctx, r.Log, bucket)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(ctrl.Result{}))
+			updated := &abv1.Bucket{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: NamespaceName}, updated)).To(Succeed())
+			Expect(updated.Status.Seed.CompletedAt).NotTo(BeNil())
+			return updated
+		}
+
+		BeforeEach(func() {
+			svc = new(mocks.GCPSvc)
+			bucket = &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "seeded", Namespace: NamespaceName},
+				Spec: abv1.BucketSpec{
+					Cloud:    abv1.BucketCloudGCP,
+					FullName: "ab-default-seeded",
+					Source:   &abv1.BucketSource{BackupName: "nightly"},
+				},
+			}
+		})
+
+		It("Should fail the seeding from a bucket of another cloud", func() {
+			source := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-cloud", Namespace: NamespaceName},
+				Spec:       abv1.BucketSpec{Cloud: abv1.BucketCloud("aws"), FullName: "other-cloud"},
+				Status:     abv1.BucketStatus{CreatedAt: time.Now().Format(time.RFC3339)},
+			}
+			bucket.Spec.Source = &abv1.BucketSource{BucketName: source.Name}
+
+			updated := seed(source)
+			c := updated.Status.GetCondition(abv1.BucketConditionSeeded)
+			Expect(c.Status).To(Equal(corev1.ConditionFalse))
+			Expect(c.Reason).To(Equal("SourceInvalid"))
+			Expect(c.Message).To(ContainSubstring("only gcp buckets can seed the bucket"))
+		})
+
+		It("Should fail the seeding from a failed backup", func() {
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: NamespaceName},
+				Status:     abv1.BucketBackupStatus{Phase: abv1.BucketBackupPhaseFailed},
+			}
+
+			updated := seed(backup)
+			c := updated.Status.GetCondition(abv1.BucketConditionSeeded)
+			Expect(c.Reason).To(Equal("SourceInvalid"))
+			Expect(c.Message).To(ContainSubstring("only completed backups can seed the bucket"))
+			svc.AssertNotCalled(GinkgoT(), "RestoreGCPBucketObjects", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("Should fail the seeding from a backup whose storage bucket doesn't exist", func() {
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: NamespaceName},
+				Status:     abv1.BucketBackupStatus{Phase: abv1.BucketBackupPhaseCompleted, BucketName: "backups", Prefix: "nightly/"},
+			}
+			svc.On("RestoreGCPBucketObjects", mock.Anything, "ab-default-seeded", mock.Anything, mock.Anything, services.RestoreConflictSkip, "", bucketSeedBatchSize).
+				Return(nil, services.ErrSourceBucketNotFound)
+
+			updated := seed(backup)
+			c := updated.Status.GetCondition(abv1.BucketConditionSeeded)
+			Expect(c.Reason).To(Equal("SourceInvalid"))
+			Expect(c.Message).To(ContainSubstring(`storage bucket "backups" not found`))
+		})
+
+		It("Should fail the seeding of a storage bucket not owned by the bucket", func() {
+			source := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "template", Namespace: NamespaceName},
+				Spec:       abv1.BucketSpec{Cloud: abv1.BucketCloudGCP, FullName: "ab-default-template"},
+				Status:     abv1.BucketStatus{CreatedAt: time.Now().Format(time.RFC3339)},
+			}
+			bucket.Spec.Source = &abv1.BucketSource{BucketName: source.Name}
+			svc.On("ReplicateGCPBucketObjects", mock.Anything, "ab-default-template", "ab-default-seeded", mock.Anything, false, "", bucketSeedBatchSize).
+				Return(nil, services.ErrBucketConflict)
+
+			updated := seed(source)
+			c := updated.Status.GetCondition(abv1.BucketConditionSeeded)
+			Expect(c.Reason).To(Equal("NotOwned"))
+			Expect(c.Message).To(ContainSubstring(`storage bucket "ab-default-seeded" is not owned by the Bucket`))
+		})
+
+		It("Should fail the restore of a backup into a storage bucket not owned by the bucket", func() {
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: NamespaceName},
+				Status:     abv1.BucketBackupStatus{Phase: abv1.BucketBackupPhaseCompleted, BucketName: "backups", Prefix: "nightly/"},
+			}
+			svc.On("RestoreGCPBucketObjects", mock.Anything, "ab-default-seeded", mock.Anything, mock.Anything, services.RestoreConflictSkip, "", bucketSeedBatchSize).
+				Return(nil, services.ErrBucketConflict)
+
+			updated := seed(backup)
+			c := updated.Status.GetCondition(abv1.BucketConditionSeeded)
+			Expect(c.Reason).To(Equal("NotOwned"))
+		})
+	})
+
+	Context("When some objects can't be archived", func() {
+		It("Should keep the storage bucket and archive all the objects again", func() {
+			ctx := context.Background()
+			archive := services.BucketArchive{BucketName: "ab-archive", Prefix: "archived/"}
+			now := metav1.Now()
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "archived-failures", Namespace: NamespaceName},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-archived-failures",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyArchive,
+					Archive:        &abv1.BucketArchive{BucketName: "ab-archive"},
+				},
+				Status: abv1.BucketStatus{
+					Archive: &abv1.BucketArchiveStatus{
+						BucketName:    "ab-archive",
+						Prefix:        "archived/",
+						StartedAt:     &now,
+						ObjectsCopied: 999,
+						Failures:      1,
+						LastFailure:   "obj-0500: forbidden",
+						LastObject:    "obj-0999",
+					},
+				},
+			}
+			svc := new(mocks.GCPSvc)
+			svc.On("ArchiveGCPBucketObjects", mock.Anything, bucket.Spec.FullName, mock.Anything, archive, "obj-0999", bucketArchiveBatchSize).
+				Return(&services.ArchiveProgress{Objects: 9, Failures: 1, LastError: "obj-1005: forbidden", LastObject: "obj-1009", Done: true}, nil)
+			svc.On("ArchiveGCPBucketObjects", mock.Anything, bucket.Spec.FullName, mock.Anything, archive, "", bucketArchiveBatchSize).
+				Return(&services.ArchiveProgress{Objects: 1000, LastObject: "obj-0999"}, nil)
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, bucket)
+			r := &BucketReconciler{Client: c, Log: ctrl.Log.WithName("bucket"), Scheme: scheme.Scheme, GCPSvc: svc}
+
+			get := func() *abv1.Bucket {
+				updated := &abv1.Bucket{}
+				Expect(c.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: NamespaceName}, updated)).To(Succeed())
+				return updated
+			}
+
+			archived, err := r.archiveBucket(ctx, r.Log, get())
+			Expect(err).To(MatchError(ContainSubstring("2 objects of bucket archived-failures couldn't be archived")))
+			Expect(archived).To(BeFalse())
+			status := get().Status.Archive
+			Expect(status.CompletedAt).To(BeNil())
+			Expect(status.Failures).To(Equal(int64(2)))
+			Expect(status.LastFailure).To(Equal("obj-1005: forbidden"))
+			Expect(status.LastObject).To(BeEmpty())
+
+			archived, err = r.archiveBucket(ctx, r.Log, get())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(archived).To(BeFalse())
+			status = get().Status.Archive
+			Expect(status.ObjectsCopied).To(Equal(int64(1000)))
+			Expect(status.Failures).To(BeZero())
+			Expect(status.LastObject).To(Equal("obj-0999"))
+		})
+	})
 })
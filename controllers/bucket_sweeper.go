@@ -0,0 +1,120 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/didil/autobucket-operator/services"
+)
+
+// BucketSweeper destroys the storage buckets released with the retain-for on delete policy once their retention period is over.
+// It runs on the leader only
+type BucketSweeper struct {
+	Log         logr.Logger
+	GCPSvc      services.GCPSvc
+	ClusterName string
+	// Interval is the interval between sweeps
+	Interval time.Duration
+	// EmptyWorkers is the number of objects deleted in parallel when emptying a storage bucket
+	EmptyWorkers int
+}
+
+// DefaultBucketSweepInterval is the default interval between sweeps
+const DefaultBucketSweepInterval = time.Hour
+
+// bucketSweepEmptyBudget is the time spent emptying a storage bucket per sweep, the emptying resumes on the next sweep
+const bucketSweepEmptyBudget = 5 * time.Minute
+
+// Start sweeps the pending deletion buckets periodically until the stop channel is closed
+func (s *BucketSweeper) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultBucketSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(ctx, time.Now()); err != nil {
+			// try again on the next tick
+			s.Log.Error(err, "Failed to sweep pending deletion buckets")
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep destroys the pending deletion storage buckets whose retention period is over at the given time.
+// Buckets re-adopted by a new Bucket object since their release have a new owner and are left alone
+func (s *BucketSweeper) Sweep(ctx context.Context, now time.Time) error {
+	pending, err := s.GCPSvc.ListPendingDeletionBuckets(ctx, s.ClusterName)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range pending {
+		if now.Before(b.DeleteAfter) {
+			continue
+		}
+
+		// empty the storage bucket first, within a time budget per sweep
+		budgetCtx, cancel := context.WithTimeout(ctx, bucketSweepEmptyBudget)
+		progress, err := s.GCPSvc.EmptyGCPBucket(budgetCtx, b.Name, b.Owner, s.EmptyWorkers)
+		cancel()
+		if err == services.ErrBucketConflict {
+			s.Log.Info("Retained Storage Bucket was re-adopted. Skipping deletion", "Bucket.FullName", b.Name)
+			continue
+		}
+		if err != nil {
+			// keep sweeping the other buckets, the deleted objects are gone and the emptying resumes on the next sweep
+			s.Log.Error(err, "Failed to empty retained gcp Bucket", "Bucket.FullName", b.Name)
+			continue
+		}
+		if !progress.Done {
+			s.Log.Info("Emptying retained Storage Bucket. Resuming on the next sweep", "Bucket.FullName", b.Name, "ObjectsDeleted", progress.Objects)
+			continue
+		}
+
+		s.Log.Info("Deleting retained Storage Bucket", "Bucket.Cloud", "gcp", "Bucket.FullName", b.Name, "DeleteAfter", b.DeleteAfter.Format(time.RFC3339))
+		err = s.GCPSvc.DeleteGCPBucket(ctx, b.Name, b.Owner)
+		if err == services.ErrBucketConflict {
+			s.Log.Info("Retained Storage Bucket was re-adopted. Skipping deletion", "Bucket.FullName", b.Name)
+			continue
+		}
+		if err != nil {
+			// keep sweeping the other buckets
+			s.Log.Error(err, "Failed to delete retained gcp Bucket", "Bucket.FullName", b.Name)
+		}
+	}
+
+	return nil
+}
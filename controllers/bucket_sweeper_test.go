@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/didil/autobucket-operator/services"
+	"github.com/didil/autobucket-operator/testsupport/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("Bucket sweeper", func() {
+	now := time.Date(2020, 11, 2, 10, 30, 0, 0, time.UTC)
+
+	It("Should destroy the retained buckets whose retention period is over", func() {
+		ctx := context.Background()
+
+		svc := new(mocks.GCPSvc)
+		expired := services.PendingDeletionBucket{
+			Name:        "ab-default-expired",
+			Owner:       services.BucketOwner{ClusterID: "test", UID: "1234"},
+			DeleteAfter: now.Add(-time.Minute),
+		}
+		readopted := services.PendingDeletionBucket{
+			Name:        "ab-default-readopted",
+			Owner:       services.BucketOwner{ClusterID: "test", UID: "5678"},
+			DeleteAfter: now.Add(-time.Hour),
+		}
+		retained := services.PendingDeletionBucket{
+			Name:        "ab-default-retained",
+			Owner:       services.BucketOwner{ClusterID: "test", UID: "9012"},
+			DeleteAfter: now.Add(time.Hour),
+		}
+		large := services.PendingDeletionBucket{
+			Name:        "ab-default-large",
+			Owner:       services.BucketOwner{ClusterID: "test", UID: "3456"},
+			DeleteAfter: now.Add(-time.Minute),
+		}
+		svc.On("ListPendingDeletionBuckets", mock.Anything, "test").Return([]services.PendingDeletionBucket{expired, readopted, retained, large}, nil)
+		svc.On("EmptyGCPBucket", mock.Anything, expired.Name, expired.Owner, 4).Return(&services.EmptyProgress{Objects: 10, Done: true}, nil)
+		svc.On("EmptyGCPBucket", mock.Anything, readopted.Name, readopted.Owner, 4).Return(nil, services.ErrBucketConflict)
+		svc.On("EmptyGCPBucket", mock.Anything, large.Name, large.Owner, 4).Return(&services.EmptyProgress{Objects: 100000}, nil)
+		svc.On("DeleteGCPBucket", mock.Anything, expired.Name, expired.Owner).Return(nil)
+
+		sweeper := &BucketSweeper{
+			Log:          ctrl.Log.WithName("sweeper"),
+			GCPSvc:       svc,
+			ClusterName:  "test",
+			EmptyWorkers: 4,
+		}
+		Expect(sweeper.Sweep(ctx, now)).To(Succeed())
+
+		svc.AssertCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, expired.Name, expired.Owner)
+		svc.AssertNotCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, readopted.Name, mock.Anything)
+		svc.AssertNotCalled(GinkgoT(), "EmptyGCPBucket", mock.Anything, retained.Name, mock.Anything, mock.Anything)
+		svc.AssertNotCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, retained.Name, mock.Anything)
+		// the emptying of the large bucket resumes on the next sweep
+		svc.AssertNotCalled(GinkgoT(), "DeleteGCPBucket", mock.Anything, large.Name, mock.Anything)
+	})
+})
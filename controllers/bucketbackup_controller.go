@@ -0,0 +1,331 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+)
+
+// BucketBackupReconciler reconciles a BucketBackup object
+type BucketBackupReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	GCPSvc services.GCPSvc
+	// DeleteWorkers is the number of backup objects deleted in parallel when a backup is deleted
+	DeleteWorkers int
+	// BackupBuckets are the storage buckets allowed as backup destinations besides the Buckets of the backup namespace
+	BackupBuckets []string
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch
+
+func (r *BucketBackupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("bucketbackup", req.NamespacedName)
+
+	backup := &abv1.BucketBackup{}
+	err := r.Get(ctx, req.NamespacedName, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("BucketBackup resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get bucket backup")
+		return ctrl.Result{}, err
+	}
+
+	// the backup objects are deleted with the BucketBackup
+	if backup.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !containsString(backup.ObjectMeta.Finalizers, bucketBackupFinalizerName) {
+			backup.ObjectMeta.Finalizers = append(backup.ObjectMeta.Finalizers, bucketBackupFinalizerName)
+			if err := r.Update(ctx, backup); err != nil {
+				log.Error(err, "Failed to update bucket backup finalizers")
+				return ctrl.Result{}, err
+			}
+
+			// Object updated - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		}
+	} else {
+		if containsString(backup.ObjectMeta.Finalizers, bucketBackupFinalizerName) {
+			done, err := r.deleteBackupObjects(ctx, log, backup)
+			if err != nil {
+				log.Error(err, "Failed to delete backup objects")
+				return ctrl.Result{}, err
+			}
+			if !done {
+				// resume the deletion on the next reconcile
+				return ctrl.Result{Requeue: true}, nil
+			}
+
+			backup.ObjectMeta.Finalizers = removeString(backup.ObjectMeta.Finalizers, bucketBackupFinalizerName)
+			if err := r.Update(ctx, backup); err != nil {
+				log.Error(err, "Failed to delete bucket backup finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
+	}
+
+	if backup.Status.Finished() {
+		return ctrl.Result{}, nil
+	}
+
+	bucket := &abv1.Bucket{}
+	err = r.Get(ctx, types.NamespacedName{Name: backup.Spec.BucketName, Namespace: backup.Namespace}, bucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.setPending(ctx, log, backup, fmt.Sprintf("bucket %q not found", backup.Spec.BucketName))
+		}
+		log.Error(err, "Failed to get Bucket")
+		return ctrl.Result{}, err
+	}
+	if bucket.Spec.Cloud != abv1.BucketCloudGCP {
+		// the backups are copied server side to a gcp destination bucket
+		return r.setFailed(ctx, log, backup, fmt.Sprintf("bucket %q cloud is %s, only gcp buckets can be backed up", bucket.Name, bucket.Spec.Cloud))
+	}
+	if message, err := r.checkDestination(ctx, backup, bucket); err != nil {
+		log.Error(err, "Failed to check the backup destination")
+		return ctrl.Result{}, err
+	} else if message != "" {
+		return r.setPending(ctx, log, backup, message)
+	}
+
+	if backup.Status.StartedAt == nil {
+		return r.startBackup(ctx, log, backup)
+	}
+
+	return r.copyBatch(ctx, log, backup, bucket)
+}
+
+const bucketBackupFinalizerName = "ab.leclouddev.com/bucketbackup-finalizer"
+
+// bucketBackupRetryInterval is the interval between the attempts of pending backups
+const bucketBackupRetryInterval = time.Minute
+
+// bucketBackupBatchSize is the number of objects backed up per reconcile, the progress is saved in the status between batches
+const bucketBackupBatchSize = 1000
+
+// checkDestination returns a pending message if the destination bucket is neither a managed Bucket of the backup namespace
+// nor an allowed backup bucket, so that a namespace can't write to or delete from the storage buckets of other namespaces
+func (r *BucketBackupReconciler) checkDestination(ctx context.Context, backup *abv1.BucketBackup, bucket *abv1.Bucket) (string, error) {
+	dest := backup.Spec.Destination.BucketName
+	if dest == bucket.Spec.FullName {
+		return fmt.Sprintf("destination bucket %q must differ from the backed up bucket", dest), nil
+	}
+	if containsString(r.BackupBuckets, dest) {
+		return "", nil
+	}
+
+	buckets := &abv1.BucketList{}
+	if err := r.List(ctx, buckets, client.InNamespace(backup.Namespace)); err != nil {
+		return "", err
+	}
+	for i := range buckets.Items {
+		b := &buckets.Items[i]
+		// observed buckets aren't owned by the namespace
+		if b.Spec.FullName == dest && b.DeletionTimestamp.IsZero() && b.Status.CreatedAt != "" &&
+			b.Spec.ManagementPolicy != abv1.BucketManagementPolicyObserveOnly {
+			return "", nil
+		}
+	}
+
+	return fmt.Sprintf("destination bucket %q is neither a Bucket of the namespace nor an allowed backup bucket", dest), nil
+}
+
+// setPending updates the status of a backup waiting for its source bucket, and retries periodically
+func (r *BucketBackupReconciler) setPending(ctx context.Context, log logr.Logger, backup *abv1.BucketBackup, message string) (ctrl.Result, error) {
+	if backup.Status.Phase != abv1.BucketBackupPhasePending || backup.Status.Message != message {
+		log.Info("Backup pending", "reason", message)
+		backup.Status.Phase = abv1.BucketBackupPhasePending
+		backup.Status.Message = message
+		if err := r.Client.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update bucket backup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: bucketBackupRetryInterval}, nil
+}
+
+// setFailed marks the backup as failed without retrying
+func (r *BucketBackupReconciler) setFailed(ctx context.Context, log logr.Logger, backup *abv1.BucketBackup, message string) (ctrl.Result, error) {
+	log.Info("Backup failed", "reason", message)
+	now := metav1.Now()
+	backup.Status.Phase = abv1.BucketBackupPhaseFailed
+	backup.Status.Message = message
+	backup.Status.CompletedAt = &now
+	if err := r.Client.Status().Update(ctx, backup); err != nil {
+		log.Error(err, "Failed to update bucket backup status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// startBackup records the destination and the base backup in the status, they don't change once the backup started
+func (r *BucketBackupReconciler) startBackup(ctx context.Context, log logr.Logger, backup *abv1.BucketBackup) (ctrl.Result, error) {
+	basePrefix, err := r.basePrefix(ctx, log, backup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	backup.Status.Phase = abv1.BucketBackupPhaseRunning
+	backup.Status.Message = ""
+	backup.Status.StartedAt = &now
+	backup.Status.BucketName = backup.Spec.Destination.BucketName
+	backup.Status.Prefix = backupPrefix(backup)
+	backup.Status.BasePrefix = basePrefix
+
+	log.Info("Starting backup", "Bucket.Name", backup.Spec.BucketName, "Destination", backup.Status.BucketName+"/"+backup.Status.Prefix,
+		"Base", basePrefix)
+	if err := r.Client.Status().Update(ctx, backup); err != nil {
+		log.Error(err, "Failed to update bucket backup status")
+		return ctrl.Result{}, err
+	}
+
+	// updated successfully - return and requeue
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// basePrefix returns the prefix of the base backup, empty for a full backup if the base backup isn't usable
+func (r *BucketBackupReconciler) basePrefix(ctx context.Context, log logr.Logger, backup *abv1.BucketBackup) (string, error) {
+	if backup.Spec.BaseBackupName == "" {
+		return "", nil
+	}
+
+	base := &abv1.BucketBackup{}
+	err := r.Get(ctx, types.NamespacedName{Name: backup.Spec.BaseBackupName, Namespace: backup.Namespace}, base)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Base backup not found. Running a full backup", "Base", backup.Spec.BaseBackupName)
+			return "", nil
+		}
+		log.Error(err, "Failed to get base bucket backup")
+		return "", err
+	}
+	if base.Status.Phase != abv1.BucketBackupPhaseCompleted || base.Status.BucketName != backup.Spec.Destination.BucketName {
+		log.Info("Base backup not completed or in another destination bucket. Running a full backup", "Base", base.Name)
+		return "", nil
+	}
+
+	return base.Status.Prefix, nil
+}
+
+// backupPrefix returns the prefix of the backed up objects in the destination bucket
+func backupPrefix(backup *abv1.BucketBackup) string {
+	return backup.Spec.Destination.Prefix + backup.Namespace + "/" + backup.Name + "/"
+}
+
+// copyBatch copies a batch of objects to the destination and reports the progress in the status
+func (r *BucketBackupReconciler) copyBatch(ctx context.Context, log logr.Logger, backup *abv1.BucketBackup, bucket *abv1.Bucket) (ctrl.Result, error) {
+	status := &backup.Status
+	dest := services.BackupDestination{BucketName: status.BucketName, Prefix: status.Prefix, BasePrefix: status.BasePrefix}
+	progress, err := r.GCPSvc.BackupGCPBucketObjects(ctx, bucket.Spec.FullName, dest, status.LastObject, bucketBackupBatchSize)
+	if err == services.ErrBucketNotFound {
+		return r.setPending(ctx, log, backup, fmt.Sprintf("storage bucket %q not found", bucket.Spec.FullName))
+	}
+	if err != nil {
+		log.Error(err, "Failed to back up gcp Bucket objects", "Bucket.FullName", bucket.Spec.FullName)
+		return ctrl.Result{}, err
+	}
+
+	previous := status.DeepCopy()
+	status.Message = ""
+	status.ObjectsCopied += progress.Objects
+	status.ObjectsUnchanged += progress.Unchanged
+	status.BytesCopied += progress.Bytes
+	status.Failures += progress.Failures
+	if progress.LastError != "" {
+		status.LastFailure = progress.LastError
+	}
+	if progress.LastObject != "" {
+		status.LastObject = progress.LastObject
+	}
+	if progress.Done {
+		now := metav1.Now()
+		status.CompletedAt = &now
+		status.Phase = abv1.BucketBackupPhaseCompleted
+		if status.Failures > 0 {
+			status.Phase = abv1.BucketBackupPhaseFailed
+			status.Message = fmt.Sprintf("%d objects couldn't be copied", status.Failures)
+		}
+		log.Info("Backup finished", "Phase", status.Phase, "Objects", status.ObjectsCopied, "Unchanged", status.ObjectsUnchanged,
+			"Failures", status.Failures)
+	}
+
+	// only update on changes to avoid triggering a new reconcile loop
+	if !reflect.DeepEqual(previous, status) {
+		if err := r.Client.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update bucket backup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !progress.Done {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// deleteBackupObjects deletes the backed up objects for up to bucketEmptyBudget, returns true once they are all deleted
+func (r *BucketBackupReconciler) deleteBackupObjects(ctx context.Context, log logr.Logger, backup *abv1.BucketBackup) (bool, error) {
+	if backup.Status.Prefix == "" {
+		// not started, nothing to delete
+		return true, nil
+	}
+
+	log.Info("Deleting backup objects", "Destination", backup.Status.BucketName+"/"+backup.Status.Prefix)
+	budgetCtx, cancel := context.WithTimeout(ctx, bucketEmptyBudget)
+	defer cancel()
+	progress, err := r.GCPSvc.DeleteGCPObjects(budgetCtx, backup.Status.BucketName, backup.Status.Prefix, r.DeleteWorkers)
+	if err != nil {
+		return false, err
+	}
+
+	return progress.Done, nil
+}
+
+func (r *BucketBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.BucketBackup{}).
+		Complete(r)
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+	"github.com/didil/autobucket-operator/testsupport/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("BucketBackup controller", func() {
+	const (
+		NamespaceName = "default"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	Context("When creating a bucket backup", func() {
+		It("Should copy the objects in batches, report the progress and delete the backup objects with the backup", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-backup-source", mock.Anything, mock.Anything).Return(nil)
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "backup-source",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-backup-source",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			dest := services.BackupDestination{BucketName: "backups", Prefix: "nightly/default/backup-test/"}
+			gcpSvc.On("BackupGCPBucketObjects", mock.Anything, "ab-default-backup-source", dest, "", bucketBackupBatchSize).
+				Return(&services.BackupProgress{Objects: 2, Bytes: 2048, LastObject: "b.txt"}, nil).Once()
+			gcpSvc.On("BackupGCPBucketObjects", mock.Anything, "ab-default-backup-source", dest, "b.txt", bucketBackupBatchSize).
+				Return(&services.BackupProgress{Objects: 1, Bytes: 1024, Failures: 1, LastError: "d.txt: copy error", LastObject: "d.txt", Done: true}, nil).Once()
+			gcpSvc.On("DeleteGCPObjects", mock.Anything, "backups", dest.Prefix, mock.Anything).Return(&services.EmptyProgress{Objects: 3, Done: true}, nil)
+
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "backup-test",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketBackupSpec{
+					BucketName:  bucket.Name,
+					Destination: abv1.BucketBackupDestination{BucketName: "backups", Prefix: "nightly/"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, backup)).Should(Succeed())
+
+			backupKey := types.NamespacedName{Name: backup.Name, Namespace: NamespaceName}
+			Eventually(func() error {
+				updated := &abv1.BucketBackup{}
+				if err := k8sClient.Get(ctx, backupKey, updated); err != nil {
+					return err
+				}
+				status := updated.Status
+				if status.Phase != abv1.BucketBackupPhaseFailed {
+					return fmt.Errorf("wrong phase %v", status.Phase)
+				}
+				if status.Prefix != dest.Prefix || status.ObjectsCopied != 3 || status.BytesCopied != 3072 || status.Failures != 1 {
+					return fmt.Errorf("wrong status %+v", status)
+				}
+				if status.LastFailure != "d.txt: copy error" || status.CompletedAt == nil {
+					return fmt.Errorf("wrong status %+v", status)
+				}
+				return nil
+			}, timeout, interval).Should(BeNil())
+
+			Expect(k8sClient.Delete(ctx, backup)).Should(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, backupKey, &abv1.BucketBackup{}))
+			}, timeout, interval).Should(BeTrue())
+			gcpSvc.AssertCalled(GinkgoT(), "DeleteGCPObjects", mock.Anything, "backups", dest.Prefix, mock.Anything)
+
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+		})
+
+		It("Should not back up to a storage bucket of another namespace", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-backup-denied", mock.Anything, mock.Anything).Return(nil)
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "backup-denied",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-backup-denied",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "backup-denied-test",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketBackupSpec{
+					BucketName:  bucket.Name,
+					Destination: abv1.BucketBackupDestination{BucketName: "ab-other-team-uploads"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, backup)).Should(Succeed())
+
+			backupKey := types.NamespacedName{Name: backup.Name, Namespace: NamespaceName}
+			Eventually(func() (abv1.BucketBackupPhase, error) {
+				updated := &abv1.BucketBackup{}
+				err := k8sClient.Get(ctx, backupKey, updated)
+				return updated.Status.Phase, err
+			}, timeout, interval).Should(Equal(abv1.BucketBackupPhasePending))
+			gcpSvc.AssertNotCalled(GinkgoT(), "BackupGCPBucketObjects", mock.Anything, "ab-default-backup-denied", mock.Anything, mock.Anything, mock.Anything)
+
+			Expect(k8sClient.Delete(ctx, backup)).Should(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, backupKey, &abv1.BucketBackup{}))
+			}, timeout, interval).Should(BeTrue())
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+		})
+	})
+
+	Context("When the bucket can't be backed up", func() {
+		It("Should fail the backup of a bucket of another cloud", func() {
+			ctx := context.Background()
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-cloud", Namespace: NamespaceName},
+				Spec:       abv1.BucketSpec{Cloud: abv1.BucketCloud("aws"), FullName: "ab-default-other-cloud"},
+			}
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "other-cloud-backup",
+					Namespace:  NamespaceName,
+					Finalizers: []string{bucketBackupFinalizerName},
+				},
+				Spec: abv1.BucketBackupSpec{
+					BucketName:  bucket.Name,
+					Destination: abv1.BucketBackupDestination{BucketName: "backups"},
+				},
+			}
+			svc := new(mocks.GCPSvc)
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, bucket, backup)
+			r := &BucketBackupReconciler{Client: c, Log: ctrl.Log.WithName("backup"), Scheme: scheme.Scheme, GCPSvc: svc}
+
+			backupKey := types.NamespacedName{Name: backup.Name, Namespace: NamespaceName}
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: backupKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(ctrl.Result{}))
+
+			updated := &abv1.BucketBackup{}
+			Expect(c.Get(ctx, backupKey, updated)).To(Succeed())
+			Expect(updated.Status.Phase).To(Equal(abv1.BucketBackupPhaseFailed))
+			Expect(updated.Status.Message).To(ContainSubstring("only gcp buckets can be backed up"))
+			svc.AssertNotCalled(GinkgoT(), "BackupGCPBucketObjects", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+})
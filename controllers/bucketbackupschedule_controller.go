@@ -0,0 +1,352 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+)
+
+// BucketBackupScheduleReconciler reconciles a BucketBackupSchedule object
+type BucketBackupScheduleReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackupschedules,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackupschedules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackups,verbs=get;list;watch;create;update;patch;delete
+
+func (r *BucketBackupScheduleReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("bucketbackupschedule", req.NamespacedName)
+
+	schedule := &abv1.BucketBackupSchedule{}
+	err := r.Get(ctx, req.NamespacedName, schedule)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("BucketBackupSchedule resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get bucket backup schedule")
+		return ctrl.Result{}, err
+	}
+
+	// the backups outlive the schedule unless its deletion policy deletes them
+	if schedule.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !containsString(schedule.ObjectMeta.Finalizers, bucketBackupScheduleFinalizerName) {
+			schedule.ObjectMeta.Finalizers = append(schedule.ObjectMeta.Finalizers, bucketBackupScheduleFinalizerName)
+			if err := r.Update(ctx, schedule); err != nil {
+				log.Error(err, "Failed to update bucket backup schedule finalizers")
+				return ctrl.Result{}, err
+			}
+
+			// Object updated - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		}
+	} else {
+		if containsString(schedule.ObjectMeta.Finalizers, bucketBackupScheduleFinalizerName) {
+			if err := r.releaseBackups(ctx, log, schedule); err != nil {
+				log.Error(err, "Failed to release bucket backups")
+				return ctrl.Result{}, err
+			}
+
+			schedule.ObjectMeta.Finalizers = removeString(schedule.ObjectMeta.Finalizers, bucketBackupScheduleFinalizerName)
+			if err := r.Update(ctx, schedule); err != nil {
+				log.Error(err, "Failed to delete bucket backup schedule finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
+	}
+
+	sched, err := cron.ParseStandard(schedule.Spec.Schedule)
+	if err != nil {
+		// retrying won't help until the schedule is fixed
+		log.Info("Invalid schedule", "Schedule", schedule.Spec.Schedule, "reason", err.Error())
+		return ctrl.Result{}, r.updateStatus(ctx, log, schedule, func(status *abv1.BucketBackupScheduleStatus) {
+			status.Message = fmt.Sprintf("invalid schedule %q: %v", schedule.Spec.Schedule, err)
+		})
+	}
+
+	backups, err := r.scheduleBackups(ctx, log, schedule)
+	if err != nil {
+		log.Error(err, "Failed to list bucket backups")
+		return ctrl.Result{}, err
+	}
+	// newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[j].CreationTimestamp.Before(&backups[i].CreationTimestamp)
+	})
+
+	active := false
+	var lastCompleted *abv1.BucketBackup
+	for _, backup := range backups {
+		if !backup.Status.Finished() {
+			active = true
+		} else if lastCompleted == nil && backup.Status.Phase == abv1.BucketBackupPhaseCompleted {
+			lastCompleted = backup
+		}
+	}
+
+	if err := r.deleteExpiredBackups(ctx, log, schedule, backups, lastCompleted); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	scheduledTime := lastMissedSchedule(sched, schedule, now)
+
+	if scheduledTime != nil && !schedule.Spec.Suspend && !active {
+		backup := backupForSchedule(schedule, *scheduledTime, lastCompleted)
+		log.Info("Creating a new BucketBackup", "BucketBackup.Name", backup.Name)
+		err = r.Create(ctx, backup)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			log.Error(err, "Failed to create new BucketBackup", "BucketBackup.Name", backup.Name)
+			return ctrl.Result{}, err
+		}
+
+		err = r.updateStatus(ctx, log, schedule, func(status *abv1.BucketBackupScheduleStatus) {
+			t := metav1.NewTime(*scheduledTime)
+			status.LastScheduleTime = &t
+			status.LastBackupName = backup.Name
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err = r.updateStatus(ctx, log, schedule, func(status *abv1.BucketBackupScheduleStatus) {
+		status.Message = ""
+		if lastCompleted != nil {
+			status.LastCompletedBackupName = lastCompleted.Name
+		}
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if scheduledTime != nil && active {
+		// the missed schedule runs once the active backup finishes, the backup changes trigger a new reconcile
+		log.Info("Backup still running. Postponing the scheduled backup")
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: sched.Next(now).Sub(now)}, nil
+}
+
+const bucketBackupScheduleFinalizerName = "ab.leclouddev.com/bucketbackupschedule-finalizer"
+
+const bucketBackupScheduleLabel = "bucketbackupschedule_cr"
+
+// bucketBackupScheduleUIDLabel tells apart the backups of a schedule recreated with the same name
+const bucketBackupScheduleUIDLabel = "bucketbackupschedule_uid"
+
+// maxMissedSchedules bounds the search of the missed schedules, e.g. after a long suspension
+const maxMissedSchedules = 1000
+
+// lastMissedSchedule returns the latest schedule time since the last scheduled backup, nil if none is due
+func lastMissedSchedule(sched cron.Schedule, schedule *abv1.BucketBackupSchedule, now time.Time) *time.Time {
+	last := schedule.CreationTimestamp.Time
+	if schedule.Status.LastScheduleTime != nil {
+		last = schedule.Status.LastScheduleTime.Time
+	}
+
+	var missed *time.Time
+	for i := 0; i < maxMissedSchedules; i++ {
+		next := sched.Next(last)
+		if next.After(now) {
+			break
+		}
+		missed = &next
+		last = next
+	}
+	return missed
+}
+
+// deleteExpiredBackups deletes the finished backups beyond the schedule keep count, the latest completed backup is always kept
+// as the base of the incremental backups. The backups are ordered newest first
+func (r *BucketBackupScheduleReconciler) deleteExpiredBackups(ctx context.Context, log logr.Logger, schedule *abv1.BucketBackupSchedule,
+	backups []*abv1.BucketBackup, lastCompleted *abv1.BucketBackup) error {
+	keep := int32(abv1.DefaultBackupScheduleKeep)
+	if schedule.Spec.Keep != nil {
+		keep = *schedule.Spec.Keep
+	}
+
+	var finished int32
+	for _, backup := range backups {
+		if !backup.Status.Finished() {
+			continue
+		}
+		finished++
+		if finished <= keep || backup == lastCompleted || !backup.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		log.Info("Deleting expired BucketBackup", "BucketBackup.Name", backup.Name)
+		if err := r.Delete(ctx, backup); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete expired BucketBackup", "BucketBackup.Name", backup.Name)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scheduleBackups returns the backups created by the schedule.
+// The backups created by earlier operator versions are owned by the schedule, the owner reference is removed
+// so that they aren't garbage collected with the schedule
+func (r *BucketBackupScheduleReconciler) scheduleBackups(ctx context.Context, log logr.Logger, schedule *abv1.BucketBackupSchedule) ([]*abv1.BucketBackup, error) {
+	backupList := &abv1.BucketBackupList{}
+	err := r.List(ctx, backupList, client.InNamespace(schedule.Namespace), client.MatchingLabels{bucketBackupScheduleLabel: schedule.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []*abv1.BucketBackup
+	for i := range backupList.Items {
+		backup := &backupList.Items[i]
+		if metav1.IsControlledBy(backup, schedule) {
+			log.Info("Removing the schedule owner reference of BucketBackup", "BucketBackup.Name", backup.Name)
+			backup.OwnerReferences = removeOwnerReference(backup.OwnerReferences, schedule.UID)
+			backup.Labels[bucketBackupScheduleUIDLabel] = string(schedule.UID)
+			if err := r.Update(ctx, backup); err != nil {
+				return nil, err
+			}
+		}
+		if backup.Labels[bucketBackupScheduleUIDLabel] == string(schedule.UID) {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
+// releaseBackups deletes the backups of the deleted schedule if its deletion policy is Delete, they are kept otherwise
+func (r *BucketBackupScheduleReconciler) releaseBackups(ctx context.Context, log logr.Logger, schedule *abv1.BucketBackupSchedule) error {
+	backups, err := r.scheduleBackups(ctx, log, schedule)
+	if err != nil {
+		return err
+	}
+	if schedule.Spec.DeletionPolicy != abv1.BackupScheduleDeletionPolicyDelete {
+		return nil
+	}
+
+	for _, backup := range backups {
+		if !backup.DeletionTimestamp.IsZero() {
+			continue
+		}
+		log.Info("Deleting BucketBackup of the deleted schedule", "BucketBackup.Name", backup.Name)
+		if err := r.Delete(ctx, backup); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOwnerReference returns the owner references without the owner
+func removeOwnerReference(refs []metav1.OwnerReference, owner types.UID) []metav1.OwnerReference {
+	var result []metav1.OwnerReference
+	for _, ref := range refs {
+		if ref.UID != owner {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// backupForSchedule returns the BucketBackup object of a scheduled time.
+// The schedule doesn't own the backup, so that deleting the schedule doesn't delete the backups with the garbage collector
+func backupForSchedule(schedule *abv1.BucketBackupSchedule, scheduledTime time.Time, lastCompleted *abv1.BucketBackup) *abv1.BucketBackup {
+	backup := &abv1.BucketBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      schedule.Name + "-" + strconv.FormatInt(scheduledTime.Unix(), 10),
+			Namespace: schedule.Namespace,
+			Labels: map[string]string{
+				"app":                        "ab",
+				bucketBackupScheduleLabel:    schedule.Name,
+				bucketBackupScheduleUIDLabel: string(schedule.UID),
+			},
+		},
+		Spec: abv1.BucketBackupSpec{
+			BucketName:  schedule.Spec.BucketName,
+			Destination: schedule.Spec.Destination,
+		},
+	}
+	if schedule.Spec.Incremental && lastCompleted != nil {
+		backup.Spec.BaseBackupName = lastCompleted.Name
+	}
+
+	return backup
+}
+
+// updateStatus applies the changes to the schedule status and updates it if it changed
+func (r *BucketBackupScheduleReconciler) updateStatus(ctx context.Context, log logr.Logger, schedule *abv1.BucketBackupSchedule,
+	change func(status *abv1.BucketBackupScheduleStatus)) error {
+	status := schedule.Status.DeepCopy()
+	change(status)
+	if reflect.DeepEqual(status, &schedule.Status) {
+		return nil
+	}
+
+	schedule.Status = *status
+	if err := r.Client.Status().Update(ctx, schedule); err != nil {
+		log.Error(err, "Failed to update bucket backup schedule status")
+		return err
+	}
+	return nil
+}
+
+func (r *BucketBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.BucketBackupSchedule{}).
+		Watches(&source.Kind{Type: &abv1.BucketBackup{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				// enqueue the schedule of the backup
+				name := obj.Meta.GetLabels()[bucketBackupScheduleLabel]
+				if name == "" {
+					return nil
+				}
+				return []reconcile.Request{
+					{NamespacedName: types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: name}},
+				}
+			}),
+		}).
+		Complete(r)
+}
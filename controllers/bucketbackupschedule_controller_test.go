@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("BucketBackupSchedule controller", func() {
+	now := time.Date(2020, 11, 2, 10, 30, 0, 0, time.UTC)
+
+	It("Should return the latest missed schedule", func() {
+		sched, err := cron.ParseStandard("0 3 * * *")
+		Expect(err).ToNot(HaveOccurred())
+
+		schedule := &abv1.BucketBackupSchedule{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-72 * time.Hour))},
+		}
+		Expect(lastMissedSchedule(sched, schedule, now)).To(Equal(timePtr(time.Date(2020, 11, 2, 3, 0, 0, 0, time.UTC))))
+
+		lastScheduleTime := metav1.NewTime(time.Date(2020, 11, 2, 3, 0, 0, 0, time.UTC))
+		schedule.Status.LastScheduleTime = &lastScheduleTime
+		Expect(lastMissedSchedule(sched, schedule, now)).To(BeNil())
+	})
+
+	It("Should delete the finished backups beyond the keep count except the latest completed one", func() {
+		ctx := context.Background()
+
+		keep := int32(2)
+		schedule := &abv1.BucketBackupSchedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "1234"},
+			Spec:       abv1.BucketBackupScheduleSpec{Keep: &keep},
+		}
+		newBackup := func(name string, phase abv1.BucketBackupPhase) *abv1.BucketBackup {
+			return &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Status:     abv1.BucketBackupStatus{Phase: phase},
+			}
+		}
+		// newest first
+		backups := []*abv1.BucketBackup{
+			newBackup("running", abv1.BucketBackupPhaseRunning),
+			newBackup("failed-1", abv1.BucketBackupPhaseFailed),
+			newBackup("failed-2", abv1.BucketBackupPhaseFailed),
+			newBackup("completed-1", abv1.BucketBackupPhaseCompleted),
+			newBackup("completed-2", abv1.BucketBackupPhaseCompleted),
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, backups[0], backups[1], backups[2], backups[3], backups[4])
+		r := &BucketBackupScheduleReconciler{Client: c, Log: ctrl.Log.WithName("schedule"), Scheme: scheme.Scheme}
+
+		Expect(r.deleteExpiredBackups(ctx, r.Log, schedule, backups, backups[3])).To(Succeed())
+
+		remaining := map[string]bool{}
+		for _, backup := range backups {
+			err := c.Get(ctx, types.NamespacedName{Name: backup.Name, Namespace: "default"}, &abv1.BucketBackup{})
+			if err == nil {
+				remaining[backup.Name] = true
+			} else {
+				Expect(errors.IsNotFound(err)).To(BeTrue())
+			}
+		}
+		Expect(remaining).To(Equal(map[string]bool{"running": true, "failed-1": true, "failed-2": true, "completed-1": true}))
+	})
+
+	It("Should keep the backups of a deleted schedule unless its deletion policy deletes them", func() {
+		ctx := context.Background()
+
+		schedule := &abv1.BucketBackupSchedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "1234"},
+		}
+		labels := map[string]string{bucketBackupScheduleLabel: "nightly", bucketBackupScheduleUIDLabel: "1234"}
+		backup := &abv1.BucketBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly-1", Namespace: "default", Labels: labels},
+		}
+		// created by an earlier operator version, owned by the schedule
+		legacyBackup := &abv1.BucketBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly-0", Namespace: "default", Labels: map[string]string{bucketBackupScheduleLabel: "nightly"}},
+		}
+		Expect(ctrl.SetControllerReference(schedule, legacyBackup, scheme.Scheme)).To(Succeed())
+		// created by a former schedule with the same name
+		formerBackup := &abv1.BucketBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly-former", Namespace: "default",
+				Labels: map[string]string{bucketBackupScheduleLabel: "nightly", bucketBackupScheduleUIDLabel: "5678"}},
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, backup, legacyBackup, formerBackup)
+		r := &BucketBackupScheduleReconciler{Client: c, Log: ctrl.Log.WithName("schedule"), Scheme: scheme.Scheme}
+
+		Expect(r.releaseBackups(ctx, r.Log, schedule)).To(Succeed())
+		updated := &abv1.BucketBackup{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: legacyBackup.Name, Namespace: "default"}, updated)).To(Succeed())
+		Expect(updated.OwnerReferences).To(BeEmpty())
+		Expect(updated.Labels[bucketBackupScheduleUIDLabel]).To(Equal("1234"))
+		Expect(c.Get(ctx, types.NamespacedName{Name: backup.Name, Namespace: "default"}, &abv1.BucketBackup{})).To(Succeed())
+
+		schedule.Spec.DeletionPolicy = abv1.BackupScheduleDeletionPolicyDelete
+		Expect(r.releaseBackups(ctx, r.Log, schedule)).To(Succeed())
+		for _, name := range []string{backup.Name, legacyBackup.Name} {
+			err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, &abv1.BucketBackup{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		}
+		Expect(c.Get(ctx, types.NamespacedName{Name: formerBackup.Name, Namespace: "default"}, &abv1.BucketBackup{})).To(Succeed())
+	})
+})
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,369 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/lib"
+)
+
+// BucketClaimReconciler reconciles a BucketClaim object
+type BucketClaimReconciler struct {
+	client.Client
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	BucketNamer lib.BucketNamer
+	// ProvisioningNamespace is the namespace of the Buckets bound to the claims
+	ProvisioningNamespace string
+	// BucketDefaults are the operator defaults applied to the Buckets
+	BucketDefaults abv1.BucketDefaults
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketclaims,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketclaims/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketclasses,verbs=get;list;watch
+
+func (r *BucketClaimReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("bucketclaim", req.NamespacedName)
+
+	claim := &abv1.BucketClaim{}
+	err := r.Get(ctx, req.NamespacedName, claim)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("BucketClaim resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get bucket claim")
+		return ctrl.Result{}, err
+	}
+
+	// the claim and the bound bucket live in different namespaces, owner references can't be used
+	if claim.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !containsString(claim.ObjectMeta.Finalizers, bucketClaimFinalizerName) {
+			claim.ObjectMeta.Finalizers = append(claim.ObjectMeta.Finalizers, bucketClaimFinalizerName)
+			if err := r.Update(ctx, claim); err != nil {
+				log.Error(err, "Failed to update bucket claim finalizers")
+				return ctrl.Result{}, err
+			}
+
+			// Object updated - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		}
+	} else {
+		if containsString(claim.ObjectMeta.Finalizers, bucketClaimFinalizerName) {
+			if err := r.releaseBucket(ctx, log, claim); err != nil {
+				log.Error(err, "Failed to release bound bucket")
+				return ctrl.Result{}, err
+			}
+
+			claim.ObjectMeta.Finalizers = removeString(claim.ObjectMeta.Finalizers, bucketClaimFinalizerName)
+			if err := r.Update(ctx, claim); err != nil {
+				log.Error(err, "Failed to delete bucket claim finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
+	}
+
+	if claim.Status.BucketName != "" {
+		return r.reconcileBound(ctx, log, claim)
+	}
+	if claim.Spec.BucketName != "" {
+		return r.bindBucket(ctx, log, claim)
+	}
+	return r.provisionBucket(ctx, log, claim)
+}
+
+const bucketClaimFinalizerName = "ab.leclouddev.com/bucketclaim-finalizer"
+
+// bucketClaimRetryInterval is the interval between binding attempts of pending claims
+const bucketClaimRetryInterval = time.Minute
+
+// provisionBucket creates a new bucket for the claim and binds it
+func (r *BucketClaimReconciler) provisionBucket(ctx context.Context, log logr.Logger, claim *abv1.BucketClaim) (ctrl.Result, error) {
+	bucket := &abv1.Bucket{}
+	err := r.Get(ctx, types.NamespacedName{Name: claimBucketName(claim), Namespace: r.ProvisioningNamespace}, bucket)
+	if err != nil && errors.IsNotFound(err) {
+		bucket, err := r.bucketForClaim(ctx, claim)
+		if err != nil {
+			log.Info("Can't provision bucket", "reason", err.Error())
+			return r.setPhase(ctx, log, claim, abv1.BucketClaimPhasePending, err.Error(), nil)
+		}
+
+		log.Info("Provisioning a new Bucket", "Bucket.Namespace", bucket.Namespace, "Bucket.Name", bucket.Name)
+		err = r.Create(ctx, bucket)
+		if err != nil && errors.IsForbidden(err) {
+			// the claim namespace quotas are enforced by the bucket webhook
+			log.Info("Can't provision bucket", "reason", err.Error())
+			return r.setPhase(ctx, log, claim, abv1.BucketClaimPhasePending, err.Error(), nil)
+		}
+		if err != nil {
+			log.Error(err, "Failed to create new Bucket", "Bucket.Name", bucket.Name)
+			return ctrl.Result{}, err
+		}
+
+		// created successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Bucket")
+		return ctrl.Result{}, err
+	}
+
+	return r.setPhase(ctx, log, claim, abv1.BucketClaimPhaseBound, "", bucket)
+}
+
+// bindBucket binds the claim to the existing bucket it requests
+func (r *BucketClaimReconciler) bindBucket(ctx context.Context, log logr.Logger, claim *abv1.BucketClaim) (ctrl.Result, error) {
+	bucket := &abv1.Bucket{}
+	err := r.Get(ctx, types.NamespacedName{Name: claim.Spec.BucketName, Namespace: r.ProvisioningNamespace}, bucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.setPhase(ctx, log, claim, abv1.BucketClaimPhasePending, fmt.Sprintf("bucket %q not found", claim.Spec.BucketName), nil)
+		}
+		log.Error(err, "Failed to get Bucket")
+		return ctrl.Result{}, err
+	}
+
+	// only the buckets pre-bound to the claim by an administrator can be bound
+	if bucket.Spec.ClaimRef == nil {
+		return r.setPhase(ctx, log, claim, abv1.BucketClaimPhasePending, fmt.Sprintf("bucket %q is not pre-bound to the claim", bucket.Name), nil)
+	}
+	if isPreBoundClaimRef(bucket.Spec.ClaimRef, claim) {
+		log.Info("Binding Bucket", "Bucket.Name", bucket.Name)
+		bucket.Spec.ClaimRef = claimRef(claim)
+		if err := r.Update(ctx, bucket); err != nil {
+			log.Error(err, "Failed to bind bucket")
+			return ctrl.Result{}, err
+		}
+
+		// updated successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+	if !isClaimRef(bucket.Spec.ClaimRef, claim) {
+		return r.setPhase(ctx, log, claim, abv1.BucketClaimPhasePending, fmt.Sprintf("bucket %q is bound to another claim", bucket.Name), nil)
+	}
+
+	return r.setPhase(ctx, log, claim, abv1.BucketClaimPhaseBound, "", bucket)
+}
+
+// reconcileBound checks that the bound bucket still exists and refreshes the claim status
+func (r *BucketClaimReconciler) reconcileBound(ctx context.Context, log logr.Logger, claim *abv1.BucketClaim) (ctrl.Result, error) {
+	bucket := &abv1.Bucket{}
+	err := r.Get(ctx, types.NamespacedName{Name: claim.Status.BucketName, Namespace: r.ProvisioningNamespace}, bucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.setPhase(ctx, log, claim, abv1.BucketClaimPhaseLost, fmt.Sprintf("bound bucket %q not found", claim.Status.BucketName), nil)
+		}
+		log.Error(err, "Failed to get Bucket")
+		return ctrl.Result{}, err
+	}
+	if !isClaimRef(bucket.Spec.ClaimRef, claim) {
+		return r.setPhase(ctx, log, claim, abv1.BucketClaimPhaseLost, fmt.Sprintf("bound bucket %q is bound to another claim", bucket.Name), nil)
+	}
+
+	return r.setPhase(ctx, log, claim, abv1.BucketClaimPhaseBound, "", bucket)
+}
+
+// setPhase updates the claim status if it changed, pending claims are retried periodically.
+// The bound bucket of the status is kept if bucket is nil
+func (r *BucketClaimReconciler) setPhase(ctx context.Context, log logr.Logger, claim *abv1.BucketClaim, phase abv1.BucketClaimPhase, message string, bucket *abv1.Bucket) (ctrl.Result, error) {
+	status := claim.Status.DeepCopy()
+	status.Phase = phase
+	status.Message = message
+	if bucket != nil {
+		status.BucketName = bucket.Name
+		status.FullName = bucket.Spec.FullName
+	}
+
+	if *status != claim.Status {
+		claim.Status = *status
+		if err := r.Client.Status().Update(ctx, claim); err != nil {
+			log.Error(err, "Failed to update bucket claim status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if phase == abv1.BucketClaimPhasePending {
+		return ctrl.Result{RequeueAfter: bucketClaimRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// releaseBucket deletes the bucket provisioned for the claim, or unbinds the existing bucket it requested.
+// The storage bucket of a deleted Bucket is handled per its OnDeletePolicy
+func (r *BucketClaimReconciler) releaseBucket(ctx context.Context, log logr.Logger, claim *abv1.BucketClaim) error {
+	name := claim.Status.BucketName
+	if name == "" {
+		name = claim.Spec.BucketName
+	}
+	if name == "" {
+		name = claimBucketName(claim)
+	}
+
+	bucket := &abv1.Bucket{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: r.ProvisioningNamespace}, bucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !isClaimRef(bucket.Spec.ClaimRef, claim) {
+		return nil
+	}
+
+	if bucket.Labels[bucketClaimLabel] != "" {
+		log.Info("Deleting provisioned Bucket", "Bucket.Name", bucket.Name)
+		err := r.Delete(ctx, bucket)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	// keep the pre-binding, a claim with the same namespace and name can bind the bucket again
+	log.Info("Unbinding Bucket", "Bucket.Name", bucket.Name)
+	bucket.Spec.ClaimRef.UID = ""
+	return r.Update(ctx, bucket)
+}
+
+// bucketForClaim returns a claim Bucket object
+func (r *BucketClaimReconciler) bucketForClaim(ctx context.Context, claim *abv1.BucketClaim) (*abv1.Bucket, error) {
+	cloud := claim.Spec.Cloud
+
+	var class *abv1.BucketClass
+	if claim.Spec.BucketClassName != "" {
+		class = &abv1.BucketClass{}
+		if err := r.Get(ctx, types.NamespacedName{Name: claim.Spec.BucketClassName}, class); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, fmt.Errorf("bucket class %q not found", claim.Spec.BucketClassName)
+			}
+			return nil, err
+		}
+		if cloud == "" {
+			cloud = class.Spec.Cloud
+		}
+	}
+	if cloud == "" {
+		return nil, fmt.Errorf("no cloud set by the claim nor by the bucket class")
+	}
+
+	// the full name is derived from the claim, the Bucket object name is unique per claim
+	fullName, err := r.BucketNamer.BucketName(string(cloud), "", claimBucketNamePrefix, claim.Namespace, claim.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := &abv1.Bucket{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimBucketName(claim),
+			Namespace: r.ProvisioningNamespace,
+			Labels: map[string]string{
+				"app":                          "ab",
+				bucketClaimLabel:               claim.Name,
+				abv1.BucketClaimNamespaceLabel: claim.Namespace,
+			},
+		},
+		Spec: abv1.BucketSpec{
+			Cloud:           cloud,
+			BucketClassName: claim.Spec.BucketClassName,
+			FullName:        fullName,
+			ClaimRef:        claimRef(claim),
+		},
+	}
+	// fill the remaining fields from the bucket class, then the operator defaults
+	if class != nil {
+		bucket.ApplyClass(class)
+	}
+	bucket.ApplyDefaults(r.BucketDefaults)
+
+	return bucket, nil
+}
+
+// claimBucketNamePrefix is the full name prefix of the provisioned buckets.
+// It differs from the workload default prefix so that "{prefix}-{namespace}-{name}" can't collide with a workload bucket
+const claimBucketNamePrefix = "abclaim"
+
+const bucketClaimLabel = "bucketclaim_cr"
+
+// claimBucketName returns the name of the Bucket provisioned for the claim
+func claimBucketName(claim *abv1.BucketClaim) string {
+	return "claim-" + string(claim.UID)
+}
+
+// claimRef returns a reference to the claim
+func claimRef(claim *abv1.BucketClaim) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: abv1.GroupVersion.String(),
+		Kind:       "BucketClaim",
+		Namespace:  claim.Namespace,
+		Name:       claim.Name,
+		UID:        claim.UID,
+	}
+}
+
+// isClaimRef returns true if ref references the claim
+func isClaimRef(ref *corev1.ObjectReference, claim *abv1.BucketClaim) bool {
+	return ref != nil && ref.Namespace == claim.Namespace && ref.Name == claim.Name && ref.UID == claim.UID
+}
+
+// isPreBoundClaimRef returns true if ref references the claim by namespace and name, without a UID yet
+func isPreBoundClaimRef(ref *corev1.ObjectReference, claim *abv1.BucketClaim) bool {
+	return ref != nil && ref.Namespace == claim.Namespace && ref.Name == claim.Name && ref.UID == ""
+}
+
+func (r *BucketClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.BucketClaim{}).
+		Watches(&source.Kind{Type: &abv1.Bucket{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				// enqueue the claim bound to the bucket
+				bucket, ok := obj.Object.(*abv1.Bucket)
+				if !ok || bucket.Spec.ClaimRef == nil {
+					return nil
+				}
+				return []reconcile.Request{
+					{NamespacedName: types.NamespacedName{Namespace: bucket.Spec.ClaimRef.Namespace, Name: bucket.Spec.ClaimRef.Name}},
+				}
+			}),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("BucketClaim controller", func() {
+	const (
+		NamespaceName = "default"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	// expectBound waits for the claim to be bound and returns the bound bucket
+	expectBound := func(ctx context.Context, claim *abv1.BucketClaim) *abv1.Bucket {
+		bucket := &abv1.Bucket{}
+		Eventually(func() error {
+			updatedClaim := &abv1.BucketClaim{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: claim.Name, Namespace: claim.Namespace}, updatedClaim)
+			if err != nil {
+				return err
+			}
+			if updatedClaim.Status.Phase != abv1.BucketClaimPhaseBound {
+				return fmt.Errorf("claim phase %v: %v", updatedClaim.Status.Phase, updatedClaim.Status.Message)
+			}
+
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: updatedClaim.Status.BucketName, Namespace: NamespaceName}, bucket)
+			if err != nil {
+				return err
+			}
+			if !isClaimRef(bucket.Spec.ClaimRef, updatedClaim) {
+				return fmt.Errorf("wrong claim ref %v", bucket.Spec.ClaimRef)
+			}
+			if updatedClaim.Status.FullName != bucket.Spec.FullName {
+				return fmt.Errorf("wrong full name %v", updatedClaim.Status.FullName)
+			}
+			return nil
+		}, timeout, interval).Should(BeNil())
+		return bucket
+	}
+
+	Context("When creating a bucket claim", func() {
+		It("Should provision and bind a new bucket, and delete it with the claim", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abclaim-default-test-claim", mock.Anything, mock.Anything).Return(nil)
+
+			claim := &abv1.BucketClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-claim",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketClaimSpec{
+					Cloud: abv1.BucketCloudGCP,
+				},
+			}
+			Expect(k8sClient.Create(ctx, claim)).Should(Succeed())
+
+			bucket := expectBound(ctx, claim)
+			Expect(bucket.Spec.FullName).To(Equal("abclaim-default-test-claim"))
+			Expect(bucket.Labels[bucketClaimLabel]).To(Equal("test-claim"))
+
+			Expect(k8sClient.Delete(ctx, claim)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: NamespaceName}, &abv1.Bucket{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("When creating a bucket claim for an existing bucket", func() {
+		It("Should bind the pre-bound bucket, and unbind it when the claim is deleted", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-test-static-bucket", mock.Anything, mock.Anything).Return(nil)
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-static-bucket",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-test-static-bucket",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+					ClaimRef:       &corev1.ObjectReference{Namespace: NamespaceName, Name: "test-static-claim"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			claim := &abv1.BucketClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-static-claim",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketClaimSpec{
+					BucketName: bucket.Name,
+				},
+			}
+			Expect(k8sClient.Create(ctx, claim)).Should(Succeed())
+
+			boundBucket := expectBound(ctx, claim)
+			Expect(boundBucket.Name).To(Equal(bucket.Name))
+
+			Expect(k8sClient.Delete(ctx, claim)).Should(Succeed())
+			Eventually(func() error {
+				updatedBucket := &abv1.Bucket{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: NamespaceName}, updatedBucket)
+				if err != nil {
+					return err
+				}
+				if updatedBucket.Spec.ClaimRef == nil || updatedBucket.Spec.ClaimRef.UID != "" {
+					return fmt.Errorf("bucket still bound")
+				}
+				return nil
+			}, timeout, interval).Should(BeNil())
+
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+		})
+
+		It("Should not bind a bucket that isn't pre-bound to the claim", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-test-unbound-bucket", mock.Anything, mock.Anything).Return(nil)
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-unbound-bucket",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-test-unbound-bucket",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			claim := &abv1.BucketClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-unbound-claim",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketClaimSpec{
+					BucketName: bucket.Name,
+				},
+			}
+			Expect(k8sClient.Create(ctx, claim)).Should(Succeed())
+
+			Eventually(func() (abv1.BucketClaimPhase, error) {
+				updatedClaim := &abv1.BucketClaim{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: claim.Name, Namespace: claim.Namespace}, updatedClaim)
+				return updatedClaim.Status.Phase, err
+			}, timeout, interval).Should(Equal(abv1.BucketClaimPhasePending))
+
+			updatedBucket := &abv1.Bucket{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: NamespaceName}, updatedBucket)).Should(Succeed())
+			Expect(updatedBucket.Spec.ClaimRef).To(BeNil())
+
+			Expect(k8sClient.Delete(ctx, claim)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+		})
+	})
+})
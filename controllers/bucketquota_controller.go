@@ -0,0 +1,175 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+)
+
+// BucketQuotaReconciler reconciles a BucketQuota object, reporting the namespace bucket usage
+type BucketQuotaReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	GCPSvc services.GCPSvc
+	// ClaimNamespace is the namespace of the Buckets provisioned for claims, counted against the claim namespace quotas
+	ClaimNamespace string
+}
+
+// bucketQuotaMeasureInterval is the interval between the storage bucket size measurements, listing the objects is expensive
+const bucketQuotaMeasureInterval = time.Hour
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketquotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch
+
+func (r *BucketQuotaReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("bucketquota", req.NamespacedName)
+
+	quota := &abv1.BucketQuota{}
+	err := r.Get(ctx, req.NamespacedName, quota)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("BucketQuota resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get bucket quota")
+		return ctrl.Result{}, err
+	}
+
+	buckets, err := abv1.ListQuotaBuckets(ctx, r, quota.Namespace, r.ClaimNamespace)
+	if err != nil {
+		log.Error(err, "Failed to list buckets")
+		return ctrl.Result{}, err
+	}
+
+	status := quota.Status.DeepCopy()
+	status.Buckets = 0
+	for i := range buckets {
+		b := &buckets[i]
+		if b.DeletionTimestamp.IsZero() && b.CountsAgainstQuota() {
+			status.Buckets++
+		}
+	}
+
+	result := ctrl.Result{}
+	if quota.Spec.MaxTotalBytes == nil {
+		status.TotalBytes = nil
+		status.MeasuredAt = nil
+	} else {
+		if status.MeasuredAt == nil || time.Since(status.MeasuredAt.Time) >= bucketQuotaMeasureInterval {
+			size, err := r.measureBuckets(ctx, log, buckets)
+			if err != nil {
+				log.Error(err, "Failed to measure buckets")
+				return ctrl.Result{}, err
+			}
+			now := metav1.Now()
+			status.TotalBytes = resource.NewQuantity(size, resource.BinarySI)
+			status.MeasuredAt = &now
+		}
+		result.RequeueAfter = bucketQuotaMeasureInterval - time.Since(status.MeasuredAt.Time)
+	}
+
+	// only update on changes to avoid triggering a new reconcile loop
+	if !equality.Semantic.DeepEqual(status, &quota.Status) {
+		quota.Status = *status
+		if err := r.Status().Update(ctx, quota); err != nil {
+			log.Error(err, "Failed to update bucket quota status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// measureBuckets returns the total size of the managed storage buckets
+func (r *BucketQuotaReconciler) measureBuckets(ctx context.Context, log logr.Logger, buckets []abv1.Bucket) (int64, error) {
+	var total int64
+	for i := range buckets {
+		b := &buckets[i]
+		if b.Status.CreatedAt == "" || !b.CountsAgainstQuota() {
+			continue
+		}
+
+		switch b.Spec.Cloud {
+		case abv1.BucketCloudGCP:
+			size, err := r.GCPSvc.GetBucketSize(ctx, b.Spec.FullName)
+			if err == services.ErrBucketNotFound {
+				log.Info("Storage Bucket not found. Skipping measurement", "Bucket.Name", b.Name, "Bucket.FullName", b.Spec.FullName)
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		default:
+			log.Info("Bucket Cloud unknown.", "Bucket.Cloud", b.Spec.Cloud)
+		}
+	}
+
+	return total, nil
+}
+
+func (r *BucketQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.BucketQuota{}).
+		Watches(&source.Kind{Type: &abv1.Bucket{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				// enqueue the quotas of the bucket namespace, the claim namespace for claim buckets
+				bucket, ok := obj.Object.(*abv1.Bucket)
+				if !ok {
+					return nil
+				}
+				namespace := bucket.QuotaNamespace(r.ClaimNamespace)
+				quotas := &abv1.BucketQuotaList{}
+				if err := r.List(context.Background(), quotas, client.InNamespace(namespace)); err != nil {
+					r.Log.Error(err, "Failed to list bucket quotas", "namespace", namespace)
+					return nil
+				}
+				var requests []reconcile.Request
+				for _, q := range quotas.Items {
+					requests = append(requests, reconcile.Request{
+						NamespacedName: types.NamespacedName{Namespace: q.Namespace, Name: q.Name},
+					})
+				}
+				return requests
+			}),
+		}).
+		Complete(r)
+}
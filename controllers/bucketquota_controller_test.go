@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("BucketQuota controller", func() {
+	const (
+		// the quota applies to the whole namespace, use a dedicated one
+		NamespaceName = "quota-test"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	Context("When creating buckets in a namespace with a quota", func() {
+		It("Should report the usage and block the buckets exceeding the quota", func() {
+			ctx := context.Background()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: NamespaceName}})).Should(Succeed())
+
+			maxBuckets := int32(1)
+			maxTotalBytes := resource.MustParse("1Gi")
+			quota := &abv1.BucketQuota{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-quota",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketQuotaSpec{
+					MaxBuckets:    &maxBuckets,
+					MaxTotalBytes: &maxTotalBytes,
+				},
+			}
+			Expect(k8sClient.Create(ctx, quota)).Should(Succeed())
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-quota-test-first", mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("GetBucketSize", mock.Anything, "ab-quota-test-first").Return(int64(1024), nil)
+
+			newBucket := func(name string) *abv1.Bucket {
+				return &abv1.Bucket{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: NamespaceName,
+					},
+					Spec: abv1.BucketSpec{
+						Cloud:          abv1.BucketCloudGCP,
+						FullName:       "ab-quota-test-" + name,
+						OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+					},
+				}
+			}
+
+			first := newBucket("first")
+			Expect(k8sClient.Create(ctx, first)).Should(Succeed())
+			Eventually(func() string {
+				updated := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: first.Name, Namespace: NamespaceName}, updated); err != nil {
+					return ""
+				}
+				return updated.Status.CreatedAt
+			}, timeout, interval).ShouldNot(BeEmpty())
+
+			Eventually(func() error {
+				updated := &abv1.BucketQuota{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: quota.Name, Namespace: NamespaceName}, updated); err != nil {
+					return err
+				}
+				if updated.Status.Buckets != 1 {
+					return fmt.Errorf("buckets %d", updated.Status.Buckets)
+				}
+				if updated.Status.TotalBytes == nil || updated.Status.TotalBytes.Value() != 1024 {
+					return fmt.Errorf("total bytes %v", updated.Status.TotalBytes)
+				}
+				return nil
+			}, timeout, interval).Should(BeNil())
+
+			second := newBucket("second")
+			Expect(k8sClient.Create(ctx, second)).Should(Succeed())
+			Eventually(func() bool {
+				updated := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: second.Name, Namespace: NamespaceName}, updated); err != nil {
+					return false
+				}
+				return updated.Status.IsConditionTrue(abv1.BucketConditionQuotaExceeded)
+			}, timeout, interval).Should(BeTrue())
+
+			for _, call := range gcpSvc.Calls {
+				if call.Method == "CreateBucket" {
+					Expect(call.Arguments.Get(1)).NotTo(Equal("ab-quota-test-second"))
+				}
+			}
+		})
+	})
+})
@@ -0,0 +1,304 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+)
+
+var (
+	// replicationLag reports the time since the start of the last completed sync pass of each replication
+	replicationLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "autobucket_replication_lag_seconds",
+		Help: "Time since the start of the last completed sync pass, the objects changed since may not be replicated yet",
+	}, []string{"namespace", "name"})
+	// replicationLastSync reports the start time of the last completed sync pass of each replication, for alerts computing the lag continuously
+	replicationLastSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "autobucket_replication_last_sync_timestamp_seconds",
+		Help: "Start time of the last completed sync pass as a Unix timestamp",
+	}, []string{"namespace", "name"})
+	// replicationObjectsCopied counts the objects copied by each replication
+	replicationObjectsCopied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "autobucket_replication_objects_copied_total",
+		Help: "Number of new or changed objects copied to the destination bucket",
+	}, []string{"namespace", "name"})
+	// replicationFailures counts the objects that couldn't be replicated by each replication
+	replicationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "autobucket_replication_failures_total",
+		Help: "Number of objects that couldn't be replicated",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(replicationLag, replicationLastSync, replicationObjectsCopied, replicationFailures)
+}
+
+// BucketReplicationReconciler reconciles a BucketReplication object
+type BucketReplicationReconciler struct {
+	client.Client
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	GCPSvc      services.GCPSvc
+	ClusterName string
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketreplications,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketreplications/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch
+
+func (r *BucketReplicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("bucketreplication", req.NamespacedName)
+
+	replication := &abv1.BucketReplication{}
+	err := r.Get(ctx, req.NamespacedName, replication)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("BucketReplication resource not found. Ignoring since object must be deleted")
+			r.deleteMetrics(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get bucket replication")
+		return ctrl.Result{}, err
+	}
+
+	if replication.Spec.Suspend {
+		return r.setPhase(ctx, log, replication, abv1.BucketReplicationPhaseSuspended, "")
+	}
+
+	source, err := r.getBucket(ctx, replication.Namespace, replication.Spec.SourceBucketName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	dest, err := r.getBucket(ctx, replication.Namespace, replication.Spec.DestinationBucketName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if source == nil || dest == nil {
+		result, err := r.setPhase(ctx, log, replication, abv1.BucketReplicationPhasePending,
+			fmt.Sprintf("buckets %q and %q must exist", replication.Spec.SourceBucketName, replication.Spec.DestinationBucketName))
+		if err != nil {
+			return result, err
+		}
+		return ctrl.Result{RequeueAfter: bucketReplicationRetryInterval}, nil
+	}
+	if message := validateReplication(source, dest); message != "" {
+		// retrying won't help until the spec or the buckets are fixed
+		return r.setPhase(ctx, log, replication, abv1.BucketReplicationPhaseFailed, message)
+	}
+
+	interval := bucketReplicationInterval(replication)
+	now := time.Now()
+	r.reportLag(replication, now)
+
+	if replication.Status.CurrentSync == nil {
+		if lastSync := replication.Status.LastSync; lastSync != nil {
+			if next := lastSync.StartedAt.Add(interval); now.Before(next) {
+				return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+			}
+		}
+
+		log.Info("Starting sync pass", "Source", source.Spec.FullName, "Destination", dest.Spec.FullName)
+		replication.Status.CurrentSync = &abv1.BucketReplicationSyncStatus{StartedAt: metav1.NewTime(now)}
+		replication.Status.Phase = abv1.BucketReplicationPhaseSyncing
+		replication.Status.Message = ""
+		if err := r.Client.Status().Update(ctx, replication); err != nil {
+			log.Error(err, "Failed to update bucket replication status")
+			return ctrl.Result{}, err
+		}
+
+		// updated successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	return r.syncBatch(ctx, log, replication, source, dest, interval)
+}
+
+// bucketReplicationRetryInterval is the interval between the attempts of pending replications
+const bucketReplicationRetryInterval = time.Minute
+
+// defaultBucketReplicationInterval is the default interval between the sync passes
+const defaultBucketReplicationInterval = 5 * time.Minute
+
+// bucketReplicationBatchSize is the number of objects compared per reconcile, the progress is saved in the status between batches
+const bucketReplicationBatchSize = 5000
+
+// bucketReplicationInterval returns the interval between the sync passes of the replication
+func bucketReplicationInterval(replication *abv1.BucketReplication) time.Duration {
+	if replication.Spec.Interval != nil && replication.Spec.Interval.Duration > 0 {
+		return replication.Spec.Interval.Duration
+	}
+	return defaultBucketReplicationInterval
+}
+
+// validateReplication returns why the buckets can't be replicated, empty if they can
+func validateReplication(source *abv1.Bucket, dest *abv1.Bucket) string {
+	if source.Spec.FullName == dest.Spec.FullName {
+		return "the source and destination buckets must differ"
+	}
+	if dest.Spec.ManagementPolicy == abv1.BucketManagementPolicyObserveOnly {
+		return fmt.Sprintf("destination bucket %q is observe-only and can't be replicated into", dest.Name)
+	}
+	if source.Spec.Cloud != abv1.BucketCloudGCP || dest.Spec.Cloud != abv1.BucketCloudGCP {
+		return fmt.Sprintf("replication from %s to %s buckets isn't supported, only gcp buckets can be replicated", source.Spec.Cloud, dest.Spec.Cloud)
+	}
+	return ""
+}
+
+// getBucket returns the named Bucket of the namespace, nil if it doesn't exist
+func (r *BucketReplicationReconciler) getBucket(ctx context.Context, namespace string, name string) (*abv1.Bucket, error) {
+	bucket := &abv1.Bucket{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, bucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return bucket, nil
+}
+
+// syncBatch replicates a batch of objects and reports the progress in the status, the completed sync pass becomes the last sync
+func (r *BucketReplicationReconciler) syncBatch(ctx context.Context, log logr.Logger, replication *abv1.BucketReplication,
+	source *abv1.Bucket, dest *abv1.Bucket, interval time.Duration) (ctrl.Result, error) {
+	status := &replication.Status
+	previous := status.DeepCopy()
+	sync := status.CurrentSync
+
+	owner := services.BucketOwner{ClusterID: r.ClusterName, UID: string(dest.UID)}
+	progress, err := r.GCPSvc.ReplicateGCPBucketObjects(ctx, source.Spec.FullName, dest.Spec.FullName, owner, replication.Spec.DeleteRemoved,
+		sync.LastObject, bucketReplicationBatchSize)
+	if err == services.ErrBucketNotFound {
+		result, err := r.setPhase(ctx, log, replication, abv1.BucketReplicationPhasePending, "storage buckets not found")
+		if err != nil {
+			return result, err
+		}
+		return ctrl.Result{RequeueAfter: bucketReplicationRetryInterval}, nil
+	}
+	if err == services.ErrBucketConflict {
+		return r.setPhase(ctx, log, replication, abv1.BucketReplicationPhaseFailed,
+			fmt.Sprintf("destination storage bucket %q is not owned by the Bucket", dest.Spec.FullName))
+	}
+	if err != nil {
+		log.Error(err, "Failed to replicate gcp Bucket objects", "Source", source.Spec.FullName, "Destination", dest.Spec.FullName)
+		return ctrl.Result{}, err
+	}
+
+	sync.ObjectsCompared += progress.Objects
+	sync.ObjectsCopied += progress.Copied
+	sync.ObjectsDeleted += progress.Deleted
+	sync.BytesCopied += progress.Bytes
+	sync.Failures += progress.Failures
+	if progress.LastError != "" {
+		sync.LastFailure = progress.LastError
+	}
+	if progress.LastObject != "" {
+		sync.LastObject = progress.LastObject
+	}
+	replicationObjectsCopied.WithLabelValues(replication.Namespace, replication.Name).Add(float64(progress.Copied))
+	replicationFailures.WithLabelValues(replication.Namespace, replication.Name).Add(float64(progress.Failures))
+
+	if progress.Done {
+		now := metav1.Now()
+		sync.CompletedAt = &now
+		sync.LastObject = ""
+		status.LastSync = sync
+		status.CurrentSync = nil
+		status.Phase = abv1.BucketReplicationPhaseSynced
+		status.Message = ""
+		if sync.Failures > 0 {
+			status.Phase = abv1.BucketReplicationPhaseFailed
+			status.Message = fmt.Sprintf("%d objects couldn't be replicated, retrying on the next sync pass", sync.Failures)
+		}
+		log.Info("Sync pass completed", "Phase", status.Phase, "Copied", sync.ObjectsCopied, "Deleted", sync.ObjectsDeleted,
+			"Failures", sync.Failures)
+	}
+
+	// only update on changes to avoid triggering a new reconcile loop
+	if !reflect.DeepEqual(previous, status) {
+		if err := r.Client.Status().Update(ctx, replication); err != nil {
+			log.Error(err, "Failed to update bucket replication status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !progress.Done {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	r.reportLag(replication, time.Now())
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// setPhase updates the replication phase if it changed, without requeuing
+func (r *BucketReplicationReconciler) setPhase(ctx context.Context, log logr.Logger, replication *abv1.BucketReplication,
+	phase abv1.BucketReplicationPhase, message string) (ctrl.Result, error) {
+	if replication.Status.Phase != phase || replication.Status.Message != message {
+		log.Info("Replication "+string(phase), "reason", message)
+		replication.Status.Phase = phase
+		replication.Status.Message = message
+		if err := r.Client.Status().Update(ctx, replication); err != nil {
+			log.Error(err, "Failed to update bucket replication status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// reportLag sets the lag metrics of the replication, from the start of its last completed sync pass
+func (r *BucketReplicationReconciler) reportLag(replication *abv1.BucketReplication, now time.Time) {
+	if replication.Status.LastSync == nil {
+		return
+	}
+	startedAt := replication.Status.LastSync.StartedAt.Time
+	replicationLag.WithLabelValues(replication.Namespace, replication.Name).Set(now.Sub(startedAt).Seconds())
+	replicationLastSync.WithLabelValues(replication.Namespace, replication.Name).Set(float64(startedAt.Unix()))
+}
+
+// deleteMetrics removes the metrics of a deleted replication
+func (r *BucketReplicationReconciler) deleteMetrics(name types.NamespacedName) {
+	replicationLag.DeleteLabelValues(name.Namespace, name.Name)
+	replicationLastSync.DeleteLabelValues(name.Namespace, name.Name)
+	replicationObjectsCopied.DeleteLabelValues(name.Namespace, name.Name)
+	replicationFailures.DeleteLabelValues(name.Namespace, name.Name)
+}
+
+func (r *BucketReplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.BucketReplication{}).
+		Complete(r)
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("BucketReplication controller", func() {
+	const (
+		NamespaceName = "default"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	Context("When replicating a bucket", func() {
+		It("Should compare the objects in batches and report the completed sync pass", func() {
+			ctx := context.Background()
+
+			newBucket := func(name string) *abv1.Bucket {
+				gcpSvc.On("CreateBucket", mock.Anything, "ab-default-"+name, mock.Anything, mock.Anything).Return(nil)
+				return &abv1.Bucket{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: NamespaceName,
+					},
+					Spec: abv1.BucketSpec{
+						Cloud:          abv1.BucketCloudGCP,
+						FullName:       "ab-default-" + name,
+						OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+					},
+				}
+			}
+			source := newBucket("replication-source")
+			Expect(k8sClient.Create(ctx, source)).Should(Succeed())
+			dest := newBucket("replication-dest")
+			Expect(k8sClient.Create(ctx, dest)).Should(Succeed())
+
+			gcpSvc.On("ReplicateGCPBucketObjects", mock.Anything, source.Spec.FullName, dest.Spec.FullName, mock.Anything, true, "", bucketReplicationBatchSize).
+				Return(&services.ReplicationProgress{Objects: 5000, Copied: 2, Bytes: 2048, LastObject: "b.txt"}, nil).Once()
+			gcpSvc.On("ReplicateGCPBucketObjects", mock.Anything, source.Spec.FullName, dest.Spec.FullName, mock.Anything, true, "b.txt", bucketReplicationBatchSize).
+				Return(&services.ReplicationProgress{Objects: 10, Copied: 1, Deleted: 1, Bytes: 1024, LastObject: "d.txt", Done: true}, nil).Once()
+
+			replication := &abv1.BucketReplication{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "replication-test",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketReplicationSpec{
+					SourceBucketName:      source.Name,
+					DestinationBucketName: dest.Name,
+					Interval:              &metav1.Duration{Duration: time.Hour},
+					DeleteRemoved:         true,
+				},
+			}
+			Expect(k8sClient.Create(ctx, replication)).Should(Succeed())
+
+			Eventually(func() error {
+				updated := &abv1.BucketReplication{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: replication.Name, Namespace: NamespaceName}, updated); err != nil {
+					return err
+				}
+				status := updated.Status
+				if status.Phase != abv1.BucketReplicationPhaseSynced || status.CurrentSync != nil || status.LastSync == nil {
+					return fmt.Errorf("wrong phase %v", status.Phase)
+				}
+				lastSync := status.LastSync
+				if lastSync.ObjectsCompared != 5010 || lastSync.ObjectsCopied != 3 || lastSync.ObjectsDeleted != 1 || lastSync.BytesCopied != 3072 {
+					return fmt.Errorf("wrong last sync %+v", lastSync)
+				}
+				return nil
+			}, timeout, interval).Should(BeNil())
+
+			Expect(k8sClient.Delete(ctx, replication)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, source)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, dest)).Should(Succeed())
+		})
+	})
+})
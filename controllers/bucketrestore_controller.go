@@ -0,0 +1,253 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+)
+
+// BucketRestoreReconciler reconciles a BucketRestore object
+type BucketRestoreReconciler struct {
+	client.Client
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	GCPSvc      services.GCPSvc
+	ClusterName string
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketrestores,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketrestores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketbackups,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch
+
+func (r *BucketRestoreReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("bucketrestore", req.NamespacedName)
+
+	restore := &abv1.BucketRestore{}
+	err := r.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("BucketRestore resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get bucket restore")
+		return ctrl.Result{}, err
+	}
+
+	if restore.Status.Finished() {
+		return ctrl.Result{}, nil
+	}
+
+	if (restore.Spec.BackupName == "") == (restore.Spec.PointInTime == nil) {
+		return r.setFailed(ctx, log, restore, "set either backupName or pointInTime")
+	}
+
+	bucket := &abv1.Bucket{}
+	err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.BucketName, Namespace: restore.Namespace}, bucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.setPending(ctx, log, restore, fmt.Sprintf("bucket %q not found", restore.Spec.BucketName))
+		}
+		log.Error(err, "Failed to get Bucket")
+		return ctrl.Result{}, err
+	}
+	if bucket.Spec.ManagementPolicy == abv1.BucketManagementPolicyObserveOnly {
+		return r.setFailed(ctx, log, restore, fmt.Sprintf("bucket %q is observe-only and can't be restored into", bucket.Name))
+	}
+	if bucket.Spec.Cloud != abv1.BucketCloudGCP {
+		return r.setFailed(ctx, log, restore, "only gcp buckets can be restored")
+	}
+
+	source := services.RestoreSource{}
+	if restore.Spec.BackupName != "" {
+		backup := &abv1.BucketBackup{}
+		err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.BackupName, Namespace: restore.Namespace}, backup)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return r.setPending(ctx, log, restore, fmt.Sprintf("backup %q not found", restore.Spec.BackupName))
+			}
+			log.Error(err, "Failed to get BucketBackup")
+			return ctrl.Result{}, err
+		}
+		if backup.Status.Phase == abv1.BucketBackupPhaseFailed {
+			return r.setFailed(ctx, log, restore, fmt.Sprintf("backup %q failed, only completed backups can be restored", backup.Name))
+		}
+		if backup.Status.Phase != abv1.BucketBackupPhaseCompleted {
+			return r.setPending(ctx, log, restore, fmt.Sprintf("backup %q not completed", backup.Name))
+		}
+		source.BucketName = backup.Status.BucketName
+		source.Prefix = backup.Status.Prefix
+	} else {
+		source.PointInTime = restore.Spec.PointInTime.Time
+	}
+
+	if restore.Status.StartedAt == nil {
+		now := metav1.Now()
+		restore.Status.Phase = abv1.BucketRestorePhaseRunning
+		restore.Status.Message = ""
+		restore.Status.StartedAt = &now
+
+		log.Info("Starting restore", "Bucket.Name", bucket.Name, "Backup", restore.Spec.BackupName, "PointInTime", restore.Spec.PointInTime)
+		if err := r.Client.Status().Update(ctx, restore); err != nil {
+			log.Error(err, "Failed to update bucket restore status")
+			return ctrl.Result{}, err
+		}
+
+		// updated successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	return r.restoreBatch(ctx, log, restore, bucket, source)
+}
+
+// bucketRestoreRetryInterval is the interval between the attempts of pending restores
+const bucketRestoreRetryInterval = time.Minute
+
+// bucketRestoreBatchSize is the number of objects restored per reconcile, the progress is saved in the status between batches
+const bucketRestoreBatchSize = 1000
+
+// restoreConflictPolicy returns the conflict policy of the restore. A point in time restore overwrites by default,
+// the objects modified since the point in time are the ones to restore
+func restoreConflictPolicy(restore *abv1.BucketRestore) services.RestoreConflictPolicy {
+	if restore.Spec.ConflictPolicy != "" {
+		return services.RestoreConflictPolicy(restore.Spec.ConflictPolicy)
+	}
+	if restore.Spec.PointInTime != nil {
+		return services.RestoreConflictOverwrite
+	}
+	return services.RestoreConflictSkip
+}
+
+// restoreBatch restores a batch of objects into the target bucket and reports the progress in the status
+func (r *BucketRestoreReconciler) restoreBatch(ctx context.Context, log logr.Logger, restore *abv1.BucketRestore, bucket *abv1.Bucket,
+	source services.RestoreSource) (ctrl.Result, error) {
+	conflict := restoreConflictPolicy(restore)
+
+	status := &restore.Status
+	owner := services.BucketOwner{ClusterID: r.ClusterName, UID: string(bucket.UID)}
+	progress, err := r.GCPSvc.RestoreGCPBucketObjects(ctx, bucket.Spec.FullName, owner, source, conflict, status.LastObject, bucketRestoreBatchSize)
+	if err == services.ErrBucketNotFound {
+		return r.setPending(ctx, log, restore, fmt.Sprintf("storage bucket %q not found", bucket.Spec.FullName))
+	}
+	if err == services.ErrBucketConflict {
+		return r.setFailed(ctx, log, restore, fmt.Sprintf("storage bucket %q is not owned by the Bucket", bucket.Spec.FullName))
+	}
+	if err == services.ErrSourceBucketNotFound {
+		return r.setFailed(ctx, log, restore, fmt.Sprintf("backup storage bucket %q not found", source.BucketName))
+	}
+	if err != nil {
+		log.Error(err, "Failed to restore gcp Bucket objects", "Bucket.FullName", bucket.Spec.FullName)
+		return ctrl.Result{}, err
+	}
+
+	previous := status.DeepCopy()
+	status.Phase = abv1.BucketRestorePhaseRunning
+	status.Message = ""
+	status.ObjectsRestored += progress.Objects
+	status.ObjectsSkipped += progress.Skipped
+	status.BytesRestored += progress.Bytes
+	status.Failures += progress.Failures
+	if progress.LastError != "" {
+		status.LastFailure = progress.LastError
+	}
+	if progress.LastObject != "" {
+		status.LastObject = progress.LastObject
+	}
+	finished := progress.Done || progress.Conflict != ""
+	if finished {
+		now := metav1.Now()
+		status.CompletedAt = &now
+		status.Phase = abv1.BucketRestorePhaseCompleted
+		if progress.Conflict != "" {
+			status.Phase = abv1.BucketRestorePhaseFailed
+			status.Message = fmt.Sprintf("object %q already exists in the target bucket", progress.Conflict)
+		} else if status.Failures > 0 {
+			status.Phase = abv1.BucketRestorePhaseFailed
+			status.Message = fmt.Sprintf("%d objects couldn't be restored", status.Failures)
+		}
+		log.Info("Restore finished", "Phase", status.Phase, "Objects", status.ObjectsRestored, "Skipped", status.ObjectsSkipped,
+			"Failures", status.Failures)
+	}
+
+	// only update on changes to avoid triggering a new reconcile loop
+	if !reflect.DeepEqual(previous, status) {
+		if err := r.Client.Status().Update(ctx, restore); err != nil {
+			log.Error(err, "Failed to update bucket restore status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !finished {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// setPending updates the status of a restore waiting for its target bucket or backup, and retries periodically
+func (r *BucketRestoreReconciler) setPending(ctx context.Context, log logr.Logger, restore *abv1.BucketRestore, message string) (ctrl.Result, error) {
+	if restore.Status.Phase != abv1.BucketRestorePhasePending || restore.Status.Message != message {
+		log.Info("Restore pending", "reason", message)
+		restore.Status.Phase = abv1.BucketRestorePhasePending
+		restore.Status.Message = message
+		if err := r.Client.Status().Update(ctx, restore); err != nil {
+			log.Error(err, "Failed to update bucket restore status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: bucketRestoreRetryInterval}, nil
+}
+
+// setFailed stops an invalid restore, retrying won't help
+func (r *BucketRestoreReconciler) setFailed(ctx context.Context, log logr.Logger, restore *abv1.BucketRestore, message string) (ctrl.Result, error) {
+	log.Info("Restore failed", "reason", message)
+	now := metav1.Now()
+	restore.Status.Phase = abv1.BucketRestorePhaseFailed
+	restore.Status.Message = message
+	restore.Status.CompletedAt = &now
+	if err := r.Client.Status().Update(ctx, restore); err != nil {
+		log.Error(err, "Failed to update bucket restore status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (r *BucketRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.BucketRestore{}).
+		Complete(r)
+}
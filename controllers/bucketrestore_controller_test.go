@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("BucketRestore controller", func() {
+	const (
+		NamespaceName = "default"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	Context("When restoring a point in time into a bucket", func() {
+		It("Should restore the object versions in batches and stop on a conflict with the fail policy", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-restore-target", mock.Anything, mock.Anything).Return(nil)
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "restore-target",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "ab-default-restore-target",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+				},
+			}
+			Expect(k8sClient.Create(ctx, bucket)).Should(Succeed())
+
+			pointInTime := metav1.NewTime(time.Date(2020, 11, 2, 10, 30, 0, 0, time.UTC))
+			source := services.RestoreSource{PointInTime: pointInTime.Time}
+			gcpSvc.On("RestoreGCPBucketObjects", mock.Anything, "ab-default-restore-target", mock.Anything, source, services.RestoreConflictFail, "", bucketRestoreBatchSize).
+				Return(&services.RestoreProgress{Objects: 2, Skipped: 1, Bytes: 2048, LastObject: "b.txt"}, nil).Once()
+			gcpSvc.On("RestoreGCPBucketObjects", mock.Anything, "ab-default-restore-target", mock.Anything, source, services.RestoreConflictFail, "b.txt", bucketRestoreBatchSize).
+				Return(&services.RestoreProgress{Objects: 1, Bytes: 1024, LastObject: "c.txt", Conflict: "d.txt"}, nil).Once()
+
+			restore := &abv1.BucketRestore{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "restore-test",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketRestoreSpec{
+					BucketName:     bucket.Name,
+					PointInTime:    &pointInTime,
+					ConflictPolicy: abv1.BucketRestoreConflictPolicyFail,
+				},
+			}
+			Expect(k8sClient.Create(ctx, restore)).Should(Succeed())
+
+			Eventually(func() error {
+				updated := &abv1.BucketRestore{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: restore.Name, Namespace: NamespaceName}, updated); err != nil {
+					return err
+				}
+				status := updated.Status
+				if status.Phase != abv1.BucketRestorePhaseFailed || status.Message != `object "d.txt" already exists in the target bucket` {
+					return fmt.Errorf("wrong phase %v: %v", status.Phase, status.Message)
+				}
+				if status.ObjectsRestored != 3 || status.ObjectsSkipped != 1 || status.BytesRestored != 3072 || status.LastObject != "c.txt" {
+					return fmt.Errorf("wrong status %+v", status)
+				}
+				return nil
+			}, timeout, interval).Should(BeNil())
+
+			Expect(k8sClient.Delete(ctx, restore)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, bucket)).Should(Succeed())
+		})
+	})
+
+	Context("When restoring a missing backup", func() {
+		It("Should wait for the backup", func() {
+			ctx := context.Background()
+
+			restore := &abv1.BucketRestore{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "restore-pending-test",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.BucketRestoreSpec{
+					BucketName: "restore-pending-target",
+					BackupName: "missing-backup",
+				},
+			}
+			Expect(k8sClient.Create(ctx, restore)).Should(Succeed())
+
+			Eventually(func() abv1.BucketRestorePhase {
+				updated := &abv1.BucketRestore{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: restore.Name, Namespace: NamespaceName}, updated); err != nil {
+					return ""
+				}
+				return updated.Status.Phase
+			}, timeout, interval).Should(Equal(abv1.BucketRestorePhasePending))
+
+			Expect(k8sClient.Delete(ctx, restore)).Should(Succeed())
+		})
+
+		It("Should fail the restore of a failed backup", func() {
+			ctx := context.Background()
+
+			bucket := &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore-target", Namespace: NamespaceName},
+				Spec:       abv1.BucketSpec{Cloud: abv1.BucketCloudGCP, FullName: "ab-default-restore-target"},
+			}
+			backup := &abv1.BucketBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "failed-backup", Namespace: NamespaceName},
+				Status:     abv1.BucketBackupStatus{Phase: abv1.BucketBackupPhaseFailed},
+			}
+			restore := &abv1.BucketRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore-failed-backup", Namespace: NamespaceName},
+				Spec:       abv1.BucketRestoreSpec{BucketName: bucket.Name, BackupName: backup.Name},
+			}
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, bucket, backup, restore)
+			r := &BucketRestoreReconciler{Client: c, Log: ctrl.Log.WithName("restore"), Scheme: scheme.Scheme}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: restore.Name, Namespace: NamespaceName}})
+			Expect(err).ToNot(HaveOccurred())
+			updated := &abv1.BucketRestore{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: restore.Name, Namespace: NamespaceName}, updated)).To(Succeed())
+			Expect(updated.Status.Phase).To(Equal(abv1.BucketRestorePhaseFailed))
+			Expect(updated.Status.Message).To(ContainSubstring("only completed backups can be restored"))
+		})
+	})
+
+	It("Should overwrite by default for point in time restores only", func() {
+		pointInTime := metav1.Now()
+		restore := &abv1.BucketRestore{Spec: abv1.BucketRestoreSpec{PointInTime: &pointInTime}}
+		Expect(restoreConflictPolicy(restore)).To(Equal(services.RestoreConflictOverwrite))
+
+		restore.Spec.ConflictPolicy = abv1.BucketRestoreConflictPolicySkip
+		Expect(restoreConflictPolicy(restore)).To(Equal(services.RestoreConflictSkip))
+
+		restore = &abv1.BucketRestore{Spec: abv1.BucketRestoreSpec{BackupName: "nightly"}}
+		Expect(restoreConflictPolicy(restore)).To(Equal(services.RestoreConflictSkip))
+	})
+})
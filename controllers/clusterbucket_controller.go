@@ -0,0 +1,221 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/lib"
+)
+
+// ClusterBucketReconciler reconciles a ClusterBucket object
+type ClusterBucketReconciler struct {
+	client.Client
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	BucketNamer lib.BucketNamer
+	// ProvisioningNamespace is the namespace of the Buckets backing the cluster buckets
+	ProvisioningNamespace string
+	// BucketDefaults are the operator defaults applied to the Buckets
+	BucketDefaults abv1.BucketDefaults
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=clusterbuckets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=clusterbuckets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketclasses,verbs=get;list;watch
+
+func (r *ClusterBucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("clusterbucket", req.Name)
+
+	clusterBucket := &abv1.ClusterBucket{}
+	err := r.Get(ctx, req.NamespacedName, clusterBucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// The backing Bucket is garbage collected through its owner reference.
+			// Return and don't requeue
+			log.Info("ClusterBucket resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get cluster bucket")
+		return ctrl.Result{}, err
+	}
+
+	if !clusterBucket.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	bucket := &abv1.Bucket{}
+	err = r.Get(ctx, types.NamespacedName{Name: clusterBucketBucketName(clusterBucket), Namespace: r.ProvisioningNamespace}, bucket)
+	if err != nil && errors.IsNotFound(err) {
+		bucket, err := r.bucketForClusterBucket(ctx, clusterBucket)
+		if err != nil {
+			log.Info("Can't provision bucket", "reason", err.Error())
+			return ctrl.Result{RequeueAfter: clusterBucketRetryInterval}, nil
+		}
+
+		log.Info("Provisioning a new Bucket", "Bucket.Namespace", bucket.Namespace, "Bucket.Name", bucket.Name)
+		err = r.Create(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to create new Bucket", "Bucket.Name", bucket.Name)
+			return ctrl.Result{}, err
+		}
+
+		// created successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Bucket")
+		return ctrl.Result{}, err
+	}
+
+	if !metav1.IsControlledBy(bucket, clusterBucket) {
+		log.Info("Bucket already exists and is not controlled by this cluster bucket. Ignoring", "Bucket.Name", bucket.Name)
+		return ctrl.Result{}, nil
+	}
+
+	// the on delete policy is the only mutable field propagated to the backing bucket
+	if clusterBucket.Spec.OnDeletePolicy != "" && clusterBucket.Spec.OnDeletePolicy != bucket.Spec.OnDeletePolicy {
+		bucket.Spec.OnDeletePolicy = clusterBucket.Spec.OnDeletePolicy
+
+		log.Info("Updating Bucket OnDeletePolicy", "Bucket.Name", bucket.Name, "Bucket.OnDeletePolicy", bucket.Spec.OnDeletePolicy)
+
+		if err := r.Update(ctx, bucket); err != nil {
+			log.Error(err, "Failed to update bucket")
+			return ctrl.Result{}, err
+		}
+
+		// updated successfully - return and requeue
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	status := abv1.ClusterBucketStatus{
+		BucketName: bucket.Name,
+		Cloud:      bucket.Spec.Cloud,
+		FullName:   bucket.Spec.FullName,
+		CreatedAt:  bucket.Status.CreatedAt,
+	}
+	if status != clusterBucket.Status {
+		clusterBucket.Status = status
+		if err := r.Client.Status().Update(ctx, clusterBucket); err != nil {
+			log.Error(err, "Failed to update cluster bucket status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// clusterBucketRetryInterval is the interval between provisioning attempts of cluster buckets
+const clusterBucketRetryInterval = time.Minute
+
+// clusterBucketNamespace is the namespace of the cluster buckets in the bucket name template
+const clusterBucketNamespace = "cluster"
+
+// clusterBucketNamePrefix is the full name prefix of the cluster buckets.
+// It differs from the workload default prefix so that a workload of the "cluster" namespace can't collide with a cluster bucket
+const clusterBucketNamePrefix = "abcluster"
+
+const clusterBucketLabel = "clusterbucket_cr"
+
+// clusterBucketBucketName returns the name of the Bucket backing the cluster bucket
+func clusterBucketBucketName(clusterBucket *abv1.ClusterBucket) string {
+	return "clusterbucket-" + clusterBucket.Name
+}
+
+// bucketForClusterBucket returns the Bucket object backing the cluster bucket
+func (r *ClusterBucketReconciler) bucketForClusterBucket(ctx context.Context, clusterBucket *abv1.ClusterBucket) (*abv1.Bucket, error) {
+	spec := clusterBucket.Spec
+
+	var class *abv1.BucketClass
+	if spec.BucketClassName != "" {
+		class = &abv1.BucketClass{}
+		if err := r.Get(ctx, types.NamespacedName{Name: spec.BucketClassName}, class); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, fmt.Errorf("bucket class %q not found", spec.BucketClassName)
+			}
+			return nil, err
+		}
+		if spec.Cloud == "" {
+			spec.Cloud = class.Spec.Cloud
+		}
+	}
+	if spec.Cloud == "" {
+		return nil, fmt.Errorf("no cloud set by the cluster bucket nor by the bucket class")
+	}
+
+	// the full name is derived from the cluster bucket, not from the provisioning namespace
+	if spec.FullName == "" {
+		fullName, err := r.BucketNamer.BucketName(string(spec.Cloud), "", clusterBucketNamePrefix, clusterBucketNamespace, clusterBucket.Name)
+		if err != nil {
+			return nil, err
+		}
+		spec.FullName = fullName
+	}
+
+	bucket := &abv1.Bucket{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterBucketBucketName(clusterBucket),
+			Namespace: r.ProvisioningNamespace,
+			Labels: map[string]string{
+				"app":              "ab",
+				clusterBucketLabel: clusterBucket.Name,
+			},
+		},
+		Spec: abv1.BucketSpec{
+			Cloud:            spec.Cloud,
+			BucketClassName:  spec.BucketClassName,
+			FullName:         spec.FullName,
+			OnDeletePolicy:   spec.OnDeletePolicy,
+			Location:         spec.Location,
+			StorageClass:     spec.StorageClass,
+			ManagementPolicy: spec.ManagementPolicy,
+		},
+	}
+	// fill the remaining fields from the bucket class, then the operator defaults
+	if class != nil {
+		bucket.ApplyClass(class)
+	}
+	bucket.ApplyDefaults(r.BucketDefaults)
+
+	// the cluster bucket owns the backing bucket, no namespace nor workload does
+	if err := ctrl.SetControllerReference(clusterBucket, bucket, r.Scheme); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+func (r *ClusterBucketReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.ClusterBucket{}).
+		Owns(&abv1.Bucket{}).
+		Complete(r)
+}
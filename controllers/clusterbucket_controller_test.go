@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/testsupport/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("ClusterBucket controller", func() {
+	const (
+		NamespaceName = "default"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	// expectPhase waits for the binding to reach the phase
+	expectPhase := func(ctx context.Context, binding *abv1.ClusterBucketBinding, phase abv1.ClusterBucketBindingPhase) {
+		Eventually(func() error {
+			updated := &abv1.ClusterBucketBinding{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}, updated); err != nil {
+				return err
+			}
+			if updated.Status.Phase != phase {
+				return fmt.Errorf("binding phase %v: %v", updated.Status.Phase, updated.Status.Message)
+			}
+			return nil
+		}, timeout, interval).Should(BeNil())
+	}
+
+	Context("When creating a cluster bucket and bindings", func() {
+		It("Should provision the bucket, and grant access to the allowed namespaces", func() {
+			ctx := context.Background()
+
+			fullName := "abcluster-cluster-test-shared"
+			gcpSvc.On("CreateBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("GrantBucketAccess", mock.Anything, fullName, "serviceAccount:reader@test-project.iam.gserviceaccount.com", "roles/storage.objectViewer").Return(nil)
+			gcpSvc.On("RevokeBucketAccess", mock.Anything, fullName, "serviceAccount:reader@test-project.iam.gserviceaccount.com", "roles/storage.objectViewer").Return(nil)
+
+			clusterBucket := &abv1.ClusterBucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-shared",
+				},
+				Spec: abv1.ClusterBucketSpec{
+					Cloud:             abv1.BucketCloudGCP,
+					AllowedNamespaces: []string{NamespaceName},
+				},
+			}
+			Expect(k8sClient.Create(ctx, clusterBucket)).Should(Succeed())
+
+			Eventually(func() string {
+				updated := &abv1.ClusterBucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: clusterBucket.Name}, updated); err != nil {
+					return ""
+				}
+				return updated.Status.CreatedAt
+			}, timeout, interval).ShouldNot(BeEmpty())
+
+			bucket := &abv1.Bucket{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "clusterbucket-test-shared", Namespace: NamespaceName}, bucket)).Should(Succeed())
+			Expect(bucket.Spec.FullName).To(Equal(fullName))
+			Expect(metav1.IsControlledBy(bucket, clusterBucket)).To(BeTrue())
+
+			binding := &abv1.ClusterBucketBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-shared-binding",
+					Namespace: NamespaceName,
+				},
+				Spec: abv1.ClusterBucketBindingSpec{
+					ClusterBucketName: clusterBucket.Name,
+					ServiceAccount:    "reader@test-project.iam.gserviceaccount.com",
+				},
+			}
+			Expect(k8sClient.Create(ctx, binding)).Should(Succeed())
+			expectPhase(ctx, binding, abv1.ClusterBucketBindingPhaseBound)
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: NamespaceName}, secret)).Should(Succeed())
+			Expect(string(secret.Data["BUCKET_NAME"])).To(Equal(fullName))
+			Expect(string(secret.Data["BUCKET_CLOUD"])).To(Equal("gcp"))
+			Expect(string(secret.Data["GCP_PROJECT"])).To(Equal("test-project"))
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "cluster-bucket-test"}})).Should(Succeed())
+			deniedBinding := &abv1.ClusterBucketBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-shared-binding",
+					Namespace: "cluster-bucket-test",
+				},
+				Spec: abv1.ClusterBucketBindingSpec{
+					ClusterBucketName: clusterBucket.Name,
+				},
+			}
+			Expect(k8sClient.Create(ctx, deniedBinding)).Should(Succeed())
+			expectPhase(ctx, deniedBinding, abv1.ClusterBucketBindingPhaseDenied)
+
+			Expect(k8sClient.Delete(ctx, binding)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: NamespaceName}, &abv1.ClusterBucketBinding{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+			gcpSvc.AssertCalled(GinkgoT(), "RevokeBucketAccess", mock.Anything, fullName, "serviceAccount:reader@test-project.iam.gserviceaccount.com", "roles/storage.objectViewer")
+		})
+
+		It("Should keep the access shared by several bindings until the last one is deleted", func() {
+			ctx := context.Background()
+
+			fullName := "abcluster-cluster-test-shared-sa"
+			member := "serviceAccount:shared@test-project.iam.gserviceaccount.com"
+			gcpSvc.On("CreateBucket", mock.Anything, fullName, mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("GrantBucketAccess", mock.Anything, fullName, member, "roles/storage.objectViewer").Return(nil)
+			gcpSvc.On("RevokeBucketAccess", mock.Anything, fullName, member, "roles/storage.objectViewer").Return(nil)
+
+			clusterBucket := &abv1.ClusterBucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-shared-sa",
+				},
+				Spec: abv1.ClusterBucketSpec{
+					Cloud:             abv1.BucketCloudGCP,
+					AllowedNamespaces: []string{"*"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, clusterBucket)).Should(Succeed())
+
+			var bindings []*abv1.ClusterBucketBinding
+			for _, name := range []string{"test-shared-sa-a", "test-shared-sa-b"} {
+				binding := &abv1.ClusterBucketBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: NamespaceName,
+					},
+					Spec: abv1.ClusterBucketBindingSpec{
+						ClusterBucketName: clusterBucket.Name,
+						ServiceAccount:    "shared@test-project.iam.gserviceaccount.com",
+					},
+				}
+				Expect(k8sClient.Create(ctx, binding)).Should(Succeed())
+				expectPhase(ctx, binding, abv1.ClusterBucketBindingPhaseBound)
+				bindings = append(bindings, binding)
+			}
+
+			expectDeleted := func(binding *abv1.ClusterBucketBinding) {
+				Expect(k8sClient.Delete(ctx, binding)).Should(Succeed())
+				Eventually(func() bool {
+					err := k8sClient.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: NamespaceName}, &abv1.ClusterBucketBinding{})
+					return errors.IsNotFound(err)
+				}, timeout, interval).Should(BeTrue())
+			}
+
+			expectDeleted(bindings[0])
+			gcpSvc.AssertNotCalled(GinkgoT(), "RevokeBucketAccess", mock.Anything, fullName, member, "roles/storage.objectViewer")
+
+			expectDeleted(bindings[1])
+			gcpSvc.AssertCalled(GinkgoT(), "RevokeBucketAccess", mock.Anything, fullName, member, "roles/storage.objectViewer")
+		})
+	})
+
+	Context("When the cluster bucket limits the bindings access", func() {
+		const fullName = "abcluster-cluster-limited"
+		var clusterBucket *abv1.ClusterBucket
+		var binding *abv1.ClusterBucketBinding
+		var svc *mocks.GCPSvc
+
+		reconcile := func() *abv1.ClusterBucketBinding {
+			ctx := context.Background()
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, clusterBucket, binding)
+			r := &ClusterBucketBindingReconciler{Client: c, Log: ctrl.Log.WithName("binding"), Scheme: scheme.Scheme, GCPSvc: svc, ProjectID: "test-project"}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: binding.Name, Namespace: NamespaceName}})
+			Expect(err).ToNot(HaveOccurred())
+			updated := &abv1.ClusterBucketBinding{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: NamespaceName}, updated)).To(Succeed())
+			return updated
+		}
+
+		BeforeEach(func() {
+			svc = new(mocks.GCPSvc)
+			clusterBucket = &abv1.ClusterBucket{
+				ObjectMeta: metav1.ObjectMeta{Name: "limited"},
+				Spec: abv1.ClusterBucketSpec{
+					Cloud:             abv1.BucketCloudGCP,
+					AllowedNamespaces: []string{NamespaceName},
+				},
+				Status: abv1.ClusterBucketStatus{
+					Cloud:     abv1.BucketCloudGCP,
+					FullName:  fullName,
+					CreatedAt: time.Now().Format(time.RFC3339),
+				},
+			}
+			binding = &abv1.ClusterBucketBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "limited-binding",
+					Namespace:  NamespaceName,
+					Finalizers: []string{clusterBucketBindingFinalizerName},
+				},
+				Spec: abv1.ClusterBucketBindingSpec{
+					ClusterBucketName: clusterBucket.Name,
+					ServiceAccount:    "writer@test-project.iam.gserviceaccount.com",
+				},
+			}
+		})
+
+		It("Should fail a binding of a service account outside the operator project", func() {
+			binding.Spec.ServiceAccount = "intruder@other-project.iam.gserviceaccount.com"
+
+			updated := reconcile()
+			Expect(updated.Status.Phase).To(Equal(abv1.ClusterBucketBindingPhaseFailed))
+			Expect(updated.Status.Message).To(ContainSubstring(`service account "intruder@other-project.iam.gserviceaccount.com" is not allowed`))
+			svc.AssertNotCalled(GinkgoT(), "GrantBucketAccess", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("Should fail a binding of a service account not listed by the cluster bucket", func() {
+			clusterBucket.Spec.AllowedServiceAccounts = []string{"reader@test-project.iam.gserviceaccount.com", "*@team-a.iam.gserviceaccount.com"}
+
+			updated := reconcile()
+			Expect(updated.Status.Phase).To(Equal(abv1.ClusterBucketBindingPhaseFailed))
+			svc.AssertNotCalled(GinkgoT(), "GrantBucketAccess", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("Should fail a read-write binding of a read-only cluster bucket", func() {
+			binding.Spec.Access = abv1.BucketAccessReadWrite
+
+			updated := reconcile()
+			Expect(updated.Status.Phase).To(Equal(abv1.ClusterBucketBindingPhaseFailed))
+			Expect(updated.Status.Message).To(ContainSubstring("access read-write is not allowed"))
+			svc.AssertNotCalled(GinkgoT(), "GrantBucketAccess", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("Should revoke the access granted before the cluster bucket limited it", func() {
+			member := "serviceAccount:writer@test-project.iam.gserviceaccount.com"
+			svc.On("RevokeBucketAccess", mock.Anything, fullName, member, "roles/storage.objectAdmin").Return(nil)
+			binding.Spec.Access = abv1.BucketAccessReadWrite
+			binding.Status = abv1.ClusterBucketBindingStatus{
+				Phase:                 abv1.ClusterBucketBindingPhaseBound,
+				FullName:              fullName,
+				GrantedServiceAccount: "writer@test-project.iam.gserviceaccount.com",
+				GrantedAccess:         abv1.BucketAccessReadWrite,
+			}
+
+			updated := reconcile()
+			Expect(updated.Status.Phase).To(Equal(abv1.ClusterBucketBindingPhaseFailed))
+			Expect(updated.Status.GrantedServiceAccount).To(BeEmpty())
+			svc.AssertCalled(GinkgoT(), "RevokeBucketAccess", mock.Anything, fullName, member, "roles/storage.objectAdmin")
+		})
+
+		It("Should grant the access allowed by the cluster bucket", func() {
+			member := "serviceAccount:writer@team-a.iam.gserviceaccount.com"
+			svc.On("GrantBucketAccess", mock.Anything, fullName, member, "roles/storage.objectAdmin").Return(nil)
+			clusterBucket.Spec.MaxAccess = abv1.BucketAccessReadWrite
+			clusterBucket.Spec.AllowedServiceAccounts = []string{"*@team-a.iam.gserviceaccount.com"}
+			binding.Spec.ServiceAccount = "writer@team-a.iam.gserviceaccount.com"
+			binding.Spec.Access = abv1.BucketAccessReadWrite
+
+			updated := reconcile()
+			Expect(updated.Status.Phase).To(Equal(abv1.ClusterBucketBindingPhaseBound))
+			svc.AssertCalled(GinkgoT(), "GrantBucketAccess", mock.Anything, fullName, member, "roles/storage.objectAdmin")
+		})
+	})
+})
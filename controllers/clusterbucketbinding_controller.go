@@ -0,0 +1,429 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+)
+
+// ClusterBucketBindingReconciler reconciles a ClusterBucketBinding object
+type ClusterBucketBindingReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	GCPSvc services.GCPSvc
+	// ProjectID is the GCP project of the storage buckets, added to the connection secrets
+	ProjectID string
+}
+
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=clusterbucketbindings,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=clusterbucketbindings/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=clusterbuckets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *ClusterBucketBindingReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("clusterbucketbinding", req.NamespacedName)
+
+	binding := &abv1.ClusterBucketBinding{}
+	err := r.Get(ctx, req.NamespacedName, binding)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("ClusterBucketBinding resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get cluster bucket binding")
+		return ctrl.Result{}, err
+	}
+
+	// the granted access must be revoked before the binding is deleted
+	if binding.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !containsString(binding.ObjectMeta.Finalizers, clusterBucketBindingFinalizerName) {
+			binding.ObjectMeta.Finalizers = append(binding.ObjectMeta.Finalizers, clusterBucketBindingFinalizerName)
+			if err := r.Update(ctx, binding); err != nil {
+				log.Error(err, "Failed to update cluster bucket binding finalizers")
+				return ctrl.Result{}, err
+			}
+
+			// Object updated - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		}
+	} else {
+		if containsString(binding.ObjectMeta.Finalizers, clusterBucketBindingFinalizerName) {
+			if err := r.revokeAccess(ctx, log, binding); err != nil {
+				log.Error(err, "Failed to revoke bucket access")
+				return ctrl.Result{}, err
+			}
+
+			binding.ObjectMeta.Finalizers = removeString(binding.ObjectMeta.Finalizers, clusterBucketBindingFinalizerName)
+			if err := r.Update(ctx, binding); err != nil {
+				log.Error(err, "Failed to delete cluster bucket binding finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
+	}
+
+	clusterBucket := &abv1.ClusterBucket{}
+	err = r.Get(ctx, types.NamespacedName{Name: binding.Spec.ClusterBucketName}, clusterBucket)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhasePending, fmt.Sprintf("cluster bucket %q not found", binding.Spec.ClusterBucketName))
+		}
+		log.Error(err, "Failed to get ClusterBucket")
+		return ctrl.Result{}, err
+	}
+
+	if !clusterBucket.AllowsNamespace(binding.Namespace) {
+		// withdraw the access granted while the namespace was allowed
+		if err := r.revokeAccess(ctx, log, binding); err != nil {
+			log.Error(err, "Failed to revoke bucket access")
+			return ctrl.Result{}, err
+		}
+		if err := r.deleteSecret(ctx, log, binding); err != nil {
+			log.Error(err, "Failed to delete connection secret")
+			return ctrl.Result{}, err
+		}
+		return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhaseDenied,
+			fmt.Sprintf("namespace %q is not allowed by cluster bucket %q", binding.Namespace, clusterBucket.Name))
+	}
+
+	if message := r.checkAccess(binding, clusterBucket); message != "" {
+		// withdraw the access granted before the cluster bucket limits changed
+		if err := r.revokeAccess(ctx, log, binding); err != nil {
+			log.Error(err, "Failed to revoke bucket access")
+			return ctrl.Result{}, err
+		}
+		return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhaseFailed, message)
+	}
+
+	if clusterBucket.Status.CreatedAt == "" {
+		return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhasePending,
+			fmt.Sprintf("storage bucket of cluster bucket %q not yet created", clusterBucket.Name))
+	}
+	binding.Status.FullName = clusterBucket.Status.FullName
+
+	if message, err := r.reconcileSecret(ctx, log, binding, clusterBucket); err != nil {
+		log.Error(err, "Failed to reconcile connection secret")
+		return ctrl.Result{}, err
+	} else if message != "" {
+		return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhasePending, message)
+	}
+
+	if err := r.reconcileAccess(ctx, log, binding, clusterBucket); err != nil {
+		if err == services.ErrBucketNotFound {
+			return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhasePending,
+				fmt.Sprintf("storage bucket %s not found", clusterBucket.Status.FullName))
+		}
+		log.Error(err, "Failed to grant bucket access")
+		return ctrl.Result{}, err
+	}
+
+	return r.setPhase(ctx, log, binding, abv1.ClusterBucketBindingPhaseBound, "")
+}
+
+const clusterBucketBindingFinalizerName = "ab.leclouddev.com/clusterbucketbinding-finalizer"
+
+// clusterBucketBindingRetryInterval is the interval between binding attempts of pending bindings
+const clusterBucketBindingRetryInterval = time.Minute
+
+// connection secret keys, usable as env variables with envFrom
+const (
+	connectionSecretBucketNameKey = "BUCKET_NAME"
+	connectionSecretCloudKey      = "BUCKET_CLOUD"
+	connectionSecretGCPProjectKey = "GCP_PROJECT"
+)
+
+// bindingSecretName returns the name of the connection secret of the binding
+func bindingSecretName(binding *abv1.ClusterBucketBinding) string {
+	if binding.Spec.SecretName != "" {
+		return binding.Spec.SecretName
+	}
+	return binding.Name
+}
+
+// reconcileSecret creates or updates the connection secret of the binding,
+// returns a pending message if the secret exists and is not controlled by the binding
+func (r *ClusterBucketBindingReconciler) reconcileSecret(ctx context.Context, log logr.Logger, binding *abv1.ClusterBucketBinding, clusterBucket *abv1.ClusterBucket) (string, error) {
+	data := map[string][]byte{
+		connectionSecretBucketNameKey: []byte(clusterBucket.Status.FullName),
+		connectionSecretCloudKey:      []byte(clusterBucket.Status.Cloud),
+	}
+	if clusterBucket.Status.Cloud == abv1.BucketCloudGCP && r.ProjectID != "" {
+		data[connectionSecretGCPProjectKey] = []byte(r.ProjectID)
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: bindingSecretName(binding), Namespace: binding.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      bindingSecretName(binding),
+				Namespace: binding.Namespace,
+				Labels: map[string]string{
+					"app":              "ab",
+					clusterBucketLabel: clusterBucket.Name,
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}
+		if err := ctrl.SetControllerReference(binding, secret, r.Scheme); err != nil {
+			return "", err
+		}
+
+		log.Info("Creating connection Secret", "Secret.Name", secret.Name)
+		return "", r.Create(ctx, secret)
+	} else if err != nil {
+		return "", err
+	}
+
+	if !metav1.IsControlledBy(secret, binding) {
+		return fmt.Sprintf("secret %q already exists and is not controlled by the binding", secret.Name), nil
+	}
+
+	if !reflect.DeepEqual(secret.Data, data) {
+		secret.Data = data
+
+		log.Info("Updating connection Secret", "Secret.Name", secret.Name)
+		return "", r.Update(ctx, secret)
+	}
+
+	return "", nil
+}
+
+// deleteSecret deletes the connection secret of the binding
+func (r *ClusterBucketBindingReconciler) deleteSecret(ctx context.Context, log logr.Logger, binding *abv1.ClusterBucketBinding) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: bindingSecretName(binding), Namespace: binding.Namespace}, secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !metav1.IsControlledBy(secret, binding) {
+		return nil
+	}
+
+	log.Info("Deleting connection Secret", "Secret.Name", secret.Name)
+	err = r.Delete(ctx, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// checkAccess returns a failure message if the cluster bucket doesn't allow the access requested by the binding,
+// the allowed service accounts default to the service accounts of the operator project
+func (r *ClusterBucketBindingReconciler) checkAccess(binding *abv1.ClusterBucketBinding, clusterBucket *abv1.ClusterBucket) string {
+	if binding.Spec.ServiceAccount == "" {
+		return ""
+	}
+
+	var defaults []string
+	if r.ProjectID != "" {
+		defaults = []string{"*@" + r.ProjectID + ".iam.gserviceaccount.com"}
+	}
+	if !clusterBucket.AllowsServiceAccount(binding.Spec.ServiceAccount, defaults) {
+		return fmt.Sprintf("service account %q is not allowed by cluster bucket %q", binding.Spec.ServiceAccount, clusterBucket.Name)
+	}
+	if !clusterBucket.AllowsAccess(binding.Spec.Access) {
+		return fmt.Sprintf("access %s is not allowed by cluster bucket %q", binding.Spec.Access, clusterBucket.Name)
+	}
+	return ""
+}
+
+// reconcileAccess grants the binding service account access to the storage bucket,
+// the access previously granted to another service account or level is revoked
+func (r *ClusterBucketBindingReconciler) reconcileAccess(ctx context.Context, log logr.Logger, binding *abv1.ClusterBucketBinding, clusterBucket *abv1.ClusterBucket) error {
+	access := binding.Spec.Access
+	if access == "" {
+		access = abv1.BucketAccessReadOnly
+	}
+
+	if binding.Status.GrantedServiceAccount != "" &&
+		(binding.Status.GrantedServiceAccount != binding.Spec.ServiceAccount || binding.Status.GrantedAccess != access) {
+		if err := r.revokeAccess(ctx, log, binding); err != nil {
+			return err
+		}
+	}
+
+	if binding.Spec.ServiceAccount == "" || binding.Status.GrantedServiceAccount != "" {
+		return nil
+	}
+
+	switch clusterBucket.Status.Cloud {
+	case abv1.BucketCloudGCP:
+		log.Info("Granting bucket access", "ServiceAccount", binding.Spec.ServiceAccount, "Access", access)
+		err := r.GCPSvc.GrantBucketAccess(ctx, clusterBucket.Status.FullName, gcpServiceAccountMember(binding.Spec.ServiceAccount), gcpAccessRole(access))
+		if err != nil {
+			return err
+		}
+	default:
+		log.Info("Bucket Cloud unknown.", "Bucket.Cloud", clusterBucket.Status.Cloud)
+		return nil
+	}
+
+	binding.Status.GrantedServiceAccount = binding.Spec.ServiceAccount
+	binding.Status.GrantedAccess = access
+	return nil
+}
+
+// revokeAccess revokes the access granted to the binding service account.
+// The role binding is shared by the bindings granting the same access to the same service account,
+// it is only revoked once no other binding holds it
+func (r *ClusterBucketBindingReconciler) revokeAccess(ctx context.Context, log logr.Logger, binding *abv1.ClusterBucketBinding) error {
+	if binding.Status.GrantedServiceAccount == "" {
+		return nil
+	}
+
+	shared, err := r.isAccessShared(ctx, binding)
+	if err != nil {
+		return err
+	}
+	if shared {
+		log.Info("Keeping bucket access granted to other bindings", "ServiceAccount", binding.Status.GrantedServiceAccount, "Access", binding.Status.GrantedAccess)
+	} else {
+		log.Info("Revoking bucket access", "ServiceAccount", binding.Status.GrantedServiceAccount, "Access", binding.Status.GrantedAccess)
+		// the access is only granted on gcp
+		err := r.GCPSvc.RevokeBucketAccess(ctx, binding.Status.FullName,
+			gcpServiceAccountMember(binding.Status.GrantedServiceAccount), gcpAccessRole(binding.Status.GrantedAccess))
+		if err != nil {
+			return err
+		}
+	}
+
+	binding.Status.GrantedServiceAccount = ""
+	binding.Status.GrantedAccess = ""
+	if !binding.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	return r.Client.Status().Update(ctx, binding)
+}
+
+// isAccessShared returns true if another binding of the same cluster bucket holds the access granted to the binding
+func (r *ClusterBucketBindingReconciler) isAccessShared(ctx context.Context, binding *abv1.ClusterBucketBinding) (bool, error) {
+	bindings := &abv1.ClusterBucketBindingList{}
+	if err := r.List(ctx, bindings); err != nil {
+		return false, err
+	}
+
+	for _, other := range bindings.Items {
+		if other.UID == binding.UID || !other.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if other.Spec.ClusterBucketName == binding.Spec.ClusterBucketName &&
+			other.Status.FullName == binding.Status.FullName &&
+			other.Status.GrantedServiceAccount == binding.Status.GrantedServiceAccount &&
+			gcpAccessRole(other.Status.GrantedAccess) == gcpAccessRole(binding.Status.GrantedAccess) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// gcpServiceAccountMember returns the IAM member of a GCP service account
+func gcpServiceAccountMember(serviceAccount string) string {
+	return "serviceAccount:" + serviceAccount
+}
+
+// gcpAccessRole returns the IAM role granting the access level on the bucket objects
+func gcpAccessRole(access abv1.BucketAccess) string {
+	if access == abv1.BucketAccessReadWrite {
+		return "roles/storage.objectAdmin"
+	}
+	return "roles/storage.objectViewer"
+}
+
+// setPhase updates the binding status if it changed, pending bindings are retried periodically
+func (r *ClusterBucketBindingReconciler) setPhase(ctx context.Context, log logr.Logger, binding *abv1.ClusterBucketBinding, phase abv1.ClusterBucketBindingPhase, message string) (ctrl.Result, error) {
+	// the binding status is updated in place during the reconcile, compare with the stored status
+	latest := &abv1.ClusterBucketBinding{}
+	if err := r.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}, latest); err != nil {
+		log.Error(err, "Failed to get cluster bucket binding")
+		return ctrl.Result{}, err
+	}
+
+	status := binding.Status.DeepCopy()
+	status.Phase = phase
+	status.Message = message
+
+	if *status != latest.Status {
+		latest.Status = *status
+		if err := r.Client.Status().Update(ctx, latest); err != nil {
+			log.Error(err, "Failed to update cluster bucket binding status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if phase == abv1.ClusterBucketBindingPhasePending {
+		return ctrl.Result{RequeueAfter: clusterBucketBindingRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *ClusterBucketBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&abv1.ClusterBucketBinding{}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &abv1.ClusterBucket{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				// enqueue the bindings of the cluster bucket in all namespaces
+				bindings := &abv1.ClusterBucketBindingList{}
+				if err := r.List(context.Background(), bindings); err != nil {
+					r.Log.Error(err, "Failed to list cluster bucket bindings")
+					return nil
+				}
+				var requests []reconcile.Request
+				for _, b := range bindings.Items {
+					if b.Spec.ClusterBucketName != obj.Meta.GetName() {
+						continue
+					}
+					requests = append(requests, reconcile.Request{
+						NamespacedName: types.NamespacedName{Namespace: b.Namespace, Name: b.Name},
+					})
+				}
+				return requests
+			}),
+		}).
+		Complete(r)
+}
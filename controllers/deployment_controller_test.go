@@ -35,7 +35,7 @@ var _ = Describe("Deployment controller", func() {
 		It("Should create the bucket crd", func() {
 			ctx := context.Background()
 
-			gcpSvc.On("CreateBucket", mock.AnythingOfType("*context.emptyCtx"), BucketFullName).Return(nil)
+			gcpSvc.On("CreateBucket", mock.AnythingOfType("*context.emptyCtx"), BucketFullName, mock.Anything, mock.Anything).Return(nil)
 
 			deployment = &appsv1.Deployment{
 				ObjectMeta: metav1.ObjectMeta{
@@ -99,4 +99,208 @@ var _ = Describe("Deployment controller", func() {
 		})
 	})
 
+	Context("When creating a deployment with multiple buckets", func() {
+		var deployment *appsv1.Deployment
+
+		It("Should create one bucket crd per bucket key and inject the bucket env", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abtest-default-multi-deployment-uploads", mock.Anything, mock.Anything).Return(nil)
+			gcpSvc.On("CreateBucket", mock.Anything, "abthumbs-default-multi-deployment-thumbnails", mock.Anything, mock.Anything).Return(nil)
+
+			deployment = &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "multi-deployment",
+					Namespace: NamespaceName,
+					Annotations: map[string]string{
+						"ab.leclouddev.com/cloud":                       "gcp",
+						"ab.leclouddev.com/name-prefix":                 "abtest",
+						"ab.leclouddev.com/buckets":                     "uploads,thumbnails",
+						"ab.leclouddev.com/thumbnails.name-prefix":      "abthumbs",
+						"ab.leclouddev.com/thumbnails.on-delete-policy": "destroy",
+					},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"app": "multi",
+						},
+					},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: map[string]string{
+								"app": "multi",
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								corev1.Container{
+									Name:  "test",
+									Image: "busybox",
+								},
+							},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).Should(Succeed())
+
+			expectedBuckets := map[string]abv1.BucketSpec{
+				"multi-deployment-uploads": abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "abtest-default-multi-deployment-uploads",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyIgnore,
+				},
+				"multi-deployment-thumbnails": abv1.BucketSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					FullName:       "abthumbs-default-multi-deployment-thumbnails",
+					OnDeletePolicy: abv1.BucketOnDeletePolicyDestroy,
+				},
+			}
+
+			// wait for buckets creation
+			for name, spec := range expectedBuckets {
+				name, spec := name, spec
+				Eventually(func() error {
+					bucket := &abv1.Bucket{}
+					err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: deployment.Namespace}, bucket)
+					if err != nil {
+						return err
+					}
+
+					if bucket.Spec != spec {
+						return fmt.Errorf("wrong spec %v", bucket.Spec)
+					}
+
+					return nil
+				}, timeout, interval).Should(BeNil())
+			}
+
+			// wait for env injection
+			Eventually(func() error {
+				updatedDeployment := &appsv1.Deployment{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, updatedDeployment)
+				if err != nil {
+					return err
+				}
+
+				env := map[string]string{}
+				for _, envVar := range updatedDeployment.Spec.Template.Spec.Containers[0].Env {
+					env[envVar.Name] = envVar.Value
+				}
+
+				if v := env["BUCKET_UPLOADS_NAME"]; v != "abtest-default-multi-deployment-uploads" {
+					return fmt.Errorf("wrong BUCKET_UPLOADS_NAME %v", v)
+				}
+				if v := env["BUCKET_THUMBNAILS_NAME"]; v != "abthumbs-default-multi-deployment-thumbnails" {
+					return fmt.Errorf("wrong BUCKET_THUMBNAILS_NAME %v", v)
+				}
+
+				return nil
+			}, timeout, interval).Should(BeNil())
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, deployment)).Should(Succeed())
+		})
+	})
+
+	Context("When changing and removing the autobucket annotations of a deployment", func() {
+		var deployment *appsv1.Deployment
+
+		It("Should report the ignored changes and keep the bucket with a warning condition", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abtest-default-removed-deployment", mock.Anything, mock.Anything).Return(nil)
+
+			deployment = &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "removed-deployment",
+					Namespace: NamespaceName,
+					Annotations: map[string]string{
+						"ab.leclouddev.com/cloud":       "gcp",
+						"ab.leclouddev.com/name-prefix": "abtest",
+					},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"app": "removed",
+						},
+					},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: map[string]string{
+								"app": "removed",
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								corev1.Container{
+									Name:  "test",
+									Image: "busybox",
+								},
+							},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).Should(Succeed())
+
+			bucketKey := types.NamespacedName{Name: "removed-deployment", Namespace: NamespaceName}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, bucketKey, &abv1.Bucket{})
+			}, timeout, interval).Should(BeNil())
+
+			updateAnnotations := func(annotations map[string]string) error {
+				updatedDeployment := &appsv1.Deployment{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, updatedDeployment)
+				if err != nil {
+					return err
+				}
+				updatedDeployment.Annotations = annotations
+				return k8sClient.Update(ctx, updatedDeployment)
+			}
+			conditionTrue := func(conditionType abv1.BucketConditionType) func() error {
+				return func() error {
+					bucket := &abv1.Bucket{}
+					err := k8sClient.Get(ctx, bucketKey, bucket)
+					if err != nil {
+						return err
+					}
+					if !bucket.Status.IsConditionTrue(conditionType) {
+						return fmt.Errorf("no %s condition: %v", conditionType, bucket.Status.Conditions)
+					}
+					return nil
+				}
+			}
+
+			// the full name can't change
+			Eventually(func() error {
+				return updateAnnotations(map[string]string{
+					"ab.leclouddev.com/cloud":       "gcp",
+					"ab.leclouddev.com/name-prefix": "abchanged",
+				})
+			}, timeout, interval).Should(BeNil())
+			Eventually(conditionTrue(abv1.BucketConditionAnnotationsIgnored), timeout, interval).Should(BeNil())
+
+			// the bucket is kept with the default keep policy
+			Eventually(func() error {
+				return updateAnnotations(nil)
+			}, timeout, interval).Should(BeNil())
+			Eventually(conditionTrue(abv1.BucketConditionAnnotationsRemoved), timeout, interval).Should(BeNil())
+
+			bucket := &abv1.Bucket{}
+			Expect(k8sClient.Get(ctx, bucketKey, bucket)).Should(Succeed())
+			Expect(metav1.IsControlledBy(bucket, deployment)).To(BeTrue())
+			Expect(bucket.Spec.FullName).To(Equal("abtest-default-removed-deployment"))
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, deployment)).Should(Succeed())
+		})
+	})
+
 })
@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+)
+
+// orphanBuckets reports the number of orphaned storage buckets found by the last sweep
+var orphanBuckets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "autobucket_orphan_buckets",
+	Help: "Number of storage buckets owned by the cluster without a matching Bucket object",
+}, []string{"cloud"})
+
+func init() {
+	metrics.Registry.MustRegister(orphanBuckets)
+}
+
+// OrphanSweeper finds the storage buckets stamped with the ownership labels of the cluster whose Bucket object no longer exists,
+// e.g. buckets released with the ignore on delete policy or whose Bucket object was removed while the operator was offline.
+// Orphans are reported and, unless in dry-run mode, marked as pending deletion so that the BucketSweeper destroys them after the grace period.
+// It runs on the leader only
+type OrphanSweeper struct {
+	client.Reader
+	Log         logr.Logger
+	GCPSvc      services.GCPSvc
+	ClusterName string
+	// Interval is the interval between sweeps
+	Interval time.Duration
+	// GracePeriod is the delay before the orphans are destroyed
+	GracePeriod time.Duration
+	// DryRun only reports the orphans
+	DryRun bool
+}
+
+// DefaultOrphanSweepInterval is the default interval between orphan sweeps
+const DefaultOrphanSweepInterval = 6 * time.Hour
+
+// DefaultOrphanGracePeriod is the default delay before the orphans are destroyed
+const DefaultOrphanGracePeriod = 7 * 24 * time.Hour
+
+// orphanMinAge is the minimum age of a storage bucket to be considered an orphan, leaving time for its Bucket object to reach the cache
+const orphanMinAge = 10 * time.Minute
+
+// Start sweeps the orphaned buckets periodically until the stop channel is closed
+func (s *OrphanSweeper) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultOrphanSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(ctx, time.Now()); err != nil {
+			// try again on the next tick
+			s.Log.Error(err, "Failed to sweep orphaned buckets")
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep reports the orphaned storage buckets at the given time and marks them as pending deletion unless in dry-run mode.
+// Buckets already pending deletion and buckets provisioned by the COSI driver are left alone
+func (s *OrphanSweeper) Sweep(ctx context.Context, now time.Time) error {
+	if s.ClusterName == "" && !s.DryRun {
+		// the buckets of every operator without cluster name carry the same empty cluster label
+		return errors.New("a cluster name is required to mark the orphaned buckets for deletion")
+	}
+
+	owned, err := s.GCPSvc.ListOwnedBuckets(ctx, s.ClusterName)
+	if err != nil {
+		return err
+	}
+
+	bucketList := &abv1.BucketList{}
+	err = s.List(ctx, bucketList)
+	if err != nil {
+		return err
+	}
+	uids := make(map[string]bool, len(bucketList.Items))
+	for _, bucket := range bucketList.Items {
+		uids[string(bucket.UID)] = true
+	}
+
+	gracePeriod := s.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultOrphanGracePeriod
+	}
+
+	orphans := 0
+	for _, b := range owned {
+		if b.PendingDeletion || b.SelfOwned || uids[b.Owner.UID] || now.Sub(b.Created) < orphanMinAge {
+			continue
+		}
+		orphans++
+
+		if s.DryRun {
+			s.Log.Info("Found orphaned Storage Bucket", "Bucket.Cloud", "gcp", "Bucket.FullName", b.Name, "Bucket.UID", b.Owner.UID, "DryRun", true)
+			continue
+		}
+
+		deleteAfter := now.Add(gracePeriod)
+		s.Log.Info("Found orphaned Storage Bucket. Marking for deletion", "Bucket.Cloud", "gcp", "Bucket.FullName", b.Name, "Bucket.UID", b.Owner.UID, "DeleteAfter", deleteAfter.Format(time.RFC3339))
+		err := s.GCPSvc.RetainGCPBucket(ctx, b.Name, b.Owner, deleteAfter)
+		if err != nil {
+			// keep sweeping the other buckets
+			s.Log.Error(err, "Failed to mark orphaned gcp Bucket for deletion", "Bucket.FullName", b.Name)
+		}
+	}
+	orphanBuckets.WithLabelValues("gcp").Set(float64(orphans))
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/services"
+	"github.com/didil/autobucket-operator/testsupport/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Orphan sweeper", func() {
+	now := time.Date(2020, 11, 2, 10, 30, 0, 0, time.UTC)
+	owner := func(uid string) services.BucketOwner {
+		return services.BucketOwner{ClusterID: "test", UID: uid}
+	}
+
+	var svc *mocks.GCPSvc
+	var sweeper *OrphanSweeper
+	var orphan services.OwnedBucket
+
+	BeforeEach(func() {
+		svc = new(mocks.GCPSvc)
+		orphan = services.OwnedBucket{Name: "ab-default-orphan", Owner: owner("1234"), Created: now.Add(-time.Hour)}
+		owned := []services.OwnedBucket{
+			orphan,
+			{Name: "ab-default-live", Owner: owner("5678"), Created: now.Add(-time.Hour)},
+			{Name: "ab-default-pending", Owner: owner("9012"), PendingDeletion: true, Created: now.Add(-time.Hour)},
+			{Name: "cosi-bucket", Owner: owner("cosi-bucket"), SelfOwned: true, Created: now.Add(-time.Hour)},
+			{Name: "ab-default-new", Owner: owner("3456"), Created: now.Add(-time.Minute)},
+		}
+		svc.On("ListOwnedBuckets", mock.Anything, "test").Return(owned, nil)
+
+		live := &abv1.Bucket{ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "default", UID: "5678"}}
+		sweeper = &OrphanSweeper{
+			Reader:      fake.NewFakeClientWithScheme(scheme.Scheme, live),
+			Log:         ctrl.Log.WithName("orphan-sweeper"),
+			GCPSvc:      svc,
+			ClusterName: "test",
+			GracePeriod: 24 * time.Hour,
+		}
+	})
+
+	It("Should mark the orphaned buckets for deletion after the grace period", func() {
+		svc.On("RetainGCPBucket", mock.Anything, orphan.Name, orphan.Owner, now.Add(24*time.Hour)).Return(nil)
+
+		Expect(sweeper.Sweep(context.Background(), now)).To(Succeed())
+
+		svc.AssertNumberOfCalls(GinkgoT(), "RetainGCPBucket", 1)
+		svc.AssertCalled(GinkgoT(), "RetainGCPBucket", mock.Anything, orphan.Name, orphan.Owner, now.Add(24*time.Hour))
+	})
+
+	It("Should refuse to mark the orphaned buckets for deletion without cluster name", func() {
+		sweeper.ClusterName = ""
+
+		Expect(sweeper.Sweep(context.Background(), now)).NotTo(Succeed())
+
+		svc.AssertNotCalled(GinkgoT(), "ListOwnedBuckets", mock.Anything, mock.Anything)
+		svc.AssertNotCalled(GinkgoT(), "RetainGCPBucket", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	It("Should only report the orphaned buckets in dry-run mode", func() {
+		sweeper.DryRun = true
+
+		Expect(sweeper.Sweep(context.Background(), now)).To(Succeed())
+
+		svc.AssertNotCalled(GinkgoT(), "RetainGCPBucket", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+})
@@ -22,6 +22,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -46,6 +47,11 @@ var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
 var gcpSvc = new(mocks.GCPSvc)
+var bucketDefaults = abv1.BucketDefaults{
+	OnDeletePolicy:  abv1.BucketOnDeletePolicyIgnore,
+	RetentionPeriod: abv1.DefaultRetentionPeriod,
+	NamePrefix:      "ab",
+}
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -76,6 +82,9 @@ var _ = BeforeSuite(func(done Done) {
 
 	// +kubebuilder:scaffold:scheme
 
+	// the buckets deleted with the ignore on delete policy are released
+	gcpSvc.On("ReleaseGCPBucket", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:             scheme.Scheme,
 		MetricsBindAddress: ":8081",
@@ -83,18 +92,93 @@ var _ = BeforeSuite(func(done Done) {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
-	err = (&DeploymentReconciler{
+	for _, kind := range WorkloadKinds {
+		err = (&WorkloadReconciler{
+			Client:         mgr.GetClient(),
+			Log:            ctrl.Log.WithName("controllers").WithName(kind.Name),
+			Scheme:         mgr.GetScheme(),
+			Kind:           kind,
+			BucketDefaults: bucketDefaults,
+		}).SetupWithManager(mgr)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	err = (&BucketReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("Bucket"),
+		Scheme:         mgr.GetScheme(),
+		GCPSvc:         gcpSvc,
+		ClusterName:    "test-cluster",
+		BucketDefaults: bucketDefaults,
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&BucketClaimReconciler{
+		Client:                mgr.GetClient(),
+		Log:                   ctrl.Log.WithName("controllers").WithName("BucketClaim"),
+		Scheme:                mgr.GetScheme(),
+		ProvisioningNamespace: "default",
+		BucketDefaults:        bucketDefaults,
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&ClusterBucketReconciler{
+		Client:                mgr.GetClient(),
+		Log:                   ctrl.Log.WithName("controllers").WithName("ClusterBucket"),
+		Scheme:                mgr.GetScheme(),
+		ProvisioningNamespace: "default",
+		BucketDefaults:        bucketDefaults,
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&ClusterBucketBindingReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("ClusterBucketBinding"),
+		Scheme:    mgr.GetScheme(),
+		GCPSvc:    gcpSvc,
+		ProjectID: "test-project",
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&BucketQuotaReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Deployment"),
+		Log:    ctrl.Log.WithName("controllers").WithName("BucketQuota"),
 		Scheme: mgr.GetScheme(),
+		GCPSvc: gcpSvc,
 	}).SetupWithManager(mgr)
 	Expect(err).ToNot(HaveOccurred())
 
-	err = (&BucketReconciler{
+	err = (&BucketBackupReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("BucketBackup"),
+		Scheme:        mgr.GetScheme(),
+		GCPSvc:        gcpSvc,
+		BackupBuckets: []string{"backups"},
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&BucketBackupScheduleReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Bucket"),
+		Log:    ctrl.Log.WithName("controllers").WithName("BucketBackupSchedule"),
 		Scheme: mgr.GetScheme(),
-		GCPSvc: gcpSvc,
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&BucketRestoreReconciler{
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("BucketRestore"),
+		Scheme:      mgr.GetScheme(),
+		GCPSvc:      gcpSvc,
+		ClusterName: "test-cluster",
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&BucketReplicationReconciler{
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("BucketReplication"),
+		Scheme:      mgr.GetScheme(),
+		GCPSvc:      gcpSvc,
+		ClusterName: "test-cluster",
 	}).SetupWithManager(mgr)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -0,0 +1,765 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	"github.com/didil/autobucket-operator/lib"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Workload is a kubernetes object that can own buckets through autobucket annotations
+type Workload interface {
+	metav1.Object
+	runtime.Object
+}
+
+// WorkloadKind describes a kind of workload that can own buckets
+type WorkloadKind struct {
+	// Name is the kind name, used in logs and bucket labels
+	Name string
+	// NewObject returns an empty object of the kind
+	NewObject func() Workload
+	// PodTemplate returns the workload pod template, nil if the template can't be updated
+	PodTemplate func(obj Workload) *corev1.PodTemplateSpec
+	// NewList returns an empty list of the kind
+	NewList func() runtime.Object
+	// WebhookPath is the path of the annotations validating webhook
+	WebhookPath string
+}
+
+var (
+	// DeploymentKind apps/v1 Deployment workloads
+	DeploymentKind = WorkloadKind{
+		Name:        "Deployment",
+		NewObject:   func() Workload { return &appsv1.Deployment{} },
+		NewList:     func() runtime.Object { return &appsv1.DeploymentList{} },
+		PodTemplate: func(obj Workload) *corev1.PodTemplateSpec { return &obj.(*appsv1.Deployment).Spec.Template },
+		WebhookPath: "/validate-apps-v1-deployment",
+	}
+	// StatefulSetKind apps/v1 StatefulSet workloads
+	StatefulSetKind = WorkloadKind{
+		Name:        "StatefulSet",
+		NewObject:   func() Workload { return &appsv1.StatefulSet{} },
+		NewList:     func() runtime.Object { return &appsv1.StatefulSetList{} },
+		PodTemplate: func(obj Workload) *corev1.PodTemplateSpec { return &obj.(*appsv1.StatefulSet).Spec.Template },
+		WebhookPath: "/validate-apps-v1-statefulset",
+	}
+	// DaemonSetKind apps/v1 DaemonSet workloads
+	DaemonSetKind = WorkloadKind{
+		Name:        "DaemonSet",
+		NewObject:   func() Workload { return &appsv1.DaemonSet{} },
+		NewList:     func() runtime.Object { return &appsv1.DaemonSetList{} },
+		PodTemplate: func(obj Workload) *corev1.PodTemplateSpec { return &obj.(*appsv1.DaemonSet).Spec.Template },
+		WebhookPath: "/validate-apps-v1-daemonset",
+	}
+	// JobKind batch/v1 Job workloads. Job pod templates are immutable so no env is injected
+	JobKind = WorkloadKind{
+		Name:        "Job",
+		NewObject:   func() Workload { return &batchv1.Job{} },
+		NewList:     func() runtime.Object { return &batchv1.JobList{} },
+		WebhookPath: "/validate-batch-v1-job",
+	}
+	// CronJobKind batch/v1beta1 CronJob workloads
+	CronJobKind = WorkloadKind{
+		Name:      "CronJob",
+		NewObject: func() Workload { return &batchv1beta1.CronJob{} },
+		NewList:   func() runtime.Object { return &batchv1beta1.CronJobList{} },
+		PodTemplate: func(obj Workload) *corev1.PodTemplateSpec {
+			return &obj.(*batchv1beta1.CronJob).Spec.JobTemplate.Spec.Template
+		},
+		WebhookPath: "/validate-batch-v1beta1-cronjob",
+	}
+)
+
+// WorkloadKinds lists all the workload kinds that can own buckets
+var WorkloadKinds = []WorkloadKind{DeploymentKind, StatefulSetKind, DaemonSetKind, JobKind, CronJobKind}
+
+// AnnotationRemovalPolicy defines what happens to the buckets of a workload that no longer requests them through its annotations
+type AnnotationRemovalPolicy string
+
+const (
+	// AnnotationRemovalPolicyKeep keeps the buckets owned by the workload with an AnnotationsRemoved condition,
+	// they are deleted with the workload according to their on delete policy
+	AnnotationRemovalPolicyKeep AnnotationRemovalPolicy = "keep"
+	// AnnotationRemovalPolicyDetach removes the workload owner reference, the buckets become standalone Bucket objects
+	AnnotationRemovalPolicyDetach AnnotationRemovalPolicy = "detach"
+	// AnnotationRemovalPolicyDelete deletes the Bucket objects right away, their on delete policy applies
+	AnnotationRemovalPolicyDelete AnnotationRemovalPolicy = "delete"
+)
+
+// WorkloadReconciler reconciles the buckets of annotated workloads of a given kind
+type WorkloadReconciler struct {
+	client.Client
+	Log         logr.Logger
+	Scheme      *runtime.Scheme
+	Kind        WorkloadKind
+	BucketNamer lib.BucketNamer
+	// AnnotationRemovalPolicy applies to the buckets no longer requested by the workload annotations, defaults to keep
+	AnnotationRemovalPolicy AnnotationRemovalPolicy
+	// BucketDefaults are the operator defaults applied to the Buckets
+	BucketDefaults abv1.BucketDefaults
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments/status;statefulsets/status;daemonsets/status,verbs=get
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs/status;cronjobs/status,verbs=get
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=buckets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ab.leclouddev.com,resources=bucketclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+func (r *WorkloadReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues(strings.ToLower(r.Kind.Name), req.NamespacedName)
+
+	obj := r.Kind.NewObject()
+	err := r.Get(ctx, req.NamespacedName, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			log.Info(r.Kind.Name + " resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get "+strings.ToLower(r.Kind.Name))
+		return ctrl.Result{}, err
+	}
+
+	var workloadBuckets []workloadBucket
+	if hasBucketAnnotations(obj) {
+		defaults, err := namespaceDefaultsFor(ctx, r, obj.GetNamespace())
+		if err != nil {
+			log.Error(err, "Failed to get namespace defaults")
+			return ctrl.Result{}, err
+		}
+
+		workloadBuckets, err = workloadBucketsFor(obj, defaults)
+		if err != nil {
+			// retrying won't help until the annotations are fixed, the existing buckets are left alone meanwhile
+			log.Error(err, "Invalid autobucket annotations")
+			return ctrl.Result{}, nil
+		}
+	}
+
+	var env []corev1.EnvVar
+	for _, wb := range workloadBuckets {
+		bucket, requeue, err := r.reconcileBucket(ctx, log, obj, wb)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if requeue {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		if bucket == nil {
+			continue
+		}
+
+		if wb.Key != "" {
+			env = append(env, corev1.EnvVar{Name: bucketEnvName(wb.Key), Value: bucket.Spec.FullName})
+		}
+	}
+
+	// release the buckets whose annotations or bucket key were removed
+	if err := r.releaseBuckets(ctx, log, obj, workloadBuckets); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if len(env) > 0 {
+		if r.Kind.PodTemplate == nil {
+			log.Info("Pod template can't be updated, skipping bucket env injection")
+			return ctrl.Result{}, nil
+		}
+
+		if injectBucketEnv(r.Kind.PodTemplate(obj), env) {
+			log.Info("Injecting bucket env")
+			if err := r.Update(ctx, obj); err != nil {
+				log.Error(err, "Failed to update "+strings.ToLower(r.Kind.Name)+" env")
+				return ctrl.Result{}, err
+			}
+
+			// updated successfully - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// reconcileBucket ensures the bucket crd for a workload bucket exists and is up to date.
+// The returned bucket is nil if the bucket crd is controlled by another object
+func (r *WorkloadReconciler) reconcileBucket(ctx context.Context, log logr.Logger, obj Workload, wb workloadBucket) (*abv1.Bucket, bool, error) {
+	// Check if the bucket object already exists, if not create a new one
+	bucket := &abv1.Bucket{}
+	err := r.Get(ctx, types.NamespacedName{Name: wb.bucketName(obj), Namespace: obj.GetNamespace()}, bucket)
+	if err != nil && errors.IsNotFound(err) {
+		// Define new
+		bucket, err := r.bucketForWorkload(ctx, obj, wb)
+		if err != nil {
+			log.Error(err, "Failed to build new Bucket", "Bucket.Name", wb.bucketName(obj))
+			return nil, false, err
+		}
+
+		log.Info("Creating a new Bucket", "Bucket.Name", bucket.Name)
+		err = r.Create(ctx, bucket)
+		if err != nil {
+			log.Error(err, "Failed to create new Bucket", "Bucket.Name", bucket.Name)
+			return nil, false, err
+		}
+
+		// created successfully - return and requeue
+		return bucket, true, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Bucket")
+		return nil, false, err
+	}
+
+	if !metav1.IsControlledBy(bucket, obj) {
+		// another workload with the same name already owns the bucket
+		log.Info("Bucket already exists and is not controlled by this "+strings.ToLower(r.Kind.Name)+". Ignoring", "Bucket.Name", bucket.Name)
+		return nil, false, nil
+	}
+
+	// check if bucket ondelete policy and deletion protection must be updated, the bucket class settings apply if unset.
+	// A protected bucket switched to the destroy policy is still only destroyed once confirmed
+	changed := false
+	if wb.OnDeletePolicy != "" && wb.OnDeletePolicy != bucket.Spec.OnDeletePolicy {
+		bucket.Spec.OnDeletePolicy = wb.OnDeletePolicy
+		changed = true
+
+		log.Info("Updating Bucket OnDeletePolicy", "Bucket.Name", bucket.Name, "Bucket.OnDeletePolicy", wb.OnDeletePolicy)
+	}
+	// the annotations can only raise the deletion protection, disabling it requires the destroy confirmation on the Bucket
+	if wb.deletionProtected() && !bucket.Spec.DeletionProtection {
+		bucket.Spec.DeletionProtection = true
+		changed = true
+
+		log.Info("Updating Bucket DeletionProtection", "Bucket.Name", bucket.Name, "Bucket.DeletionProtection", bucket.Spec.DeletionProtection)
+	} else if wb.DeletionProtection == "false" && bucket.Spec.DeletionProtection {
+		log.Info("Bucket DeletionProtection can't be disabled by the annotations. Ignoring", "Bucket.Name", bucket.Name)
+	}
+
+	if changed {
+		if err := r.Update(ctx, bucket); err != nil {
+			log.Error(err, "Failed to update bucket")
+			return nil, false, err
+		}
+
+		// updated successfully - return and requeue
+		return bucket, true, nil
+	}
+
+	if err := r.syncAnnotationConditions(ctx, log, obj, wb, bucket); err != nil {
+		return nil, false, err
+	}
+
+	return bucket, false, nil
+}
+
+// syncAnnotationConditions reports the workload annotation changes that can't apply to the bucket,
+// and clears the AnnotationsRemoved condition of a bucket requested again
+func (r *WorkloadReconciler) syncAnnotationConditions(ctx context.Context, log logr.Logger, obj Workload, wb workloadBucket, bucket *abv1.Bucket) error {
+	changes, err := r.immutableChanges(obj, wb, bucket)
+	if err != nil {
+		log.Error(err, "Failed to compute Bucket full name", "Bucket.Name", bucket.Name)
+		return err
+	}
+
+	changed := false
+	if len(changes) > 0 {
+		log.Info("Bucket cloud and full name can't change. Ignoring", "Bucket.Name", bucket.Name, "Changes", changes)
+		changed = bucket.Status.SetCondition(abv1.BucketConditionAnnotationsIgnored, corev1.ConditionTrue, "ImmutableChange",
+			fmt.Sprintf("the %s annotations request %s, the cloud and full name can't change once the bucket exists: delete the Bucket object to recreate it",
+				strings.ToLower(r.Kind.Name), strings.Join(changes, ", ")))
+	} else if bucket.Status.GetCondition(abv1.BucketConditionAnnotationsIgnored) != nil {
+		changed = bucket.Status.SetCondition(abv1.BucketConditionAnnotationsIgnored, corev1.ConditionFalse, "InSync", "")
+	}
+	if bucket.Status.GetCondition(abv1.BucketConditionAnnotationsRemoved) != nil {
+		changed = bucket.Status.SetCondition(abv1.BucketConditionAnnotationsRemoved, corev1.ConditionFalse, "Requested", "") || changed
+	}
+
+	if changed {
+		if err := r.Status().Update(ctx, bucket); err != nil {
+			log.Error(err, "Failed to update bucket status")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// immutableChanges describes the cloud and full name requested by the workload annotations that differ from the existing bucket
+func (r *WorkloadReconciler) immutableChanges(obj Workload, wb workloadBucket, bucket *abv1.Bucket) ([]string, error) {
+	var changes []string
+	if wb.Cloud == "" {
+		// the cloud is set by the bucket class
+		wb.Cloud = bucket.Spec.Cloud
+	} else if wb.Cloud != bucket.Spec.Cloud {
+		changes = append(changes, fmt.Sprintf("cloud %q instead of %q", wb.Cloud, bucket.Spec.Cloud))
+	}
+
+	fullName, err := wb.fullName(r.BucketNamer, obj)
+	if err != nil {
+		return nil, err
+	}
+	if fullName != bucket.Spec.FullName {
+		changes = append(changes, fmt.Sprintf("full name %q instead of %q", fullName, bucket.Spec.FullName))
+	}
+
+	return changes, nil
+}
+
+// releaseBuckets applies the annotation removal policy to the buckets controlled by the workload that its annotations no longer request
+func (r *WorkloadReconciler) releaseBuckets(ctx context.Context, log logr.Logger, obj Workload, workloadBuckets []workloadBucket) error {
+	bucketList := &abv1.BucketList{}
+	err := r.List(ctx, bucketList, client.InNamespace(obj.GetNamespace()), client.MatchingLabels{workloadCRKey(r.Kind): obj.GetName()})
+	if err != nil {
+		log.Error(err, "Failed to list buckets")
+		return err
+	}
+
+	requested := map[string]bool{}
+	for _, wb := range workloadBuckets {
+		requested[wb.bucketName(obj)] = true
+	}
+
+	for i := range bucketList.Items {
+		bucket := &bucketList.Items[i]
+		if requested[bucket.Name] || !metav1.IsControlledBy(bucket, obj) || !bucket.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		switch r.AnnotationRemovalPolicy {
+		case AnnotationRemovalPolicyDetach:
+			log.Info("Bucket no longer requested. Detaching from "+strings.ToLower(r.Kind.Name), "Bucket.Name", bucket.Name)
+			var refs []metav1.OwnerReference
+			for _, ref := range bucket.OwnerReferences {
+				if ref.UID != obj.GetUID() {
+					refs = append(refs, ref)
+				}
+			}
+			bucket.OwnerReferences = refs
+			delete(bucket.Labels, workloadCRKey(r.Kind))
+			delete(bucket.Labels, bucketKeyLabel)
+			if err := r.Update(ctx, bucket); err != nil {
+				log.Error(err, "Failed to detach bucket", "Bucket.Name", bucket.Name)
+				return err
+			}
+		case AnnotationRemovalPolicyDelete:
+			log.Info("Bucket no longer requested. Deleting", "Bucket.Name", bucket.Name, "Bucket.OnDeletePolicy", bucket.Spec.OnDeletePolicy)
+			if err := r.Delete(ctx, bucket); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to delete bucket", "Bucket.Name", bucket.Name)
+				return err
+			}
+		default:
+			if bucket.Status.SetCondition(abv1.BucketConditionAnnotationsRemoved, corev1.ConditionTrue, "NotRequested",
+				fmt.Sprintf("the %s %s no longer requests the bucket, it's deleted with the %s according to its on delete policy",
+					strings.ToLower(r.Kind.Name), obj.GetName(), strings.ToLower(r.Kind.Name))) {
+				log.Info("Bucket no longer requested. Keeping", "Bucket.Name", bucket.Name)
+				if err := r.Status().Update(ctx, bucket); err != nil {
+					log.Error(err, "Failed to update bucket status", "Bucket.Name", bucket.Name)
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+const bucketCloudKey = "ab.leclouddev.com/cloud"
+const bucketNamePrefixKey = "ab.leclouddev.com/name-prefix"
+const bucketOnDeletePolicyKey = "ab.leclouddev.com/on-delete-policy"
+const bucketsKey = "ab.leclouddev.com/buckets"
+const bucketNameTemplateKey = "ab.leclouddev.com/name-template"
+const bucketClassKey = "ab.leclouddev.com/class"
+const bucketDeletionProtectionKey = "ab.leclouddev.com/deletion-protection"
+
+// workloadBucket is a bucket requested by a workload through its annotations
+type workloadBucket struct {
+	// Key identifies the bucket within the workload, empty for the single bucket named after the workload
+	Key            string
+	Cloud          abv1.BucketCloud
+	NamePrefix     string
+	NameTemplate   string
+	OnDeletePolicy abv1.BucketOnDeletePolicy
+	ClassName      string
+	// DeletionProtection is "true" or "false", empty if unset
+	DeletionProtection string
+}
+
+// deletionProtected returns true if the workload bucket is protected against deletion
+func (wb workloadBucket) deletionProtected() bool {
+	return wb.DeletionProtection == "true"
+}
+
+// bucketName returns the name of the bucket crd
+func (wb workloadBucket) bucketName(obj Workload) string {
+	if wb.Key == "" {
+		return obj.GetName()
+	}
+	return obj.GetName() + "-" + wb.Key
+}
+
+// fullName returns the cloud storage bucket full name
+func (wb workloadBucket) fullName(namer lib.BucketNamer, obj Workload) (string, error) {
+	return namer.BucketName(string(wb.Cloud), wb.NameTemplate, wb.NamePrefix, obj.GetNamespace(), wb.bucketName(obj))
+}
+
+// bucketAnnotationKey returns the per-bucket override key of a workload annotation,
+// e.g. "ab.leclouddev.com/uploads.cloud" for the "uploads" bucket
+func bucketAnnotationKey(key, annotation string) string {
+	parts := strings.SplitN(annotation, "/", 2)
+	return parts[0] + "/" + key + "." + parts[1]
+}
+
+// hasBucketAnnotations returns true if the workload requests buckets through its annotations,
+// any workload annotation requests buckets as the others can be set by the namespace defaults
+func hasBucketAnnotations(obj Workload) bool {
+	annotations := obj.GetAnnotations()
+	for _, key := range []string{bucketCloudKey, bucketsKey, bucketClassKey, bucketNamePrefixKey, bucketOnDeletePolicyKey, bucketNameTemplateKey,
+		bucketDeletionProtectionKey} {
+		if annotations[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceDefaultKeys are the annotations a Namespace can carry as defaults for its workloads
+var namespaceDefaultKeys = []string{bucketCloudKey, bucketNamePrefixKey, bucketOnDeletePolicyKey, bucketClassKey, bucketDeletionProtectionKey}
+
+// namespaceDefaults returns the default annotations of the namespace
+func namespaceDefaults(ns metav1.Object) map[string]string {
+	defaults := map[string]string{}
+	for _, key := range namespaceDefaultKeys {
+		if v := ns.GetAnnotations()[key]; v != "" {
+			defaults[key] = v
+		}
+	}
+	return defaults
+}
+
+// namespaceDefaultsFor returns the default annotations of the named namespace, none if it doesn't exist
+func namespaceDefaultsFor(ctx context.Context, c client.Reader, name string) (map[string]string, error) {
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, ns); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return namespaceDefaults(ns), nil
+}
+
+// workloadBucketsFor returns the buckets requested by the workload annotations,
+// the namespace defaults apply to the annotations omitted by the workload.
+// The workload no longer requests buckets if none of them has a cloud or a bucket class, e.g. once the cloud annotation is removed
+func workloadBucketsFor(obj Workload, defaults map[string]string) ([]workloadBucket, error) {
+	annotations := obj.GetAnnotations()
+
+	var workloadBuckets []workloadBucket
+
+	bucketsAnnotation := strings.TrimSpace(annotations[bucketsKey])
+	if bucketsAnnotation == "" {
+		// single bucket named after the workload
+		workloadBuckets = append(workloadBuckets, newWorkloadBucket(annotations, defaults, ""))
+	} else {
+		seen := map[string]bool{}
+		for _, key := range strings.Split(bucketsAnnotation, ",") {
+			key = strings.TrimSpace(key)
+			if errs := validation.IsDNS1123Label(key); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid bucket key %q in %s: %s", key, bucketsKey, strings.Join(errs, ", "))
+			}
+			if seen[key] {
+				return nil, fmt.Errorf("duplicate bucket key %q in %s", key, bucketsKey)
+			}
+			seen[key] = true
+
+			workloadBuckets = append(workloadBuckets, newWorkloadBucket(annotations, defaults, key))
+		}
+	}
+
+	requested := false
+	for _, wb := range workloadBuckets {
+		if wb.Cloud != "" || wb.ClassName != "" {
+			requested = true
+		}
+	}
+	if !requested {
+		return nil, nil
+	}
+
+	for _, wb := range workloadBuckets {
+		if err := wb.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return workloadBuckets, nil
+}
+
+// validate checks the annotation values of the workload bucket
+func (wb workloadBucket) validate() error {
+	if wb.ClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(wb.ClassName); len(errs) > 0 {
+			return fmt.Errorf("invalid %s %q%s: %s", bucketClassKey, wb.ClassName, wb.keyDescription(), strings.Join(errs, ", "))
+		}
+	}
+
+	switch wb.Cloud {
+	case abv1.BucketCloudGCP:
+	case "":
+		// only some bucket keys can lack a cloud, the workload requests no buckets if none has one
+		if wb.ClassName == "" {
+			return fmt.Errorf("no cloud for bucket key %q, set %s or %s, or a bucket class", wb.Key, bucketCloudKey, bucketAnnotationKey(wb.Key, bucketCloudKey))
+		}
+	default:
+		return fmt.Errorf("invalid %s %q%s, valid options: %s", bucketCloudKey, wb.Cloud, wb.keyDescription(), abv1.BucketCloudGCP)
+	}
+
+	switch wb.OnDeletePolicy {
+	case "", abv1.BucketOnDeletePolicyIgnore, abv1.BucketOnDeletePolicyDestroy, abv1.BucketOnDeletePolicyRetainFor, abv1.BucketOnDeletePolicyArchive:
+	default:
+		return fmt.Errorf("invalid %s %q%s, valid options: %s, %s, %s, %s", bucketOnDeletePolicyKey, wb.OnDeletePolicy, wb.keyDescription(),
+			abv1.BucketOnDeletePolicyIgnore, abv1.BucketOnDeletePolicyDestroy, abv1.BucketOnDeletePolicyRetainFor, abv1.BucketOnDeletePolicyArchive)
+	}
+
+	switch wb.DeletionProtection {
+	case "", "true", "false":
+	default:
+		return fmt.Errorf("invalid %s %q%s, valid options: true, false", bucketDeletionProtectionKey, wb.DeletionProtection, wb.keyDescription())
+	}
+
+	if wb.NameTemplate != "" {
+		if _, err := lib.ParseBucketNameTemplate(wb.NameTemplate); err != nil {
+			return fmt.Errorf("invalid %s%s: %v", bucketNameTemplateKey, wb.keyDescription(), err)
+		}
+	}
+
+	return nil
+}
+
+// keyDescription describes the bucket key in error messages
+func (wb workloadBucket) keyDescription() string {
+	if wb.Key == "" {
+		return ""
+	}
+	return fmt.Sprintf(" for bucket key %q", wb.Key)
+}
+
+// newWorkloadBucket builds a workload bucket from the workload annotations, per-bucket overrides take precedence
+// and the namespace defaults apply last
+func newWorkloadBucket(annotations map[string]string, defaults map[string]string, key string) workloadBucket {
+	annotation := func(name string) string {
+		if key != "" {
+			if v := annotations[bucketAnnotationKey(key, name)]; v != "" {
+				return v
+			}
+		}
+		if v := annotations[name]; v != "" {
+			return v
+		}
+		return defaults[name]
+	}
+
+	wb := workloadBucket{
+		Key:                key,
+		Cloud:              abv1.BucketCloud(annotation(bucketCloudKey)),
+		NamePrefix:         annotation(bucketNamePrefixKey),
+		NameTemplate:       annotation(bucketNameTemplateKey),
+		OnDeletePolicy:     abv1.BucketOnDeletePolicy(annotation(bucketOnDeletePolicyKey)),
+		ClassName:          annotation(bucketClassKey),
+		DeletionProtection: annotation(bucketDeletionProtectionKey),
+	}
+	if wb.NamePrefix == "" {
+		wb.NamePrefix = "ab"
+	}
+	if wb.OnDeletePolicy == "" && wb.ClassName == "" {
+		wb.OnDeletePolicy = abv1.BucketOnDeletePolicyIgnore
+	}
+
+	return wb
+}
+
+// bucketEnvName returns the name of the env variable holding the full name of a workload bucket, e.g. BUCKET_UPLOADS_NAME
+func bucketEnvName(key string) string {
+	return "BUCKET_" + strings.ToUpper(strings.Replace(key, "-", "_", -1)) + "_NAME"
+}
+
+// injectBucketEnv sets the env variables on all the pod template containers, returns true if the template changed
+func injectBucketEnv(tmpl *corev1.PodTemplateSpec, env []corev1.EnvVar) bool {
+	changed := false
+	for i := range tmpl.Spec.Containers {
+		container := &tmpl.Spec.Containers[i]
+	envLoop:
+		for _, envVar := range env {
+			for j := range container.Env {
+				if container.Env[j].Name == envVar.Name {
+					if container.Env[j].Value != envVar.Value || container.Env[j].ValueFrom != nil {
+						container.Env[j] = envVar
+						changed = true
+					}
+					continue envLoop
+				}
+			}
+			container.Env = append(container.Env, envVar)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// bucketForWorkload returns a Bucket object
+func (r *WorkloadReconciler) bucketForWorkload(ctx context.Context, obj Workload, wb workloadBucket) (*abv1.Bucket, error) {
+	labels := labelsForBucket(r.Kind, obj.GetName())
+	if wb.Key != "" {
+		labels[bucketKeyLabel] = wb.Key
+	}
+
+	var class *abv1.BucketClass
+	if wb.ClassName != "" {
+		class = &abv1.BucketClass{}
+		if err := r.Get(ctx, types.NamespacedName{Name: wb.ClassName}, class); err != nil {
+			return nil, fmt.Errorf("get bucket class %q: %v", wb.ClassName, err)
+		}
+		if wb.Cloud == "" {
+			// the full name is sanitized for the cloud
+			wb.Cloud = class.Spec.Cloud
+		}
+	}
+	if wb.Cloud == "" {
+		return nil, fmt.Errorf("no cloud set by the annotations nor by the bucket class %q", wb.ClassName)
+	}
+
+	fullName, err := wb.fullName(r.BucketNamer, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := &abv1.Bucket{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      wb.bucketName(obj),
+			Namespace: obj.GetNamespace(),
+			Labels:    labels,
+		},
+		Spec: abv1.BucketSpec{
+			Cloud:              wb.Cloud,
+			BucketClassName:    wb.ClassName,
+			FullName:           fullName,
+			OnDeletePolicy:     wb.OnDeletePolicy,
+			DeletionProtection: wb.deletionProtected(),
+		},
+	}
+	// fill the remaining fields from the bucket class, then the operator defaults
+	if class != nil {
+		bucket.ApplyClass(class)
+	}
+	bucket.ApplyDefaults(r.BucketDefaults)
+
+	// Set the workload as the owner and controller
+	err = ctrl.SetControllerReference(obj, bucket, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+// labelsForBucket returns the labels for a bucket
+func labelsForBucket(kind WorkloadKind, workloadName string) map[string]string {
+	return map[string]string{"app": "ab", workloadCRKey(kind): workloadName}
+}
+
+const bucketKeyLabel = "bucket_key"
+
+// workloadCRKey returns the bucket label key referencing the owner workload, e.g. "deployment_cr"
+func workloadCRKey(kind WorkloadKind) string {
+	return strings.ToLower(kind.Name) + "_cr"
+}
+
+func (r *WorkloadReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(r.Kind.NewObject()).
+		Owns(&abv1.Bucket{}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.namespaceWorkloads),
+		}, builder.WithPredicates(predicate.Funcs{
+			// only the changes of the namespace defaults matter, the workloads are reconciled on their own creation
+			CreateFunc:  func(event.CreateEvent) bool { return false },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return !reflect.DeepEqual(namespaceDefaults(e.MetaOld), namespaceDefaults(e.MetaNew))
+			},
+		})).
+		Complete(r)
+}
+
+// namespaceWorkloads returns the requests of the annotated workloads of the namespace
+func (r *WorkloadReconciler) namespaceWorkloads(obj handler.MapObject) []reconcile.Request {
+	list := r.Kind.NewList()
+	if err := r.List(context.Background(), list, client.InNamespace(obj.Meta.GetName())); err != nil {
+		r.Log.Error(err, "Failed to list "+strings.ToLower(r.Kind.Name)+"s", "namespace", obj.Meta.GetName())
+		return nil
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		r.Log.Error(err, "Failed to extract "+strings.ToLower(r.Kind.Name)+" list")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, item := range items {
+		workload, ok := item.(Workload)
+		if !ok || !hasBucketAnnotations(workload) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: workload.GetNamespace(), Name: workload.GetName()},
+		})
+	}
+	return requests
+}
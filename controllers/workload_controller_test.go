@@ -0,0 +1,399 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	abv1 "github.com/didil/autobucket-operator/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Workload controller", func() {
+	const (
+		NamespaceName = "default"
+
+		timeout  = time.Second * 5
+		interval = time.Millisecond * 250
+	)
+
+	annotations := func() map[string]string {
+		return map[string]string{
+			"ab.leclouddev.com/cloud":            "gcp",
+			"ab.leclouddev.com/name-prefix":      "abtest",
+			"ab.leclouddev.com/on-delete-policy": "ignore",
+		}
+	}
+
+	podTemplate := func(restartPolicy corev1.RestartPolicy) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"app": "test",
+				},
+			},
+			Spec: corev1.PodSpec{
+				RestartPolicy: restartPolicy,
+				Containers: []corev1.Container{
+					corev1.Container{
+						Name:  "test",
+						Image: "busybox",
+					},
+				},
+			},
+		}
+	}
+
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app": "test",
+		},
+	}
+
+	// expectOwnedBucket waits for the bucket crd owned by the workload to be created
+	expectOwnedBucket := func(ctx context.Context, obj Workload, kind string, fullName string) {
+		Eventually(func() error {
+			bucket := &abv1.Bucket{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, bucket)
+			if err != nil {
+				return err
+			}
+
+			if fn := bucket.Spec.FullName; fn != fullName {
+				return fmt.Errorf("wrong full name %v", fn)
+			}
+
+			owner := metav1.GetControllerOf(bucket)
+			if owner == nil {
+				return fmt.Errorf("no controller owner reference")
+			}
+			if owner.Kind != kind || owner.Name != obj.GetName() || owner.UID != obj.GetUID() {
+				return fmt.Errorf("wrong owner reference %v/%v", owner.Kind, owner.Name)
+			}
+
+			return nil
+		}, timeout, interval).Should(BeNil())
+	}
+
+	Context("When creating a statefulset", func() {
+		var statefulSet *appsv1.StatefulSet
+
+		It("Should create the bucket crd", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abtest-default-test-statefulset", mock.Anything, mock.Anything).Return(nil)
+
+			statefulSet = &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-statefulset",
+					Namespace:   NamespaceName,
+					Annotations: annotations(),
+				},
+				Spec: appsv1.StatefulSetSpec{
+					Selector:    selector,
+					ServiceName: "test",
+					Template:    podTemplate(corev1.RestartPolicyAlways),
+				},
+			}
+			Expect(k8sClient.Create(ctx, statefulSet)).Should(Succeed())
+
+			expectOwnedBucket(ctx, statefulSet, "StatefulSet", "abtest-default-test-statefulset")
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, statefulSet)).Should(Succeed())
+		})
+	})
+
+	Context("When creating a statefulset referencing a bucket class", func() {
+		var statefulSet *appsv1.StatefulSet
+		var class *abv1.BucketClass
+
+		It("Should create the bucket crd with the class settings", func() {
+			ctx := context.Background()
+
+			class = &abv1.BucketClass{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-archive",
+				},
+				Spec: abv1.BucketClassSpec{
+					Cloud:          abv1.BucketCloudGCP,
+					OnDeletePolicy: abv1.BucketOnDeletePolicyDestroy,
+					Location:       "eu",
+					StorageClass:   "ARCHIVE",
+				},
+			}
+			Expect(k8sClient.Create(ctx, class)).Should(Succeed())
+
+			gcpSvc.On("CreateBucket", mock.Anything, "ab-default-test-statefulset-class", mock.Anything, mock.Anything).Return(nil)
+
+			statefulSet = &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-statefulset-class",
+					Namespace: NamespaceName,
+					Annotations: map[string]string{
+						"ab.leclouddev.com/class": "test-archive",
+					},
+				},
+				Spec: appsv1.StatefulSetSpec{
+					Selector:    selector,
+					ServiceName: "test",
+					Template:    podTemplate(corev1.RestartPolicyAlways),
+				},
+			}
+			Expect(k8sClient.Create(ctx, statefulSet)).Should(Succeed())
+
+			expectOwnedBucket(ctx, statefulSet, "StatefulSet", "ab-default-test-statefulset-class")
+
+			bucket := &abv1.Bucket{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: NamespaceName}, bucket)).Should(Succeed())
+			Expect(bucket.Spec.BucketClassName).To(Equal("test-archive"))
+			Expect(bucket.Spec.OnDeletePolicy).To(Equal(abv1.BucketOnDeletePolicyDestroy))
+			Expect(bucket.Spec.Location).To(Equal("eu"))
+			Expect(bucket.Spec.StorageClass).To(Equal("ARCHIVE"))
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, statefulSet)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, class)).Should(Succeed())
+		})
+	})
+
+	Context("When creating a statefulset in a namespace with defaults", func() {
+		var namespace *corev1.Namespace
+		var statefulSet *appsv1.StatefulSet
+
+		It("Should create the bucket crd with the namespace defaults, and propagate their changes", func() {
+			ctx := context.Background()
+
+			namespace = &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "defaults-test",
+					Annotations: map[string]string{
+						"ab.leclouddev.com/cloud":            "gcp",
+						"ab.leclouddev.com/name-prefix":      "team",
+						"ab.leclouddev.com/on-delete-policy": "ignore",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, namespace)).Should(Succeed())
+
+			gcpSvc.On("CreateBucket", mock.Anything, "team-defaults-test-test-statefulset-ns", mock.Anything, mock.Anything).Return(nil)
+
+			statefulSet = &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-statefulset-ns",
+					Namespace: namespace.Name,
+					Annotations: map[string]string{
+						// the workload annotations take precedence over the namespace defaults
+						"ab.leclouddev.com/on-delete-policy": "destroy",
+					},
+				},
+				Spec: appsv1.StatefulSetSpec{
+					Selector:    selector,
+					ServiceName: "test",
+					Template:    podTemplate(corev1.RestartPolicyAlways),
+				},
+			}
+			Expect(k8sClient.Create(ctx, statefulSet)).Should(Succeed())
+
+			expectOwnedBucket(ctx, statefulSet, "StatefulSet", "team-defaults-test-test-statefulset-ns")
+
+			bucket := &abv1.Bucket{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: namespace.Name}, bucket)).Should(Succeed())
+			Expect(bucket.Spec.Cloud).To(Equal(abv1.BucketCloudGCP))
+			Expect(bucket.Spec.OnDeletePolicy).To(Equal(abv1.BucketOnDeletePolicyDestroy))
+
+			// the namespace default applies once the workload annotation is removed
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: namespace.Name}, statefulSet)).Should(Succeed())
+			statefulSet.Annotations = map[string]string{"ab.leclouddev.com/name-prefix": "team"}
+			Expect(k8sClient.Update(ctx, statefulSet)).Should(Succeed())
+
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: namespace.Name}, namespace)).Should(Succeed())
+			namespace.Annotations["ab.leclouddev.com/on-delete-policy"] = "destroy"
+			Expect(k8sClient.Update(ctx, namespace)).Should(Succeed())
+
+			Eventually(func() abv1.BucketOnDeletePolicy {
+				bucket := &abv1.Bucket{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: namespace.Name}, bucket); err != nil {
+					return ""
+				}
+				return bucket.Spec.OnDeletePolicy
+			}, timeout, interval).Should(Equal(abv1.BucketOnDeletePolicyDestroy))
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, statefulSet)).Should(Succeed())
+		})
+	})
+
+	Context("When creating a daemonset", func() {
+		var daemonSet *appsv1.DaemonSet
+
+		It("Should create the bucket crd", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abtest-default-test-daemonset", mock.Anything, mock.Anything).Return(nil)
+
+			daemonSet = &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-daemonset",
+					Namespace:   NamespaceName,
+					Annotations: annotations(),
+				},
+				Spec: appsv1.DaemonSetSpec{
+					Selector: selector,
+					Template: podTemplate(corev1.RestartPolicyAlways),
+				},
+			}
+			Expect(k8sClient.Create(ctx, daemonSet)).Should(Succeed())
+
+			expectOwnedBucket(ctx, daemonSet, "DaemonSet", "abtest-default-test-daemonset")
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, daemonSet)).Should(Succeed())
+		})
+	})
+
+	Context("When creating a job", func() {
+		var job *batchv1.Job
+
+		It("Should create the bucket crd", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abtest-default-test-job", mock.Anything, mock.Anything).Return(nil)
+
+			job = &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-job",
+					Namespace:   NamespaceName,
+					Annotations: annotations(),
+				},
+				Spec: batchv1.JobSpec{
+					Template: podTemplate(corev1.RestartPolicyNever),
+				},
+			}
+			Expect(k8sClient.Create(ctx, job)).Should(Succeed())
+
+			expectOwnedBucket(ctx, job, "Job", "abtest-default-test-job")
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, job)).Should(Succeed())
+		})
+	})
+
+	Context("When creating a cronjob", func() {
+		var cronJob *batchv1beta1.CronJob
+
+		It("Should create the bucket crd", func() {
+			ctx := context.Background()
+
+			gcpSvc.On("CreateBucket", mock.Anything, "abtest-default-test-cronjob", mock.Anything, mock.Anything).Return(nil)
+
+			cronJob = &batchv1beta1.CronJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-cronjob",
+					Namespace:   NamespaceName,
+					Annotations: annotations(),
+				},
+				Spec: batchv1beta1.CronJobSpec{
+					Schedule: "0 * * * *",
+					JobTemplate: batchv1beta1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: podTemplate(corev1.RestartPolicyNever),
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, cronJob)).Should(Succeed())
+
+			expectOwnedBucket(ctx, cronJob, "CronJob", "abtest-default-test-cronjob")
+		})
+
+		AfterEach(func() {
+			ctx := context.Background()
+			Expect(k8sClient.Delete(ctx, cronJob)).Should(Succeed())
+		})
+	})
+
+	Context("When only the cloud annotation is removed", func() {
+		var deployment *appsv1.Deployment
+		var bucket *abv1.Bucket
+
+		BeforeEach(func() {
+			deployment = &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cloud-removed",
+					Namespace: NamespaceName,
+					UID:       "cloud-removed-uid",
+					Annotations: map[string]string{
+						"ab.leclouddev.com/name-prefix":      "abtest",
+						"ab.leclouddev.com/on-delete-policy": "destroy",
+					},
+				},
+			}
+			bucket = &abv1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      deployment.Name,
+					Namespace: NamespaceName,
+					Labels:    map[string]string{workloadCRKey(DeploymentKind): deployment.Name},
+				},
+				Spec: abv1.BucketSpec{Cloud: abv1.BucketCloudGCP, FullName: "abtest-default-cloud-removed"},
+			}
+			Expect(ctrl.SetControllerReference(deployment, bucket, scheme.Scheme)).To(Succeed())
+		})
+
+		reconcile := func(policy AnnotationRemovalPolicy) *abv1.Bucket {
+			ctx := context.Background()
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, deployment, bucket)
+			r := &WorkloadReconciler{Client: c, Log: ctrl.Log.WithName("deployment"), Scheme: scheme.Scheme, Kind: DeploymentKind,
+				AnnotationRemovalPolicy: policy}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: deployment.Name, Namespace: NamespaceName}})
+			Expect(err).ToNot(HaveOccurred())
+			updated := &abv1.Bucket{}
+			err = c.Get(ctx, types.NamespacedName{Name: bucket.Name, Namespace: NamespaceName}, updated)
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			Expect(err).ToNot(HaveOccurred())
+			return updated
+		}
+
+		It("Should keep the bucket with the keep policy", func() {
+			updated := reconcile(AnnotationRemovalPolicyKeep)
+			Expect(updated.Status.IsConditionTrue(abv1.BucketConditionAnnotationsRemoved)).To(BeTrue())
+			Expect(metav1.IsControlledBy(updated, deployment)).To(BeTrue())
+		})
+
+		It("Should detach the bucket with the detach policy", func() {
+			updated := reconcile(AnnotationRemovalPolicyDetach)
+			Expect(metav1.IsControlledBy(updated, deployment)).To(BeFalse())
+			Expect(updated.Labels).NotTo(HaveKey(workloadCRKey(DeploymentKind)))
+		})
+
+		It("Should delete the bucket with the delete policy", func() {
+			updated := reconcile(AnnotationRemovalPolicyDelete)
+			Expect(updated).To(BeNil())
+		})
+	})
+})
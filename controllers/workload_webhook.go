@@ -0,0 +1,88 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// The workload webhooks fail open: an unavailable operator must not block all workload changes in the cluster
+
+// +kubebuilder:webhook:path=/validate-apps-v1-deployment,mutating=false,failurePolicy=ignore,groups=apps,resources=deployments,verbs=create;update,versions=v1,name=vdeployment.kb.io
+// +kubebuilder:webhook:path=/validate-apps-v1-statefulset,mutating=false,failurePolicy=ignore,groups=apps,resources=statefulsets,verbs=create;update,versions=v1,name=vstatefulset.kb.io
+// +kubebuilder:webhook:path=/validate-apps-v1-daemonset,mutating=false,failurePolicy=ignore,groups=apps,resources=daemonsets,verbs=create;update,versions=v1,name=vdaemonset.kb.io
+// +kubebuilder:webhook:path=/validate-batch-v1-job,mutating=false,failurePolicy=ignore,groups=batch,resources=jobs,verbs=create;update,versions=v1,name=vjob.kb.io
+// +kubebuilder:webhook:path=/validate-batch-v1beta1-cronjob,mutating=false,failurePolicy=ignore,groups=batch,resources=cronjobs,verbs=create;update,versions=v1beta1,name=vcronjob.kb.io
+
+// WorkloadValidator rejects workloads of a given kind with malformed autobucket annotations
+type WorkloadValidator struct {
+	// Client reads the namespace defaults, only the workload annotations are validated if nil
+	Client  client.Reader
+	Log     logr.Logger
+	Kind    WorkloadKind
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &WorkloadValidator{}
+var _ admission.DecoderInjector = &WorkloadValidator{}
+
+// SetupWebhookWithManager registers the validating webhook of the workload kind
+func (v *WorkloadValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(v.Kind.WebhookPath, &webhook.Admission{Handler: v})
+	return nil
+}
+
+// Handle validates the autobucket annotations of the workload
+func (v *WorkloadValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	obj := v.Kind.NewObject()
+	if err := v.decoder.Decode(req, obj); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if !hasBucketAnnotations(obj) {
+		return admission.Allowed("")
+	}
+
+	var defaults map[string]string
+	if v.Client != nil {
+		var err error
+		defaults, err = namespaceDefaultsFor(ctx, v.Client, req.Namespace)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+	}
+
+	if _, err := workloadBucketsFor(obj, defaults); err != nil {
+		v.Log.Info("Rejecting invalid autobucket annotations", "namespace", req.Namespace, "name", req.Name, "error", err.Error())
+		return admission.Denied(err.Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *WorkloadValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
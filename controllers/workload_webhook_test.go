@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Workload webhook", func() {
+	var validator *WorkloadValidator
+
+	BeforeEach(func() {
+		decoder, err := admission.NewDecoder(scheme.Scheme)
+		Expect(err).NotTo(HaveOccurred())
+
+		validator = &WorkloadValidator{Log: ctrl.Log.WithName("webhooks").WithName("Deployment"), Kind: DeploymentKind}
+		Expect(validator.InjectDecoder(decoder)).To(Succeed())
+	})
+
+	handle := func(annotations map[string]string) admission.Response {
+		dep := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-dep-webhook",
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+		}
+		raw, err := json.Marshal(dep)
+		Expect(err).NotTo(HaveOccurred())
+
+		return validator.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Operation: admissionv1beta1.Create,
+				Name:      dep.Name,
+				Namespace: dep.Namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+	}
+
+	It("Should allow workloads without autobucket annotations", func() {
+		Expect(handle(nil).Allowed).To(BeTrue())
+	})
+
+	It("Should allow valid autobucket annotations", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/cloud":                       "gcp",
+			"ab.leclouddev.com/buckets":                     "uploads,thumbnails",
+			"ab.leclouddev.com/thumbnails.on-delete-policy": "destroy",
+		})
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("Should allow a bucket class without cloud", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/class": "archive",
+		})
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("Should reject an unknown cloud", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/cloud": "aws",
+		})
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Reason).To(BeEquivalentTo(`invalid ab.leclouddev.com/cloud "aws", valid options: gcp`))
+	})
+
+	It("Should reject an unknown per-bucket on delete policy", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/cloud":                    "gcp",
+			"ab.leclouddev.com/buckets":                  "uploads",
+			"ab.leclouddev.com/uploads.on-delete-policy": "delete",
+		})
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(string(resp.Result.Reason)).To(ContainSubstring(`invalid ab.leclouddev.com/on-delete-policy "delete" for bucket key "uploads"`))
+	})
+
+	It("Should reject an invalid deletion protection", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/cloud":               "gcp",
+			"ab.leclouddev.com/deletion-protection": "yes",
+		})
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Reason).To(BeEquivalentTo(`invalid ab.leclouddev.com/deletion-protection "yes", valid options: true, false`))
+	})
+
+	It("Should allow a cloud set by the namespace defaults", func() {
+		annotations := map[string]string{
+			"ab.leclouddev.com/buckets":          "uploads,thumbnails",
+			"ab.leclouddev.com/thumbnails.class": "archive",
+		}
+		Expect(handle(annotations).Allowed).To(BeFalse())
+
+		validator.Client = fake.NewFakeClientWithScheme(scheme.Scheme, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "default",
+				Annotations: map[string]string{"ab.leclouddev.com/cloud": "gcp"},
+			},
+		})
+		Expect(handle(annotations).Allowed).To(BeTrue())
+	})
+
+	It("Should allow removing the cloud annotation only", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/name-prefix":      "abtest",
+			"ab.leclouddev.com/on-delete-policy": "destroy",
+		})
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("Should reject an invalid bucket key", func() {
+		resp := handle(map[string]string{
+			"ab.leclouddev.com/cloud":   "gcp",
+			"ab.leclouddev.com/buckets": "Uploads",
+		})
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(string(resp.Result.Reason)).To(ContainSubstring(`invalid bucket key "Uploads"`))
+	})
+})
@@ -0,0 +1,254 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cosi
+
+import (
+	"context"
+	"strings"
+
+	"github.com/didil/autobucket-operator/lib"
+	"github.com/didil/autobucket-operator/services"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultNamePrefix is the name prefix of the buckets created by the driver
+const DefaultNamePrefix = "ab"
+
+// Driver is a COSI driver provisioning the cloud storage buckets with the operator provider services
+type Driver struct {
+	// Name is the driver name reported to the COSI controller, referenced by the BucketClasses
+	Name string
+	// ClusterName is the name of the cluster, stamped on the created storage buckets
+	ClusterName string
+	// ProjectID is the GCP project of the storage buckets
+	ProjectID string
+	// GCPSvc is the GCP provider service
+	GCPSvc services.GCPSvc
+	// Log is the driver logger
+	Log logr.Logger
+}
+
+// CreateBucketRequest is a COSI DriverCreateBucketRequest
+type CreateBucketRequest struct {
+	// Name is the name of the COSI Bucket object
+	Name string
+	// Parameters are the COSI BucketClass parameters: location, storageClass and namePrefix
+	Parameters map[string]string
+}
+
+// CreateBucketResponse is a COSI DriverCreateBucketResponse
+type CreateBucketResponse struct {
+	// BucketID is the storage bucket full name
+	BucketID string
+	// ProjectID is the GCP project of the storage bucket
+	ProjectID string
+}
+
+// DeleteBucketRequest is a COSI DriverDeleteBucketRequest
+type DeleteBucketRequest struct {
+	// BucketID is the storage bucket full name
+	BucketID string
+}
+
+// GetInfo returns the driver name
+func (d *Driver) GetInfo(ctx context.Context) (string, error) {
+	if d.Name == "" {
+		return "", status.Error(codes.Unavailable, "driver name not configured")
+	}
+	return d.Name, nil
+}
+
+// CreateBucket creates the storage bucket of a COSI Bucket object,
+// the call is idempotent for the same Bucket name
+func (d *Driver) CreateBucket(ctx context.Context, req *CreateBucketRequest) (*CreateBucketResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "bucket name is required")
+	}
+
+	prefix := req.Parameters["namePrefix"]
+	if prefix == "" {
+		prefix = DefaultNamePrefix
+	}
+	fullName := lib.SanitizeBucketName("gcp", prefix+"-"+req.Name)
+
+	attrs := services.BucketAttrs{
+		Location:     req.Parameters["location"],
+		StorageClass: req.Parameters["storageClass"],
+	}
+
+	err := d.GCPSvc.CreateBucket(ctx, fullName, attrs, d.owner(fullName))
+	if err == services.ErrBucketConflict {
+		return nil, status.Errorf(codes.AlreadyExists, "bucket %s: %v", fullName, err)
+	}
+	if err != nil {
+		d.Log.Error(err, "Failed to create bucket", "name", req.Name, "fullName", fullName)
+		return nil, status.Errorf(codes.Internal, "create bucket %s: %v", fullName, err)
+	}
+
+	d.Log.Info("Created bucket", "name", req.Name, "fullName", fullName)
+
+	return &CreateBucketResponse{BucketID: fullName, ProjectID: d.ProjectID}, nil
+}
+
+// DeleteBucket deletes the storage bucket of a COSI Bucket object,
+// the call is idempotent and only deletes storage buckets created by the driver
+func (d *Driver) DeleteBucket(ctx context.Context, req *DeleteBucketRequest) error {
+	if req.BucketID == "" {
+		return status.Error(codes.InvalidArgument, "bucket id is required")
+	}
+
+	err := d.GCPSvc.DeleteGCPBucket(ctx, req.BucketID, d.owner(req.BucketID))
+	if err == services.ErrBucketConflict {
+		return status.Errorf(codes.FailedPrecondition, "bucket %s: %v", req.BucketID, err)
+	}
+	if err != nil {
+		d.Log.Error(err, "Failed to delete bucket", "fullName", req.BucketID)
+		return status.Errorf(codes.Internal, "delete bucket %s: %v", req.BucketID, err)
+	}
+
+	d.Log.Info("Deleted bucket", "fullName", req.BucketID)
+
+	return nil
+}
+
+// AuthenticationType is the COSI spec AuthenticationType of the bucket access
+type AuthenticationType int32
+
+const (
+	// AuthenticationTypeUnknown authentication type not set
+	AuthenticationTypeUnknown AuthenticationType = 0
+	// AuthenticationTypeKey the workloads access the bucket with keys created by the driver
+	AuthenticationTypeKey AuthenticationType = 1
+	// AuthenticationTypeIAM the workloads access the bucket with their own cloud identity
+	AuthenticationTypeIAM AuthenticationType = 2
+)
+
+// GrantBucketAccessRequest is a COSI DriverGrantBucketAccessRequest
+type GrantBucketAccessRequest struct {
+	// BucketID is the storage bucket full name
+	BucketID string
+	// Name is the name of the COSI BucketAccess object
+	Name string
+	// AuthenticationType is the authentication type of the BucketAccessClass, only IAM is supported
+	AuthenticationType AuthenticationType
+	// Parameters are the COSI BucketAccessClass parameters: serviceAccount and access
+	Parameters map[string]string
+}
+
+// GrantBucketAccessResponse is a COSI DriverGrantBucketAccessResponse
+type GrantBucketAccessResponse struct {
+	// AccountID identifies the granted access, passed back to RevokeBucketAccess
+	AccountID string
+	// Credentials are the gcs credential details of the workloads
+	Credentials map[string]string
+}
+
+// RevokeBucketAccessRequest is a COSI DriverRevokeBucketAccessRequest
+type RevokeBucketAccessRequest struct {
+	// BucketID is the storage bucket full name
+	BucketID string
+	// AccountID is the account id returned by GrantBucketAccess
+	AccountID string
+}
+
+// accessRoles are the IAM roles granting the access levels of the bucket access parameters
+var accessRoles = map[string]string{
+	"read-only":  "roles/storage.objectViewer",
+	"read-write": "roles/storage.objectAdmin",
+}
+
+// defaultAccess is the access level granted when the bucket access parameters don't set it
+const defaultAccess = "read-only"
+
+// GrantBucketAccess grants the service account of the bucket access parameters an IAM role on the storage bucket,
+// the account id is "{serviceAccount}/{access}" as the revoke requests don't carry the parameters
+func (d *Driver) GrantBucketAccess(ctx context.Context, req *GrantBucketAccessRequest) (*GrantBucketAccessResponse, error) {
+	if req.BucketID == "" {
+		return nil, status.Error(codes.InvalidArgument, "bucket id is required")
+	}
+	if req.AuthenticationType != AuthenticationTypeIAM {
+		return nil, status.Error(codes.Unimplemented, "only the IAM authentication type is supported")
+	}
+
+	serviceAccount := req.Parameters["serviceAccount"]
+	if serviceAccount == "" {
+		return nil, status.Error(codes.InvalidArgument, "serviceAccount parameter is required")
+	}
+	access := req.Parameters["access"]
+	if access == "" {
+		access = defaultAccess
+	}
+	role, ok := accessRoles[access]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown access %q, one of read-only, read-write", access)
+	}
+
+	err := d.GCPSvc.GrantBucketAccess(ctx, req.BucketID, "serviceAccount:"+serviceAccount, role)
+	if err == services.ErrBucketNotFound {
+		return nil, status.Errorf(codes.NotFound, "bucket %s not found", req.BucketID)
+	}
+	if err != nil {
+		d.Log.Error(err, "Failed to grant bucket access", "fullName", req.BucketID, "serviceAccount", serviceAccount)
+		return nil, status.Errorf(codes.Internal, "grant bucket %s access: %v", req.BucketID, err)
+	}
+
+	d.Log.Info("Granted bucket access", "name", req.Name, "fullName", req.BucketID, "serviceAccount", serviceAccount, "access", access)
+
+	return &GrantBucketAccessResponse{
+		AccountID: serviceAccount + "/" + access,
+		Credentials: map[string]string{
+			"bucketName":     req.BucketID,
+			"projectId":      d.ProjectID,
+			"serviceAccount": serviceAccount,
+		},
+	}, nil
+}
+
+// RevokeBucketAccess revokes the IAM role granted by GrantBucketAccess, the call is idempotent
+func (d *Driver) RevokeBucketAccess(ctx context.Context, req *RevokeBucketAccessRequest) error {
+	if req.BucketID == "" {
+		return status.Error(codes.InvalidArgument, "bucket id is required")
+	}
+
+	i := strings.LastIndex(req.AccountID, "/")
+	if i <= 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid account id %q", req.AccountID)
+	}
+	serviceAccount, access := req.AccountID[:i], req.AccountID[i+1:]
+	role, ok := accessRoles[access]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "invalid account id %q", req.AccountID)
+	}
+
+	err := d.GCPSvc.RevokeBucketAccess(ctx, req.BucketID, "serviceAccount:"+serviceAccount, role)
+	if err != nil {
+		d.Log.Error(err, "Failed to revoke bucket access", "fullName", req.BucketID, "serviceAccount", serviceAccount)
+		return status.Errorf(codes.Internal, "revoke bucket %s access: %v", req.BucketID, err)
+	}
+
+	d.Log.Info("Revoked bucket access", "fullName", req.BucketID, "serviceAccount", serviceAccount, "access", access)
+
+	return nil
+}
+
+// owner returns the ownership of the storage buckets created by the driver,
+// the full name identifies the COSI Bucket as the driver has no access to the object uid
+func (d *Driver) owner(fullName string) services.BucketOwner {
+	return services.BucketOwner{ClusterID: d.ClusterName, UID: fullName}
+}
@@ -0,0 +1,211 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cosi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Server serves the COSI Identity and Provisioner services of the driver on a unix socket
+type Server struct {
+	// Endpoint is the unix socket of the server, unix:///path/to/cosi.sock or /path/to/cosi.sock
+	Endpoint string
+	// Driver is the served driver
+	Driver *Driver
+}
+
+// Start serves the driver until the stop channel is closed, implements manager.Runnable
+func (s *Server) Start(stop <-chan struct{}) error {
+	path := strings.TrimPrefix(s.Endpoint, "unix://")
+
+	// remove the socket left by a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove cosi socket: %v", err)
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen cosi socket: %v", err)
+	}
+
+	srv := grpc.NewServer()
+	Register(srv, s.Driver)
+
+	go func() {
+		<-stop
+		srv.GracefulStop()
+	}()
+
+	s.Driver.Log.Info("Serving COSI driver", "name", s.Driver.Name, "endpoint", s.Endpoint)
+
+	return srv.Serve(lis)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable,
+// the driver is served by all the replicas for the COSI sidecar of each pod
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Register registers the COSI Identity and Provisioner services of the driver on the grpc server
+func Register(srv *grpc.Server, driver *Driver) {
+	srv.RegisterService(&identityServiceDesc, driver)
+	srv.RegisterService(&provisionerServiceDesc, driver)
+}
+
+// driverService is the driver interface served by the services
+type driverService interface {
+	GetInfo(ctx context.Context) (string, error)
+	CreateBucket(ctx context.Context, req *CreateBucketRequest) (*CreateBucketResponse, error)
+	DeleteBucket(ctx context.Context, req *DeleteBucketRequest) error
+	GrantBucketAccess(ctx context.Context, req *GrantBucketAccessRequest) (*GrantBucketAccessResponse, error)
+	RevokeBucketAccess(ctx context.Context, req *RevokeBucketAccessRequest) error
+}
+
+var _ driverService = &Driver{}
+
+var identityServiceDesc = grpc.ServiceDesc{
+	ServiceName: identityService,
+	HandlerType: (*driverService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(identityService, "DriverGetInfo", func(ctx context.Context, d driverService, req *dynamicpb.Message, res *dynamicpb.Message) error {
+			name, err := d.GetInfo(ctx)
+			if err != nil {
+				return err
+			}
+			setString(res, "name", name)
+			return nil
+		}),
+	},
+	Metadata: "cosi.proto",
+}
+
+var provisionerServiceDesc = grpc.ServiceDesc{
+	ServiceName: provisionerService,
+	HandlerType: (*driverService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(provisionerService, "DriverCreateBucket", func(ctx context.Context, d driverService, req *dynamicpb.Message, res *dynamicpb.Message) error {
+			created, err := d.CreateBucket(ctx, &CreateBucketRequest{
+				Name:       getString(req, "name"),
+				Parameters: getStringMap(req, "parameters"),
+			})
+			if err != nil {
+				return err
+			}
+			setString(res, "bucket_id", created.BucketID)
+
+			// the storage bucket protocol: bucket_info.gcs.project_id
+			info := res.Mutable(fieldByName(res, "bucket_info")).Message()
+			gcs := info.Mutable(info.Descriptor().Fields().ByName("gcs")).Message()
+			gcs.Set(gcs.Descriptor().Fields().ByName("project_id"), protoreflect.ValueOfString(created.ProjectID))
+			return nil
+		}),
+		unaryMethod(provisionerService, "DriverDeleteBucket", func(ctx context.Context, d driverService, req *dynamicpb.Message, res *dynamicpb.Message) error {
+			return d.DeleteBucket(ctx, &DeleteBucketRequest{BucketID: getString(req, "bucket_id")})
+		}),
+		unaryMethod(provisionerService, "DriverGrantBucketAccess", func(ctx context.Context, d driverService, req *dynamicpb.Message, res *dynamicpb.Message) error {
+			granted, err := d.GrantBucketAccess(ctx, &GrantBucketAccessRequest{
+				BucketID:           getString(req, "bucket_id"),
+				Name:               getString(req, "name"),
+				AuthenticationType: AuthenticationType(req.Get(fieldByName(req, "authentication_type")).Enum()),
+				Parameters:         getStringMap(req, "parameters"),
+			})
+			if err != nil {
+				return err
+			}
+			setString(res, "account_id", granted.AccountID)
+
+			// the credentials of the storage bucket protocol: credentials["gcs"].secrets
+			details := dynamicpb.NewMessage(messageDescriptor("CredentialDetails"))
+			secrets := details.Mutable(fieldByName(details, "secrets")).Map()
+			for k, v := range granted.Credentials {
+				secrets.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+			}
+			res.Mutable(fieldByName(res, "credentials")).Map().Set(protoreflect.ValueOfString("gcs").MapKey(), protoreflect.ValueOfMessage(details))
+			return nil
+		}),
+		unaryMethod(provisionerService, "DriverRevokeBucketAccess", func(ctx context.Context, d driverService, req *dynamicpb.Message, res *dynamicpb.Message) error {
+			return d.RevokeBucketAccess(ctx, &RevokeBucketAccessRequest{
+				BucketID:  getString(req, "bucket_id"),
+				AccountID: getString(req, "account_id"),
+			})
+		}),
+	},
+	Metadata: "cosi.proto",
+}
+
+// unaryMethod returns a unary method decoding the spec request message and encoding the spec response message
+func unaryMethod(serviceName, name string, call func(ctx context.Context, d driverService, req *dynamicpb.Message, res *dynamicpb.Message) error) grpc.MethodDesc {
+	requestDesc := messageDescriptor(name + "Request")
+	responseDesc := messageDescriptor(name + "Response")
+
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := dynamicpb.NewMessage(requestDesc)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				res := dynamicpb.NewMessage(responseDesc)
+				if err := call(ctx, srv.(driverService), req.(*dynamicpb.Message), res); err != nil {
+					return nil, err
+				}
+				return res, nil
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + serviceName + "/" + name,
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+func fieldByName(msg *dynamicpb.Message, name string) protoreflect.FieldDescriptor {
+	return msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+}
+
+func getString(msg *dynamicpb.Message, name string) string {
+	return msg.Get(fieldByName(msg, name)).String()
+}
+
+func setString(msg *dynamicpb.Message, name string, value string) {
+	msg.Set(fieldByName(msg, name), protoreflect.ValueOfString(value))
+}
+
+func getStringMap(msg *dynamicpb.Message, name string) map[string]string {
+	res := map[string]string{}
+	msg.Get(fieldByName(msg, name)).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		res[k.String()] = v.String()
+		return true
+	})
+	return res
+}
@@ -0,0 +1,191 @@
+package cosi
+
+import (
+	"context"
+	"net"
+
+	"github.com/didil/autobucket-operator/services"
+	"github.com/didil/autobucket-operator/testsupport/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("COSI server", func() {
+	var gcpSvc *mocks.GCPSvc
+	var srv *grpc.Server
+	var conn *grpc.ClientConn
+
+	BeforeEach(func() {
+		gcpSvc = &mocks.GCPSvc{}
+
+		lis := bufconn.Listen(1024 * 1024)
+		srv = grpc.NewServer()
+		Register(srv, &Driver{
+			Name:        "ab.leclouddev.com",
+			ClusterName: "test-cluster",
+			ProjectID:   "test-project",
+			GCPSvc:      gcpSvc,
+			Log:         ctrl.Log.WithName("cosi"),
+		})
+		go srv.Serve(lis)
+
+		var err error
+		conn, err = grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		srv.Stop()
+		gcpSvc.AssertExpectations(GinkgoT())
+	})
+
+	invoke := func(service, method string, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+		res := dynamicpb.NewMessage(messageDescriptor(method + "Response"))
+		err := conn.Invoke(context.Background(), "/"+service+"/"+method, req, res)
+		return res, err
+	}
+
+	newRequest := func(method string, fields map[string]string, parameters map[string]string) *dynamicpb.Message {
+		req := dynamicpb.NewMessage(messageDescriptor(method + "Request"))
+		for k, v := range fields {
+			setString(req, k, v)
+		}
+		if parameters != nil {
+			m := req.Mutable(fieldByName(req, "parameters")).Map()
+			for k, v := range parameters {
+				m.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+			}
+		}
+		return req
+	}
+
+	It("Should return the driver info", func() {
+		res, err := invoke(identityService, "DriverGetInfo", newRequest("DriverGetInfo", nil, nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getString(res, "name")).To(Equal("ab.leclouddev.com"))
+	})
+
+	It("Should create a bucket with the class parameters", func() {
+		fullName := "ab-cosi-bucket-1"
+		gcpSvc.On("CreateBucket", mock.Anything, fullName,
+			services.BucketAttrs{Location: "EU", StorageClass: "NEARLINE"},
+			services.BucketOwner{ClusterID: "test-cluster", UID: fullName}).Return(nil)
+
+		res, err := invoke(provisionerService, "DriverCreateBucket", newRequest("DriverCreateBucket",
+			map[string]string{"name": "cosi-bucket-1"},
+			map[string]string{"location": "EU", "storageClass": "NEARLINE"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getString(res, "bucket_id")).To(Equal(fullName))
+
+		info := res.Get(fieldByName(res, "bucket_info")).Message()
+		gcs := info.Get(info.Descriptor().Fields().ByName("gcs")).Message()
+		Expect(gcs.Get(gcs.Descriptor().Fields().ByName("project_id")).String()).To(Equal("test-project"))
+	})
+
+	It("Should return AlreadyExists on a bucket conflict", func() {
+		gcpSvc.On("CreateBucket", mock.Anything, "custom-cosi-bucket-2", mock.Anything, mock.Anything).Return(services.ErrBucketConflict)
+
+		_, err := invoke(provisionerService, "DriverCreateBucket", newRequest("DriverCreateBucket",
+			map[string]string{"name": "cosi-bucket-2"},
+			map[string]string{"namePrefix": "custom"}))
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+	})
+
+	It("Should reject a bucket without name", func() {
+		_, err := invoke(provisionerService, "DriverCreateBucket", newRequest("DriverCreateBucket", nil, nil))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	It("Should delete a bucket", func() {
+		fullName := "ab-cosi-bucket-3"
+		gcpSvc.On("DeleteGCPBucket", mock.Anything, fullName,
+			services.BucketOwner{ClusterID: "test-cluster", UID: fullName}).Return(nil)
+
+		_, err := invoke(provisionerService, "DriverDeleteBucket", newRequest("DriverDeleteBucket",
+			map[string]string{"bucket_id": fullName}, nil))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	newAccessRequest := func(authenticationType AuthenticationType, parameters map[string]string) *dynamicpb.Message {
+		req := newRequest("DriverGrantBucketAccess", map[string]string{"bucket_id": "ab-cosi-bucket-4", "name": "access-1"}, parameters)
+		req.Set(fieldByName(req, "authentication_type"), protoreflect.ValueOfEnum(protoreflect.EnumNumber(authenticationType)))
+		return req
+	}
+
+	It("Should grant bucket access to the service account of the parameters", func() {
+		gcpSvc.On("GrantBucketAccess", mock.Anything, "ab-cosi-bucket-4",
+			"serviceAccount:writer@test-project.iam.gserviceaccount.com", "roles/storage.objectAdmin").Return(nil)
+
+		res, err := invoke(provisionerService, "DriverGrantBucketAccess", newAccessRequest(AuthenticationTypeIAM,
+			map[string]string{"serviceAccount": "writer@test-project.iam.gserviceaccount.com", "access": "read-write"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getString(res, "account_id")).To(Equal("writer@test-project.iam.gserviceaccount.com/read-write"))
+
+		credentials := res.Get(fieldByName(res, "credentials")).Map()
+		gcs := credentials.Get(protoreflect.ValueOfString("gcs").MapKey()).Message().(*dynamicpb.Message)
+		Expect(getStringMap(gcs, "secrets")).To(Equal(map[string]string{
+			"bucketName":     "ab-cosi-bucket-4",
+			"projectId":      "test-project",
+			"serviceAccount": "writer@test-project.iam.gserviceaccount.com",
+		}))
+	})
+
+	It("Should grant read-only bucket access by default", func() {
+		gcpSvc.On("GrantBucketAccess", mock.Anything, "ab-cosi-bucket-4",
+			"serviceAccount:reader@test-project.iam.gserviceaccount.com", "roles/storage.objectViewer").Return(nil)
+
+		res, err := invoke(provisionerService, "DriverGrantBucketAccess", newAccessRequest(AuthenticationTypeIAM,
+			map[string]string{"serviceAccount": "reader@test-project.iam.gserviceaccount.com"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getString(res, "account_id")).To(Equal("reader@test-project.iam.gserviceaccount.com/read-only"))
+	})
+
+	It("Should return NotFound when the bucket doesn't exist", func() {
+		gcpSvc.On("GrantBucketAccess", mock.Anything, "ab-cosi-bucket-4", mock.Anything, mock.Anything).Return(services.ErrBucketNotFound)
+
+		_, err := invoke(provisionerService, "DriverGrantBucketAccess", newAccessRequest(AuthenticationTypeIAM,
+			map[string]string{"serviceAccount": "reader@test-project.iam.gserviceaccount.com"}))
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+
+	It("Should not grant bucket access with keys", func() {
+		_, err := invoke(provisionerService, "DriverGrantBucketAccess", newAccessRequest(AuthenticationTypeKey,
+			map[string]string{"serviceAccount": "reader@test-project.iam.gserviceaccount.com"}))
+		Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+	})
+
+	It("Should reject a bucket access without service account or with an unknown access", func() {
+		_, err := invoke(provisionerService, "DriverGrantBucketAccess", newAccessRequest(AuthenticationTypeIAM, nil))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = invoke(provisionerService, "DriverGrantBucketAccess", newAccessRequest(AuthenticationTypeIAM,
+			map[string]string{"serviceAccount": "reader@test-project.iam.gserviceaccount.com", "access": "owner"}))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	It("Should revoke the bucket access of the account", func() {
+		gcpSvc.On("RevokeBucketAccess", mock.Anything, "ab-cosi-bucket-4",
+			"serviceAccount:writer@test-project.iam.gserviceaccount.com", "roles/storage.objectAdmin").Return(nil)
+
+		_, err := invoke(provisionerService, "DriverRevokeBucketAccess", newRequest("DriverRevokeBucketAccess",
+			map[string]string{"bucket_id": "ab-cosi-bucket-4", "account_id": "writer@test-project.iam.gserviceaccount.com/read-write"}, nil))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Should reject the revoke of an unknown account", func() {
+		_, err := invoke(provisionerService, "DriverRevokeBucketAccess", newRequest("DriverRevokeBucketAccess",
+			map[string]string{"bucket_id": "ab-cosi-bucket-4", "account_id": "writer@test-project.iam.gserviceaccount.com"}, nil))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+})
@@ -0,0 +1,222 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cosi
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The COSI spec protobuf definitions (package cosi.v1alpha1) served by the driver, see
+// https://github.com/kubernetes-sigs/container-object-storage-interface-spec/blob/master/cosi.proto
+// The generated spec package is not a dependency of the operator: the descriptor is built from the subset
+// of the spec used by the driver, the message and field numbers must match the spec for wire compatibility.
+
+const specPackage = "cosi.v1alpha1"
+
+const (
+	identityService    = specPackage + ".Identity"
+	provisionerService = specPackage + ".Provisioner"
+)
+
+var specFile = newSpecFile()
+
+func newSpecFile() protoreflect.FileDescriptor {
+	fd, err := protodesc.NewFile(specFileDescriptor(), nil)
+	if err != nil {
+		panic(fmt.Sprintf("cosi spec descriptor: %v", err))
+	}
+	return fd
+}
+
+// messageDescriptor returns the descriptor of a spec message
+func messageDescriptor(name string) protoreflect.MessageDescriptor {
+	md := specFile.Messages().ByName(protoreflect.Name(name))
+	if md == nil {
+		panic(fmt.Sprintf("unknown cosi spec message %s", name))
+	}
+	return md
+}
+
+func specFileDescriptor() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("cosi.proto"),
+		Package: proto.String(specPackage),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("AuthenticationType"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("UnknownAuthenticationType"), Number: proto.Int32(0)},
+					{Name: proto.String("Key"), Number: proto.Int32(1)},
+					{Name: proto.String("IAM"), Number: proto.Int32(2)},
+				},
+			},
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("DriverGetInfoRequest"),
+			message("DriverGetInfoResponse",
+				stringField("name", 1)),
+			message("GCS",
+				stringField("private_key_name", 1),
+				stringField("project_id", 2),
+				stringField("service_account", 3)),
+			withOneof(message("Protocol",
+				messageField("gcs", 3, "GCS")), "type"),
+			withMapEntries(message("DriverCreateBucketRequest",
+				stringField("name", 1),
+				mapField("parameters", 2, "ParametersEntry"))),
+			message("DriverCreateBucketResponse",
+				stringField("bucket_id", 1),
+				messageField("bucket_info", 2, "Protocol")),
+			withMapEntries(message("DriverDeleteBucketRequest",
+				stringField("bucket_id", 1),
+				mapField("delete_context", 2, "DeleteContextEntry"))),
+			message("DriverDeleteBucketResponse"),
+			withMapEntries(message("CredentialDetails",
+				mapField("secrets", 1, "SecretsEntry"))),
+			withMapEntries(message("DriverGrantBucketAccessRequest",
+				stringField("bucket_id", 1),
+				stringField("name", 2),
+				enumField("authentication_type", 3, "AuthenticationType"),
+				mapField("parameters", 4, "ParametersEntry"))),
+			withMapEntries(message("DriverGrantBucketAccessResponse",
+				stringField("account_id", 1),
+				mapField("credentials", 2, "CredentialsEntry")), "CredentialDetails"),
+			withMapEntries(message("DriverRevokeBucketAccessRequest",
+				stringField("bucket_id", 1),
+				stringField("account_id", 2),
+				mapField("revoke_access_context", 3, "RevokeAccessContextEntry"))),
+			message("DriverRevokeBucketAccessResponse"),
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			service("Identity",
+				method("DriverGetInfo")),
+			service("Provisioner",
+				method("DriverCreateBucket"),
+				method("DriverDeleteBucket"),
+				method("DriverGrantBucketAccess"),
+				method("DriverRevokeBucketAccess")),
+		},
+	}
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func fieldProto(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(jsonName(name)),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+	}
+}
+
+func stringField(name string, number int32) *descriptorpb.FieldDescriptorProto {
+	return fieldProto(name, number, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+}
+
+func messageField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := fieldProto(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	f.TypeName = proto.String("." + specPackage + "." + typeName)
+	return f
+}
+
+func enumField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := fieldProto(name, number, descriptorpb.FieldDescriptorProto_TYPE_ENUM)
+	f.TypeName = proto.String("." + specPackage + "." + typeName)
+	return f
+}
+
+// mapField returns a map field, its entry type is added to the message by withMapEntries
+func mapField(name string, number int32, entryName string) *descriptorpb.FieldDescriptorProto {
+	f := fieldProto(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	f.TypeName = proto.String(entryName)
+	return f
+}
+
+// withMapEntries adds the entry types of the message map fields,
+// the map values are strings unless a message value type is given
+func withMapEntries(msg *descriptorpb.DescriptorProto, valueTypeName ...string) *descriptorpb.DescriptorProto {
+	for _, f := range msg.Field {
+		if f.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			continue
+		}
+		entryName := f.GetTypeName()
+		f.TypeName = proto.String("." + specPackage + "." + msg.GetName() + "." + entryName)
+
+		value := stringField("value", 2)
+		if len(valueTypeName) > 0 {
+			value = messageField("value", 2, valueTypeName[0])
+		}
+		msg.NestedType = append(msg.NestedType, &descriptorpb.DescriptorProto{
+			Name:    proto.String(entryName),
+			Field:   []*descriptorpb.FieldDescriptorProto{stringField("key", 1), value},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		})
+	}
+	return msg
+}
+
+// withOneof groups all the message fields in a oneof
+func withOneof(msg *descriptorpb.DescriptorProto, name string) *descriptorpb.DescriptorProto {
+	msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(name)})
+	for _, f := range msg.Field {
+		f.OneofIndex = proto.Int32(0)
+	}
+	return msg
+}
+
+func service(name string, methods ...*descriptorpb.MethodDescriptorProto) *descriptorpb.ServiceDescriptorProto {
+	return &descriptorpb.ServiceDescriptorProto{Name: proto.String(name), Method: methods}
+}
+
+// method returns a unary method with the spec request/response naming
+func method(name string) *descriptorpb.MethodDescriptorProto {
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String("." + specPackage + "." + name + "Request"),
+		OutputType: proto.String("." + specPackage + "." + name + "Response"),
+	}
+}
+
+// jsonName returns the lowerCamelCase json name of a field
+func jsonName(name string) string {
+	res := make([]byte, 0, len(name))
+	upper := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		res = append(res, c)
+	}
+	return string(res)
+}
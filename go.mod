@@ -3,13 +3,18 @@ module github.com/didil/autobucket-operator
 go 1.13
 
 require (
+	cloud.google.com/go v0.66.0
 	cloud.google.com/go/storage v1.12.0
 	github.com/go-logr/logr v0.1.0
 	github.com/joho/godotenv v1.3.0
 	github.com/onsi/ginkgo v1.12.1
 	github.com/onsi/gomega v1.10.1
+	github.com/prometheus/client_golang v1.0.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/stretchr/testify v1.6.1
 	google.golang.org/api v0.32.0
+	google.golang.org/grpc v1.32.0
+	google.golang.org/protobuf v1.25.0
 	k8s.io/api v0.18.6
 	k8s.io/apimachinery v0.18.6
 	k8s.io/client-go v0.18.6
@@ -0,0 +1,222 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// DefaultBucketNameTemplate is the default bucket full name template: "{prefix}-{namespace}-{name}"
+const DefaultBucketNameTemplate = "{{.Prefix}}-{{.Namespace}}-{{.Name}}"
+
+// BucketNameVars holds the variables available to bucket name templates
+type BucketNameVars struct {
+	// ClusterName is the name of the cluster the operator runs in
+	ClusterName string
+	// Prefix is the bucket name prefix
+	Prefix string
+	// Namespace is the namespace of the bucket owner
+	Namespace string
+	// Name is the name of the bucket owner
+	Name string
+	// Hash is a short deterministic hash of the cluster name, namespace and name
+	Hash string
+}
+
+// BucketNamer builds cloud storage bucket full names from templates
+type BucketNamer struct {
+	// ClusterName is the name of the cluster the operator runs in
+	ClusterName string
+	// Template is the operator-level bucket name template, DefaultBucketNameTemplate if empty
+	Template string
+}
+
+// ParseBucketNameTemplate parses a bucket name template
+func ParseBucketNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("bucket-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse bucket name template: %v", err)
+	}
+
+	return tmpl, nil
+}
+
+// BucketName renders the bucket name template and sanitizes the result for the cloud.
+// tmplOverride takes precedence over the operator-level template when not empty
+func (n BucketNamer) BucketName(cloud string, tmplOverride string, prefix, namespace, name string) (string, error) {
+	text := tmplOverride
+	if text == "" {
+		text = n.Template
+	}
+	if text == "" {
+		text = DefaultBucketNameTemplate
+	}
+
+	tmpl, err := ParseBucketNameTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	vars := BucketNameVars{
+		ClusterName: n.ClusterName,
+		Prefix:      prefix,
+		Namespace:   namespace,
+		Name:        name,
+		Hash:        shortHash(n.ClusterName + "/" + namespace + "/" + name),
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, vars)
+	if err != nil {
+		return "", fmt.Errorf("execute bucket name template: %v", err)
+	}
+
+	return SanitizeBucketName(cloud, buf.String()), nil
+}
+
+// SanitizeBucketName returns a name valid for the cloud storage bucket naming rules
+func SanitizeBucketName(cloud string, name string) string {
+	switch cloud {
+	case "gcp":
+		return SanitizeGCSBucketName(name)
+	default:
+		return name
+	}
+}
+
+// ValidateBucketName checks that a name satisfies the cloud storage bucket naming rules
+func ValidateBucketName(cloud string, name string) error {
+	switch cloud {
+	case "gcp":
+		return ValidateGCSBucketName(name)
+	default:
+		return nil
+	}
+}
+
+const gcsBucketNameMinLength = 3
+const gcsBucketNameMaxLength = 63
+const gcsDottedBucketNameMaxLength = 222
+
+// SanitizeGCSBucketName returns a name valid for the GCS bucket naming rules
+// https://cloud.google.com/storage/docs/naming-buckets
+// names longer than 63 characters are truncated with a deterministic hash suffix
+func SanitizeGCSBucketName(name string) string {
+	original := name
+
+	// only lowercase letters, numbers, dashes and underscores. dots are avoided since dotted names require domain verification
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+
+	// names must start and end with a number or letter
+	name = strings.Trim(name, "-_")
+
+	// names can't contain "google" or a close misspelling, nor start with "goog"
+	for {
+		i := strings.Index(gcsGoogleSpelling(name), "google")
+		if i < 0 {
+			break
+		}
+		name = name[:i] + "gcs" + name[i+len("google"):]
+	}
+	if strings.HasPrefix(gcsGoogleSpelling(name), "goog") {
+		name = "gcs" + name[len("goog"):]
+	}
+
+	if len(name) > gcsBucketNameMaxLength {
+		suffix := "-" + shortHash(original)
+		name = strings.TrimRight(name[:gcsBucketNameMaxLength-len(suffix)], "-_") + suffix
+	}
+
+	if len(name) < gcsBucketNameMinLength {
+		name = strings.TrimLeft(name+"-"+shortHash(original), "-")
+	}
+
+	return name
+}
+
+// ValidateGCSBucketName checks that a name satisfies the GCS bucket naming rules
+// https://cloud.google.com/storage/docs/naming-buckets
+func ValidateGCSBucketName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid gcs bucket name %q: %s", name, reason)
+	}
+
+	maxLength := gcsBucketNameMaxLength
+	if strings.Contains(name, ".") {
+		maxLength = gcsDottedBucketNameMaxLength
+	}
+	if len(name) < gcsBucketNameMinLength || len(name) > maxLength {
+		return invalid(fmt.Sprintf("must be between %d and %d characters", gcsBucketNameMinLength, maxLength))
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return invalid("must only contain lowercase letters, numbers, dashes, underscores and dots")
+		}
+	}
+
+	if !isAlphaNum(name[0]) || !isAlphaNum(name[len(name)-1]) {
+		return invalid("must start and end with a number or letter")
+	}
+
+	for _, component := range strings.Split(name, ".") {
+		if component == "" || len(component) > gcsBucketNameMaxLength {
+			return invalid(fmt.Sprintf("dot-separated components must be between 1 and %d characters", gcsBucketNameMaxLength))
+		}
+	}
+
+	if net.ParseIP(name) != nil {
+		return invalid("must not be an IP address")
+	}
+
+	if spelling := gcsGoogleSpelling(name); strings.HasPrefix(spelling, "goog") || strings.Contains(spelling, "google") {
+		return invalid("must not start with \"goog\" or contain \"google\" or a close misspelling")
+	}
+
+	return nil
+}
+
+// gcsGoogleSpelling maps the digits commonly used to misspell "google" to the letters they stand for,
+// GCS rejects the close misspellings such as "g00gle". The result has the same length as name
+func gcsGoogleSpelling(name string) string {
+	return strings.NewReplacer("0", "o", "1", "l", "3", "e").Replace(name)
+}
+
+func isAlphaNum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// shortHash returns the first 8 hex characters of the sha256 of s
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:8]
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket names", func() {
+
+	Context("When rendering bucket names", func() {
+		It("Should use the default template", func() {
+			name, err := BucketNamer{}.BucketName("gcp", "", "ab", "default", "test-deployment")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(name).To(Equal("ab-default-test-deployment"))
+		})
+
+		It("Should use the operator-level template", func() {
+			namer := BucketNamer{ClusterName: "prod", Template: "{{.Prefix}}-{{.ClusterName}}-{{.Namespace}}-{{.Name}}"}
+			name, err := namer.BucketName("gcp", "", "ab", "default", "test-deployment")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(name).To(Equal("ab-prod-default-test-deployment"))
+		})
+
+		It("Should prefer the template override", func() {
+			namer := BucketNamer{ClusterName: "prod", Template: "{{.Prefix}}-{{.ClusterName}}-{{.Namespace}}-{{.Name}}"}
+			name, err := namer.BucketName("gcp", "{{.Name}}-{{.Hash}}", "ab", "default", "test-deployment")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(name).To(MatchRegexp(`^test-deployment-[0-9a-f]{8}$`))
+		})
+
+		It("Should hash differently across clusters", func() {
+			a, err := BucketNamer{ClusterName: "a"}.BucketName("gcp", "{{.Name}}-{{.Hash}}", "ab", "default", "test")
+			Expect(err).ToNot(HaveOccurred())
+			b, err := BucketNamer{ClusterName: "b"}.BucketName("gcp", "{{.Name}}-{{.Hash}}", "ab", "default", "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(a).ToNot(Equal(b))
+		})
+
+		It("Should reject unknown variables", func() {
+			_, err := BucketNamer{}.BucketName("gcp", "{{.Unknown}}", "ab", "default", "test")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When sanitizing gcs bucket names", func() {
+		It("Should keep valid names", func() {
+			Expect(SanitizeGCSBucketName("ab-default-test_deployment")).To(Equal("ab-default-test_deployment"))
+			Expect(ValidateGCSBucketName("ab-default-test_deployment")).To(Succeed())
+		})
+
+		It("Should replace invalid characters", func() {
+			Expect(SanitizeGCSBucketName("-AB.default.test-")).To(Equal("ab-default-test"))
+			Expect(SanitizeGCSBucketName("goog-my-google-bucket")).To(Equal("gcs-my-gcs-bucket"))
+			Expect(SanitizeGCSBucketName("-goog-bucket")).To(Equal("gcs-bucket"))
+			Expect(SanitizeGCSBucketName("my-G00GLE-bucket")).To(Equal("my-gcs-bucket"))
+			Expect(ValidateGCSBucketName("AB.default")).ToNot(Succeed())
+		})
+
+		It("Should truncate long names deterministically", func() {
+			long := "ab-default-" + strings.Repeat("very-long-deployment-name-", 4)
+			name := SanitizeGCSBucketName(long)
+			Expect(len(name)).To(BeNumerically("<=", 63))
+			Expect(name).To(MatchRegexp(`^ab-default-very-long-deployment-name-.*-[0-9a-f]{8}$`))
+			Expect(SanitizeGCSBucketName(long)).To(Equal(name))
+			Expect(SanitizeGCSBucketName(long + "x")).ToNot(Equal(name))
+			Expect(ValidateGCSBucketName(name)).To(Succeed())
+		})
+
+		It("Should validate names", func() {
+			Expect(ValidateGCSBucketName("assets.example.com")).To(Succeed())
+			Expect(ValidateGCSBucketName("ab")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("ab-Default")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("-ab-default")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("ab..default")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("192.168.5.4")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("my-google-bucket")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("my-g00gle-bucket")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName("g00g-bucket")).ToNot(Succeed())
+			Expect(ValidateGCSBucketName(strings.Repeat("a", 64))).ToNot(Succeed())
+		})
+
+		It("Should pad short names", func() {
+			Expect(len(SanitizeGCSBucketName("a"))).To(BeNumerically(">=", 3))
+		})
+	})
+})
@@ -20,6 +20,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -31,7 +33,9 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 
 	abv1 "github.com/didil/autobucket-operator/api/v1"
+	abv2 "github.com/didil/autobucket-operator/api/v2"
 	"github.com/didil/autobucket-operator/controllers"
+	"github.com/didil/autobucket-operator/cosi"
 	"github.com/didil/autobucket-operator/lib"
 	"github.com/didil/autobucket-operator/services"
 	// +kubebuilder:scaffold:imports
@@ -46,6 +50,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(abv1.AddToScheme(scheme))
+	utilruntime.Must(abv2.AddToScheme(scheme))
 	utilruntime.Must(appsv1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
@@ -63,14 +68,111 @@ func main() {
 
 	var metricsAddr string
 	var enableLeaderElection bool
+	var clusterName string
+	var bucketNameTemplate string
+	var defaultOnDeletePolicy string
+	var defaultRetentionPeriod time.Duration
+	var sweepInterval time.Duration
+	var orphanSweepInterval time.Duration
+	var orphanGracePeriod time.Duration
+	var orphanSweepDryRun bool
+	var annotationRemovalPolicy string
+	var archiveBucket string
+	var emptyWorkers int
+	var defaultLocation string
+	var defaultStorageClass string
+	var claimNamespace string
+	var backupBuckets string
+	var cosiEndpoint string
+	var cosiDriverName string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&clusterName, "cluster-name", os.Getenv("CLUSTER_NAME"),
+		"The name of the cluster, stamped on the created storage buckets and available to bucket name templates as {{.ClusterName}}.")
+	flag.StringVar(&bucketNameTemplate, "bucket-name-template", lib.DefaultBucketNameTemplate,
+		"The bucket full name template. Available variables: {{.ClusterName}}, {{.Prefix}}, {{.Namespace}}, {{.Name}}, {{.Hash}}.")
+	flag.StringVar(&defaultOnDeletePolicy, "default-on-delete-policy", string(abv1.BucketOnDeletePolicyIgnore),
+		"The on delete policy of buckets that don't set one. Valid options: ignore, destroy, retain-for, archive.")
+	flag.DurationVar(&defaultRetentionPeriod, "default-retention-period", abv1.DefaultRetentionPeriod,
+		"The grace period before the storage buckets released with the retain-for on delete policy are destroyed, for buckets that don't set one.")
+	flag.StringVar(&archiveBucket, "archive-bucket", "",
+		"The archive storage bucket full name of the buckets with the archive on delete policy that don't set one.")
+	flag.IntVar(&emptyWorkers, "empty-workers", services.DefaultEmptyWorkers,
+		"The number of objects deleted in parallel when emptying a storage bucket before its deletion.")
+	flag.DurationVar(&sweepInterval, "sweep-interval", controllers.DefaultBucketSweepInterval,
+		"The interval between the checks of the storage buckets pending deletion.")
+	flag.DurationVar(&orphanSweepInterval, "orphan-sweep-interval", controllers.DefaultOrphanSweepInterval,
+		"The interval between the checks of the storage buckets owned by the cluster without a Bucket object.")
+	flag.DurationVar(&orphanGracePeriod, "orphan-grace-period", controllers.DefaultOrphanGracePeriod,
+		"The grace period before the orphaned storage buckets are destroyed.")
+	flag.BoolVar(&orphanSweepDryRun, "orphan-sweep-dry-run", true,
+		"Only report the orphaned storage buckets without destroying them.")
+	flag.StringVar(&annotationRemovalPolicy, "annotation-removal-policy", string(controllers.AnnotationRemovalPolicyKeep),
+		"What happens to the buckets a workload no longer requests through its annotations. Valid options: keep, detach, delete.")
+	flag.StringVar(&defaultLocation, "default-location", "",
+		"The location of created storage buckets that don't set one. Defaults to the cloud default location.")
+	flag.StringVar(&defaultStorageClass, "default-storage-class", "",
+		"The storage class of created storage buckets that don't set one. Defaults to the cloud default storage class.")
+	flag.StringVar(&claimNamespace, "claim-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the Buckets bound to BucketClaims and backing ClusterBuckets. Defaults to the operator namespace.")
+	flag.StringVar(&backupBuckets, "backup-buckets", "",
+		"Comma-separated storage buckets allowed as backup destinations, besides the Buckets of the backup namespace.")
+	flag.StringVar(&cosiEndpoint, "cosi-endpoint", "",
+		"The unix socket of the COSI driver served to the COSI provisioner sidecar, e.g. unix:///var/lib/cosi/cosi.sock. The COSI driver is disabled if empty.")
+	flag.StringVar(&cosiDriverName, "cosi-driver-name", "ab.leclouddev.com",
+		"The COSI driver name, referenced by the COSI BucketClasses.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	if _, err := lib.ParseBucketNameTemplate(bucketNameTemplate); err != nil {
+		setupLog.Error(err, "invalid bucket name template")
+		os.Exit(1)
+	}
+	bucketNamer := lib.BucketNamer{ClusterName: clusterName, Template: bucketNameTemplate}
+
+	if clusterName == "" && !orphanSweepDryRun {
+		// the orphan sweeper would mark the buckets of the other operators without cluster name for deletion
+		setupLog.Error(fmt.Errorf("no cluster name"), "set the --cluster-name flag or the CLUSTER_NAME env to disable the orphan sweep dry-run mode")
+		os.Exit(1)
+	}
+
+	onDeletePolicy := abv1.BucketOnDeletePolicy(defaultOnDeletePolicy)
+	switch onDeletePolicy {
+	case abv1.BucketOnDeletePolicyIgnore, abv1.BucketOnDeletePolicyDestroy, abv1.BucketOnDeletePolicyRetainFor:
+	case abv1.BucketOnDeletePolicyArchive:
+		if archiveBucket == "" {
+			setupLog.Error(fmt.Errorf("no archive bucket"), "set the --archive-bucket flag to use the archive default on delete policy")
+			os.Exit(1)
+		}
+	default:
+		setupLog.Error(fmt.Errorf("unknown on delete policy %q", defaultOnDeletePolicy), "invalid default on delete policy")
+		os.Exit(1)
+	}
+
+	removalPolicy := controllers.AnnotationRemovalPolicy(annotationRemovalPolicy)
+	switch removalPolicy {
+	case controllers.AnnotationRemovalPolicyKeep, controllers.AnnotationRemovalPolicyDetach, controllers.AnnotationRemovalPolicyDelete:
+	default:
+		setupLog.Error(fmt.Errorf("unknown annotation removal policy %q", annotationRemovalPolicy), "invalid annotation removal policy")
+		os.Exit(1)
+	}
+	if defaultRetentionPeriod <= 0 {
+		setupLog.Error(fmt.Errorf("retention period %v is not positive", defaultRetentionPeriod), "invalid default retention period")
+		os.Exit(1)
+	}
+	bucketDefaults := abv1.BucketDefaults{
+		OnDeletePolicy:    onDeletePolicy,
+		RetentionPeriod:   defaultRetentionPeriod,
+		ArchiveBucketName: archiveBucket,
+		NamePrefix:        "ab",
+		BucketNamer:       bucketNamer,
+		Location:          defaultLocation,
+		StorageClass:      defaultStorageClass,
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
@@ -91,27 +193,203 @@ func main() {
 	}
 
 	if err = (&controllers.BucketReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Bucket"),
-		Scheme: mgr.GetScheme(),
-		GCPSvc: gcpSvc,
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("Bucket"),
+		Scheme:         mgr.GetScheme(),
+		GCPSvc:         gcpSvc,
+		ClusterName:    clusterName,
+		EmptyWorkers:   emptyWorkers,
+		ClaimNamespace: claimNamespace,
+		BucketDefaults: bucketDefaults,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Bucket")
 		os.Exit(1)
 	}
-	if err = (&controllers.DeploymentReconciler{
+	if claimNamespace == "" {
+		setupLog.Info("no claim namespace, the BucketClaim and ClusterBucket controllers are disabled. Set the --claim-namespace flag or the POD_NAMESPACE env variable to enable them")
+	} else {
+		if err = (&controllers.BucketClaimReconciler{
+			Client:                mgr.GetClient(),
+			Log:                   ctrl.Log.WithName("controllers").WithName("BucketClaim"),
+			Scheme:                mgr.GetScheme(),
+			BucketNamer:           bucketNamer,
+			ProvisioningNamespace: claimNamespace,
+			BucketDefaults:        bucketDefaults,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "BucketClaim")
+			os.Exit(1)
+		}
+		if err = (&controllers.ClusterBucketReconciler{
+			Client:                mgr.GetClient(),
+			Log:                   ctrl.Log.WithName("controllers").WithName("ClusterBucket"),
+			Scheme:                mgr.GetScheme(),
+			BucketNamer:           bucketNamer,
+			ProvisioningNamespace: claimNamespace,
+			BucketDefaults:        bucketDefaults,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterBucket")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.ClusterBucketBindingReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("ClusterBucketBinding"),
+		Scheme:    mgr.GetScheme(),
+		GCPSvc:    gcpSvc,
+		ProjectID: os.Getenv("GCP_PROJECT"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterBucketBinding")
+		os.Exit(1)
+	}
+	if err = (&controllers.BucketQuotaReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("BucketQuota"),
+		Scheme:         mgr.GetScheme(),
+		GCPSvc:         gcpSvc,
+		ClaimNamespace: claimNamespace,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BucketQuota")
+		os.Exit(1)
+	}
+	if err = (&controllers.BucketBackupReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("BucketBackup"),
+		Scheme:        mgr.GetScheme(),
+		GCPSvc:        gcpSvc,
+		DeleteWorkers: emptyWorkers,
+		BackupBuckets: splitList(backupBuckets),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BucketBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.BucketBackupScheduleReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Deployment"),
+		Log:    ctrl.Log.WithName("controllers").WithName("BucketBackupSchedule"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Deployment")
+		setupLog.Error(err, "unable to create controller", "controller", "BucketBackupSchedule")
+		os.Exit(1)
+	}
+	if err = (&controllers.BucketRestoreReconciler{
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("BucketRestore"),
+		Scheme:      mgr.GetScheme(),
+		GCPSvc:      gcpSvc,
+		ClusterName: clusterName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BucketRestore")
 		os.Exit(1)
 	}
+	if err = (&controllers.BucketReplicationReconciler{
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("BucketReplication"),
+		Scheme:      mgr.GetScheme(),
+		GCPSvc:      gcpSvc,
+		ClusterName: clusterName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BucketReplication")
+		os.Exit(1)
+	}
+	for _, kind := range controllers.WorkloadKinds {
+		if err = (&controllers.WorkloadReconciler{
+			Client:                  mgr.GetClient(),
+			Log:                     ctrl.Log.WithName("controllers").WithName(kind.Name),
+			Scheme:                  mgr.GetScheme(),
+			Kind:                    kind,
+			BucketNamer:             bucketNamer,
+			BucketDefaults:          bucketDefaults,
+			AnnotationRemovalPolicy: removalPolicy,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", kind.Name)
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&abv1.Bucket{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Bucket")
+			os.Exit(1)
+		}
+		if err = (&abv1.BucketDefaulter{
+			Client:   mgr.GetClient(),
+			Defaults: bucketDefaults,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Bucket")
+			os.Exit(1)
+		}
+		if err = (&abv1.BucketValidator{
+			Client:         mgr.GetClient(),
+			ClaimNamespace: claimNamespace,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Bucket")
+			os.Exit(1)
+		}
+		for _, kind := range controllers.WorkloadKinds {
+			if err = (&controllers.WorkloadValidator{
+				Client: mgr.GetClient(),
+				Log:    ctrl.Log.WithName("webhooks").WithName(kind.Name),
+				Kind:   kind,
+			}).SetupWebhookWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create webhook", "webhook", kind.Name)
+				os.Exit(1)
+			}
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
+	if err = mgr.Add(&controllers.BucketSweeper{
+		Log:          ctrl.Log.WithName("sweeper"),
+		GCPSvc:       gcpSvc,
+		ClusterName:  clusterName,
+		Interval:     sweepInterval,
+		EmptyWorkers: emptyWorkers,
+	}); err != nil {
+		setupLog.Error(err, "unable to add bucket sweeper")
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(&controllers.OrphanSweeper{
+		Reader:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("orphan-sweeper"),
+		GCPSvc:      gcpSvc,
+		ClusterName: clusterName,
+		Interval:    orphanSweepInterval,
+		GracePeriod: orphanGracePeriod,
+		DryRun:      orphanSweepDryRun,
+	}); err != nil {
+		setupLog.Error(err, "unable to add orphan sweeper")
+		os.Exit(1)
+	}
+
+	if cosiEndpoint != "" {
+		if err = mgr.Add(&cosi.Server{
+			Endpoint: cosiEndpoint,
+			Driver: &cosi.Driver{
+				Name:        cosiDriverName,
+				ClusterName: clusterName,
+				ProjectID:   os.Getenv("GCP_PROJECT"),
+				GCPSvc:      gcpSvc,
+				Log:         ctrl.Log.WithName("cosi"),
+			},
+		}); err != nil {
+			setupLog.Error(err, "unable to add cosi driver")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// splitList returns the non-empty items of a comma-separated list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BucketAttrs are the attributes of a cloud storage bucket
+type BucketAttrs struct {
+	// Location is the bucket location, the cloud default if empty
+	Location string
+	// StorageClass is the bucket default storage class, the cloud default if empty
+	StorageClass string
+	// Labels are the bucket labels
+	Labels map[string]string
+	// Lifecycle are the bucket object lifecycle rules, none if nil
+	Lifecycle *BucketLifecycle
+	// KMSKeyName is the default encryption key of the bucket objects, the cloud managed encryption if empty
+	KMSKeyName string
+	// Created is the bucket creation time
+	Created time.Time
+}
+
+// BucketLifecycle are the object lifecycle rules of a cloud storage bucket
+type BucketLifecycle struct {
+	// DeleteAfterDays deletes the objects older than the given number of days, never if 0
+	DeleteAfterDays int32
+	// Transitions move the objects to other storage classes as they age
+	Transitions []BucketLifecycleTransition
+}
+
+// BucketLifecycleTransition moves the objects to another storage class after a given age
+type BucketLifecycleTransition struct {
+	// AfterDays is the object age in days
+	AfterDays int32
+	// StorageClass is the target storage class
+	StorageClass string
+}
+
+// BucketArchive is the destination of the archived objects of a cloud storage bucket
+type BucketArchive struct {
+	// BucketName is the archive bucket name
+	BucketName string
+	// Prefix is prepended to the archived object names
+	Prefix string
+	// StorageClass is the storage class of the archived objects, the archive bucket default if empty
+	StorageClass string
+}
+
+// ArchiveProgress reports the objects archived by an archive batch
+type ArchiveProgress struct {
+	// Objects is the number of objects archived by the batch
+	Objects int64
+	// Bytes is the size of the objects archived by the batch
+	Bytes int64
+	// Failures is the number of objects that couldn't be archived
+	Failures int64
+	// LastError is the last archive error, empty if none
+	LastError string
+	// LastObject is the name of the last processed object, empty if none
+	LastObject string
+	// Done is true when all the objects are processed
+	Done bool
+}
+
+// BackupDestination is the location of a bucket backup
+type BackupDestination struct {
+	// BucketName is the destination bucket name
+	BucketName string
+	// Prefix is prepended to the backed up object names
+	Prefix string
+	// BasePrefix is the prefix of a previous backup in the destination bucket, the objects unchanged since are copied from it.
+	// Full backup if empty
+	BasePrefix string
+}
+
+// BackupProgress reports the objects copied by a backup batch
+type BackupProgress struct {
+	// Objects is the number of objects copied from the source bucket by the batch
+	Objects int64
+	// Unchanged is the number of objects unchanged since the base backup, copied from it by the batch
+	Unchanged int64
+	// Bytes is the size of the objects backed up by the batch
+	Bytes int64
+	// Failures is the number of objects that couldn't be copied
+	Failures int64
+	// LastError is the last copy error, empty if none
+	LastError string
+	// LastObject is the name of the last processed object, empty if none
+	LastObject string
+	// Done is true when all the objects are processed
+	Done bool
+}
+
+// RestoreSource is the origin of the restored objects
+type RestoreSource struct {
+	// BucketName is the bucket of the restored backup, empty for a point in time restore of the target bucket object versions
+	BucketName string
+	// Prefix is the prefix of the restored backup in its bucket
+	Prefix string
+	// PointInTime restores the object versions live at that time if BucketName is empty
+	PointInTime time.Time
+}
+
+// RestoreConflictPolicy defines how the restore handles the objects existing in the target bucket with a different content
+type RestoreConflictPolicy string
+
+const (
+	// RestoreConflictSkip keeps the existing objects
+	RestoreConflictSkip RestoreConflictPolicy = "skip"
+	// RestoreConflictOverwrite replaces the existing objects
+	RestoreConflictOverwrite RestoreConflictPolicy = "overwrite"
+	// RestoreConflictFail stops the restore on the first existing object
+	RestoreConflictFail RestoreConflictPolicy = "fail"
+)
+
+// RestoreProgress reports the objects restored by a restore batch
+type RestoreProgress struct {
+	// Objects is the number of objects restored by the batch
+	Objects int64
+	// Skipped is the number of objects left alone by the batch: unchanged, or existing with the skip conflict policy
+	Skipped int64
+	// Bytes is the size of the objects restored by the batch
+	Bytes int64
+	// Failures is the number of objects that couldn't be restored
+	Failures int64
+	// LastError is the last restore error, empty if none
+	LastError string
+	// Conflict is the name of the existing object that stopped the restore with the fail conflict policy
+	Conflict string
+	// LastObject is the name of the last processed object, empty if none
+	LastObject string
+	// Done is true when all the objects are processed
+	Done bool
+}
+
+// ReplicationProgress reports the objects replicated by a replication batch
+type ReplicationProgress struct {
+	// Objects is the number of objects compared by the batch
+	Objects int64
+	// Copied is the number of new or changed objects copied by the batch
+	Copied int64
+	// Deleted is the number of destination objects deleted by the batch as they were removed from the source
+	Deleted int64
+	// Bytes is the size of the objects copied by the batch
+	Bytes int64
+	// Failures is the number of objects that couldn't be replicated
+	Failures int64
+	// LastError is the last replication error, empty if none
+	LastError string
+	// LastObject is the name of the last compared object, empty if none
+	LastObject string
+	// Done is true when all the objects are compared
+	Done bool
+}
+
+// EmptyProgress reports the objects deleted by an empty call
+type EmptyProgress struct {
+	// Objects is the number of deleted object versions
+	Objects int64
+	// Bytes is the size of the deleted object versions
+	Bytes int64
+	// Done is true when the bucket is empty
+	Done bool
+}
+
+// DefaultEmptyWorkers is the default number of objects deleted in parallel when emptying a bucket
+const DefaultEmptyWorkers = 16
+
+// ErrBucketNotFound is returned when a bucket doesn't exist
+var ErrBucketNotFound = errors.New("bucket not found")
+
+// ErrSourceBucketNotFound is returned when the bucket holding the objects to restore doesn't exist
+var ErrSourceBucketNotFound = errors.New("source bucket not found")
+
+// BucketOwner identifies the operator object owning a cloud storage bucket
+type BucketOwner struct {
+	// ClusterID identifies the cluster the operator runs in
+	ClusterID string
+	// UID is the owner object uid
+	UID string
+	// Namespace is the owner object namespace, a released bucket can only be re-adopted from the same namespace
+	Namespace string
+	// Name is the owner object name, a recreated owner object with the same namespace and name re-adopts the bucket
+	Name string
+}
+
+// ErrBucketConflict is returned when a bucket already exists and is not owned by the caller
+var ErrBucketConflict = errors.New("bucket already exists and is not owned by this operator object")
+
+const ownerClusterLabel = "autobucket-cluster"
+const ownerUIDLabel = "autobucket-uid"
+const ownerNamespaceLabel = "autobucket-namespace"
+const ownerNameLabel = "autobucket-name"
+
+// labels returns the bucket labels stamping the ownership
+func (owner BucketOwner) labels() map[string]string {
+	labels := map[string]string{
+		ownerClusterLabel: labelValue(owner.ClusterID),
+		ownerUIDLabel:     labelValue(owner.UID),
+	}
+	if owner.Namespace != "" {
+		labels[ownerNamespaceLabel] = labelValue(owner.Namespace)
+		labels[ownerNameLabel] = labelValue(owner.Name)
+	}
+	return labels
+}
+
+// isOwned checks if the bucket labels carry an ownership
+func isOwned(labels map[string]string) bool {
+	return labels[ownerClusterLabel] != "" || labels[ownerUIDLabel] != ""
+}
+
+// owns checks if the bucket labels match the ownership
+func (owner BucketOwner) owns(labels map[string]string) bool {
+	return labels[ownerClusterLabel] == labelValue(owner.ClusterID) && labels[ownerUIDLabel] == labelValue(owner.UID)
+}
+
+const pendingDeletionLabel = "autobucket-pending-deletion"
+const deleteAfterLabel = "autobucket-delete-after"
+
+// PendingDeletionBucket is a cloud storage bucket released by its owner and destroyed once its retention period is over
+type PendingDeletionBucket struct {
+	// Name is the bucket name
+	Name string
+	// Owner is the ownership of the released bucket
+	Owner BucketOwner
+	// DeleteAfter is the end of the retention period
+	DeleteAfter time.Time
+}
+
+// OwnedBucket is a cloud storage bucket stamped with the ownership labels of a cluster
+type OwnedBucket struct {
+	// Name is the bucket name
+	Name string
+	// Owner is the bucket ownership
+	Owner BucketOwner
+	// PendingDeletion is true if the bucket is pending deletion
+	PendingDeletion bool
+	// SelfOwned is true if the owner UID is the bucket name, as stamped by the COSI driver which has no Bucket objects
+	SelfOwned bool
+	// Created is the bucket creation time
+	Created time.Time
+}
+
+// pendingDeletionLabels returns the bucket labels marking the bucket as pending deletion
+func pendingDeletionLabels(deleteAfter time.Time) map[string]string {
+	return map[string]string{
+		pendingDeletionLabel: "true",
+		deleteAfterLabel:     strconv.FormatInt(deleteAfter.Unix(), 10),
+	}
+}
+
+// pendingDeletion returns the end of the retention period if the bucket labels mark the bucket as pending deletion
+func pendingDeletion(labels map[string]string) (time.Time, bool) {
+	if labels[pendingDeletionLabel] != "true" {
+		return time.Time{}, false
+	}
+	deleteAfter, err := strconv.ParseInt(labels[deleteAfterLabel], 10, 64)
+	if err != nil {
+		// unreadable timestamp, keep the bucket until the label is fixed
+		return time.Time{}, false
+	}
+	return time.Unix(deleteAfter, 0), true
+}
+
+// canReadopt checks if the bucket was released by a previous owner of the same cluster and namespace:
+// any owner of the namespace can re-adopt a bucket pending deletion, and an owner with the same name,
+// e.g. the Bucket of a recreated workload, can re-adopt a bucket released with the ignore on delete policy
+func (owner BucketOwner) canReadopt(labels map[string]string) bool {
+	if owner.Namespace == "" || labels[ownerClusterLabel] != labelValue(owner.ClusterID) ||
+		labels[ownerNamespaceLabel] != labelValue(owner.Namespace) {
+		return false
+	}
+	if _, pending := pendingDeletion(labels); pending {
+		return true
+	}
+	return labels[ownerNameLabel] == labelValue(owner.Name)
+}
+
+// labelValue returns a valid gcp label value: lowercase letters, numbers, dashes and underscores, 63 characters max
+func labelValue(v string) string {
+	v = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, v)
+	if len(v) > 63 {
+		v = v[:63]
+	}
+	return v
+}
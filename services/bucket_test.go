@@ -0,0 +1,50 @@
+package services
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket ownership", func() {
+	owner := BucketOwner{ClusterID: "prod", UID: "uid-2", Namespace: "team-a", Name: "uploads"}
+	pending := pendingDeletionLabels(time.Now().Add(time.Hour))
+
+	withLabels := func(extra ...map[string]string) map[string]string {
+		labels := map[string]string{}
+		for _, m := range extra {
+			for k, v := range m {
+				labels[k] = v
+			}
+		}
+		return labels
+	}
+
+	It("Should own the buckets stamped with the cluster and uid", func() {
+		Expect(owner.owns(owner.labels())).To(BeTrue())
+		// buckets stamped before the namespace was part of the ownership
+		Expect(owner.owns(map[string]string{ownerClusterLabel: "prod", ownerUIDLabel: "uid-2"})).To(BeTrue())
+		Expect(owner.owns(map[string]string{ownerClusterLabel: "prod", ownerUIDLabel: "uid-1"})).To(BeFalse())
+		Expect(owner.owns(map[string]string{ownerClusterLabel: "staging", ownerUIDLabel: "uid-2"})).To(BeFalse())
+	})
+
+	DescribeTable("re-adoption of a released bucket",
+		func(labels map[string]string, expected bool) {
+			Expect(owner.canReadopt(labels)).To(Equal(expected))
+		},
+		Entry("same namespace and name", withLabels(BucketOwner{ClusterID: "prod", UID: "uid-1", Namespace: "team-a", Name: "uploads"}.labels()), true),
+		Entry("same namespace, other name", withLabels(BucketOwner{ClusterID: "prod", UID: "uid-1", Namespace: "team-a", Name: "assets"}.labels()), false),
+		Entry("same namespace, other name, pending deletion", withLabels(BucketOwner{ClusterID: "prod", UID: "uid-1", Namespace: "team-a", Name: "assets"}.labels(), pending), true),
+		Entry("other namespace, pending deletion", withLabels(BucketOwner{ClusterID: "prod", UID: "uid-1", Namespace: "team-b", Name: "uploads"}.labels(), pending), false),
+		Entry("other cluster", withLabels(BucketOwner{ClusterID: "staging", UID: "uid-1", Namespace: "team-a", Name: "uploads"}.labels()), false),
+		Entry("no namespace label, pending deletion", withLabels(BucketOwner{ClusterID: "prod", UID: "uid-1"}.labels(), pending), false),
+	)
+
+	It("Should not re-adopt for owners without namespace", func() {
+		cosiOwner := BucketOwner{ClusterID: "prod", UID: "ab-bucket"}
+		Expect(cosiOwner.labels()).NotTo(HaveKey(ownerNamespaceLabel))
+		Expect(cosiOwner.canReadopt(withLabels(cosiOwner.labels(), pending))).To(BeFalse())
+	})
+})
@@ -1,18 +1,42 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"cloud.google.com/go/iam"
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
 // GCPSvc GCP Service interface
 type GCPSvc interface {
-	CreateBucket(ctx context.Context, name string) error
-	DeleteGCPBucket(ctx context.Context, name string) error
+	CreateBucket(ctx context.Context, name string, attrs BucketAttrs, owner BucketOwner) error
+	AdoptBucket(ctx context.Context, name string, owner BucketOwner) (*BucketAttrs, error)
+	GetBucketAttrs(ctx context.Context, name string) (*BucketAttrs, error)
+	GetBucketSize(ctx context.Context, name string) (int64, error)
+	GrantBucketAccess(ctx context.Context, name string, member string, role string) error
+	RevokeBucketAccess(ctx context.Context, name string, member string, role string) error
+	DeleteGCPBucket(ctx context.Context, name string, owner BucketOwner) error
+	RetainGCPBucket(ctx context.Context, name string, owner BucketOwner, deleteAfter time.Time) error
+	ReleaseGCPBucket(ctx context.Context, name string, owner BucketOwner) error
+	ListPendingDeletionBuckets(ctx context.Context, clusterID string) ([]PendingDeletionBucket, error)
+	ListOwnedBuckets(ctx context.Context, clusterID string) ([]OwnedBucket, error)
+	EmptyGCPBucket(ctx context.Context, name string, owner BucketOwner, workers int) (*EmptyProgress, error)
+	ArchiveGCPBucketObjects(ctx context.Context, name string, owner BucketOwner, archive BucketArchive, startAfter string, maxObjects int) (*ArchiveProgress, error)
+	BackupGCPBucketObjects(ctx context.Context, name string, dest BackupDestination, startAfter string, maxObjects int) (*BackupProgress, error)
+	DeleteGCPObjects(ctx context.Context, name string, prefix string, workers int) (*EmptyProgress, error)
+	RestoreGCPBucketObjects(ctx context.Context, name string, owner BucketOwner, source RestoreSource, conflict RestoreConflictPolicy, startAfter string, maxObjects int) (*RestoreProgress, error)
+	ReplicateGCPBucketObjects(ctx context.Context, source string, dest string, owner BucketOwner, deleteRemoved bool, startAfter string, maxObjects int) (*ReplicationProgress, error)
 }
 
 // GCPService GCP Service struct
@@ -35,21 +59,49 @@ func NewGCPService() (*GCPService, error) {
 	return svc, nil
 }
 
-// CreateBucket creates a gcp bucket
-func (svc *GCPService) CreateBucket(ctx context.Context, name string) error {
+// CreateBucket creates a gcp bucket stamped with the owner labels, or re-adopts a bucket released by a previous owner of the same namespace
+// returns ErrBucketConflict if the bucket already exists with another owner
+func (svc *GCPService) CreateBucket(ctx context.Context, name string, attrs BucketAttrs, owner BucketOwner) error {
 	cl := svc.storageClient
 
 	bucket := cl.Bucket(name)
 
-	_, err := bucket.Attrs(ctx)
+	existingAttrs, err := bucket.Attrs(ctx)
 	if err == nil {
-		return nil // bucket already exists, noop
+		if owner.owns(existingAttrs.Labels) {
+			return nil // bucket already exists, noop
+		}
+		if owner.canReadopt(existingAttrs.Labels) {
+			_, err = svc.readoptBucket(ctx, bucket, owner)
+			return err
+		}
+		return ErrBucketConflict
 	}
 	if err != nil && err != storage.ErrBucketNotExist {
 		return fmt.Errorf("bucket attrs: %v", err)
 	}
 
-	err = bucket.Create(ctx, os.Getenv("GCP_PROJECT"), nil)
+	labels := map[string]string{}
+	for k, v := range attrs.Labels {
+		labels[k] = v
+	}
+	for k, v := range owner.labels() {
+		labels[k] = v
+	}
+
+	gcpAttrs := &storage.BucketAttrs{
+		Location:     attrs.Location,
+		StorageClass: attrs.StorageClass,
+		Labels:       labels,
+	}
+	if attrs.Lifecycle != nil {
+		gcpAttrs.Lifecycle = gcpLifecycle(attrs.Lifecycle)
+	}
+	if attrs.KMSKeyName != "" {
+		gcpAttrs.Encryption = &storage.BucketEncryption{DefaultKMSKeyName: attrs.KMSKeyName}
+	}
+
+	err = bucket.Create(ctx, os.Getenv("GCP_PROJECT"), gcpAttrs)
 	if err != nil {
 		return fmt.Errorf("create: %v", err)
 	}
@@ -57,44 +109,1017 @@ func (svc *GCPService) CreateBucket(ctx context.Context, name string) error {
 	return nil
 }
 
-// DeleteGCPBucket deletes a gcp bucket
-func (svc *GCPService) DeleteGCPBucket(ctx context.Context, name string) error {
+// AdoptBucket stamps the owner labels on an existing gcp bucket
+// returns ErrBucketNotFound if the bucket doesn't exist, ErrBucketConflict if it is owned by another owner
+func (svc *GCPService) AdoptBucket(ctx context.Context, name string, owner BucketOwner) (*BucketAttrs, error) {
 	cl := svc.storageClient
 
 	bucket := cl.Bucket(name)
 
-	_, err := bucket.Attrs(ctx)
+	attrs, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+
+	if owner.owns(attrs.Labels) {
+		return gcpBucketAttrs(attrs), nil // already adopted, noop
+	}
+	if owner.canReadopt(attrs.Labels) {
+		return svc.readoptBucket(ctx, bucket, owner)
+	}
+	if isOwned(attrs.Labels) {
+		return nil, ErrBucketConflict
+	}
+
+	uattrs := storage.BucketAttrsToUpdate{}
+	for k, v := range owner.labels() {
+		uattrs.SetLabel(k, v)
+	}
+
+	attrs, err = bucket.Update(ctx, uattrs)
+	if err != nil {
+		return nil, fmt.Errorf("update: %v", err)
+	}
+
+	return gcpBucketAttrs(attrs), nil
+}
+
+// readoptBucket stamps the owner labels on a gcp bucket released by a previous owner, cancelling its deletion if pending
+func (svc *GCPService) readoptBucket(ctx context.Context, bucket *storage.BucketHandle, owner BucketOwner) (*BucketAttrs, error) {
+	uattrs := storage.BucketAttrsToUpdate{}
+	for k, v := range owner.labels() {
+		uattrs.SetLabel(k, v)
+	}
+	uattrs.DeleteLabel(pendingDeletionLabel)
+	uattrs.DeleteLabel(deleteAfterLabel)
+
+	attrs, err := bucket.Update(ctx, uattrs)
+	if err != nil {
+		return nil, fmt.Errorf("update: %v", err)
+	}
+
+	return gcpBucketAttrs(attrs), nil
+}
+
+// GetBucketAttrs returns the attributes of a gcp bucket
+// returns ErrBucketNotFound if the bucket doesn't exist
+func (svc *GCPService) GetBucketAttrs(ctx context.Context, name string) (*BucketAttrs, error) {
+	cl := svc.storageClient
+
+	attrs, err := cl.Bucket(name).Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+
+	return gcpBucketAttrs(attrs), nil
+}
+
+// GetBucketSize returns the total size in bytes of the objects of a gcp bucket
+// returns ErrBucketNotFound if the bucket doesn't exist
+func (svc *GCPService) GetBucketSize(ctx context.Context, name string) (int64, error) {
+	cl := svc.storageClient
+
+	objects := cl.Bucket(name).Objects(ctx, nil)
+
+	var size int64
+	for {
+		objAttrs, err := objects.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			if err == storage.ErrBucketNotExist {
+				return 0, ErrBucketNotFound
+			}
+			return 0, fmt.Errorf("bucket iterator: %v", err)
+		}
+		size += objAttrs.Size
+	}
+
+	return size, nil
+}
+
+// GrantBucketAccess adds an IAM role binding to a gcp bucket, e.g. member "serviceAccount:{email}" and role "roles/storage.objectViewer"
+// returns ErrBucketNotFound if the bucket doesn't exist
+func (svc *GCPService) GrantBucketAccess(ctx context.Context, name string, member string, role string) error {
+	return svc.updateBucketPolicy(ctx, name, func(policy *iam.Policy) bool {
+		if policy.HasRole(member, iam.RoleName(role)) {
+			return false
+		}
+		policy.Add(member, iam.RoleName(role))
+		return true
+	})
+}
+
+// RevokeBucketAccess removes an IAM role binding from a gcp bucket, noop if the bucket doesn't exist
+func (svc *GCPService) RevokeBucketAccess(ctx context.Context, name string, member string, role string) error {
+	err := svc.updateBucketPolicy(ctx, name, func(policy *iam.Policy) bool {
+		if !policy.HasRole(member, iam.RoleName(role)) {
+			return false
+		}
+		policy.Remove(member, iam.RoleName(role))
+		return true
+	})
+	if err == ErrBucketNotFound {
+		return nil // bucket doesn't exist, noop
+	}
+	return err
+}
+
+// updateBucketPolicy updates the IAM policy of a gcp bucket, the policy is only saved if update returns true
+func (svc *GCPService) updateBucketPolicy(ctx context.Context, name string, update func(policy *iam.Policy) bool) error {
+	cl := svc.storageClient
+
+	handle := cl.Bucket(name).IAM()
+
+	policy, err := handle.Policy(ctx)
+	if err != nil {
+		if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+			return ErrBucketNotFound
+		}
+		return fmt.Errorf("bucket iam policy: %v", err)
+	}
+
+	if !update(policy) {
+		return nil
+	}
+
+	err = handle.SetPolicy(ctx, policy)
+	if err != nil {
+		return fmt.Errorf("set bucket iam policy: %v", err)
+	}
+
+	return nil
+}
+
+// gcpLifecycle converts lifecycle rules to gcp lifecycle rules
+func gcpLifecycle(lifecycle *BucketLifecycle) storage.Lifecycle {
+	gcpLifecycle := storage.Lifecycle{}
+	if lifecycle.DeleteAfterDays > 0 {
+		gcpLifecycle.Rules = append(gcpLifecycle.Rules, storage.LifecycleRule{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: int64(lifecycle.DeleteAfterDays)},
+		})
+	}
+	for _, t := range lifecycle.Transitions {
+		gcpLifecycle.Rules = append(gcpLifecycle.Rules, storage.LifecycleRule{
+			Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: t.StorageClass},
+			Condition: storage.LifecycleCondition{AgeInDays: int64(t.AfterDays)},
+		})
+	}
+	return gcpLifecycle
+}
+
+// gcpBucketAttrs converts gcp bucket attributes
+func gcpBucketAttrs(attrs *storage.BucketAttrs) *BucketAttrs {
+	return &BucketAttrs{
+		Location:     attrs.Location,
+		StorageClass: attrs.StorageClass,
+		Labels:       attrs.Labels,
+		Created:      attrs.Created,
+	}
+}
+
+// DeleteGCPBucket deletes a gcp bucket, after deleting all its object versions
+// returns ErrBucketConflict if the bucket is not owned by the owner
+func (svc *GCPService) DeleteGCPBucket(ctx context.Context, name string, owner BucketOwner) error {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+
+	attrs, err := bucket.Attrs(ctx)
 	if err == storage.ErrBucketNotExist {
 		return nil // bucket doesn't exists, noop
 	}
 	if err != nil {
 		return fmt.Errorf("bucket attrs: %v", err)
 	}
+	if !owner.owns(attrs.Labels) {
+		return ErrBucketConflict
+	}
 
 	// delete all objects first (required by storage api)
+	progress, err := emptyGCPBucket(ctx, bucket, "", DefaultEmptyWorkers)
+	if err != nil {
+		return err
+	}
+	if !progress.Done {
+		return fmt.Errorf("empty: %v", ctx.Err())
+	}
+
+	err = bucket.Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("delete: %v", err)
+	}
+
+	return nil
+}
+
+// EmptyGCPBucket deletes the object versions of a gcp bucket with a pool of workers until the bucket is empty or the context is done,
+// a context deadline stops the deletion without error so that it can be resumed by a later call
+// returns ErrBucketConflict if the bucket is not owned by the owner
+func (svc *GCPService) EmptyGCPBucket(ctx context.Context, name string, owner BucketOwner, workers int) (*EmptyProgress, error) {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+
+	attrs, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return &EmptyProgress{Done: true}, nil // bucket doesn't exists, noop
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+	if !owner.owns(attrs.Labels) {
+		return nil, ErrBucketConflict
+	}
+
+	return emptyGCPBucket(ctx, bucket, "", workers)
+}
+
+// DeleteGCPObjects deletes the object versions with the given prefix of a gcp bucket not managed by the operator, e.g. a backup.
+// Like EmptyGCPBucket, a context deadline stops the deletion without error so that it can be resumed by a later call
+func (svc *GCPService) DeleteGCPObjects(ctx context.Context, name string, prefix string, workers int) (*EmptyProgress, error) {
+	cl := svc.storageClient
+
+	progress, err := emptyGCPBucket(ctx, cl.Bucket(name), prefix, workers)
+	if err != nil && ctx.Err() == nil {
+		// the bucket listing fails if the bucket doesn't exist
+		if _, attrsErr := cl.Bucket(name).Attrs(ctx); attrsErr == storage.ErrBucketNotExist {
+			return &EmptyProgress{Done: true}, nil
+		}
+	}
+	return progress, err
+}
+
+// emptyGCPBucket deletes the object versions with the given prefix of a gcp bucket, including the noncurrent versions, with a pool of workers
+func emptyGCPBucket(ctx context.Context, bucket *storage.BucketHandle, prefix string, workers int) (*EmptyProgress, error) {
+	objects := listGCPObjects(bucket.Objects(ctx, &storage.Query{Versions: true, Prefix: prefix}), "")
+	return deleteObjects(ctx, objects, func(objAttrs *storage.ObjectAttrs) error {
+		return bucket.Object(objAttrs.Name).Generation(objAttrs.Generation).Delete(ctx)
+	}, workers)
+}
+
+// deleteObjects deletes the listed objects with a pool of workers, the objects already deleted are ignored.
+// The progress is done when all the objects are listed and deleted without error before the context is done
+func deleteObjects(ctx context.Context, objects gcpObjects, del func(objAttrs *storage.ObjectAttrs) error, workers int) (*EmptyProgress, error) {
+	if workers <= 0 {
+		workers = DefaultEmptyWorkers
+	}
+
+	var objectsDeleted, bytes int64
+	var errOnce sync.Once
+	var firstErr error
+
+	jobs := make(chan *storage.ObjectAttrs)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for objAttrs := range jobs {
+				err := del(objAttrs)
+				if err == storage.ErrObjectNotExist {
+					continue // already deleted, e.g. by a previous call
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						errOnce.Do(func() { firstErr = fmt.Errorf("bucket obj delete: %v", err) })
+					}
+					continue
+				}
+				atomic.AddInt64(&objectsDeleted, 1)
+				atomic.AddInt64(&bytes, objAttrs.Size)
+			}
+		}()
+	}
+
+	listed := false
+list:
+	for {
+		objAttrs, err := objects()
+		if err != nil {
+			if ctx.Err() == nil {
+				errOnce.Do(func() { firstErr = err })
+			}
+			break
+		}
+		if objAttrs == nil {
+			listed = true
+			break
+		}
+
+		select {
+		case jobs <- objAttrs:
+		case <-ctx.Done():
+			break list
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	progress := &EmptyProgress{
+		Objects: atomic.LoadInt64(&objectsDeleted),
+		Bytes:   atomic.LoadInt64(&bytes),
+		Done:    listed && firstErr == nil && ctx.Err() == nil,
+	}
+	return progress, firstErr
+}
+
+// gcpObjects returns the next listed object, nil when all the objects are listed
+type gcpObjects func() (*storage.ObjectAttrs, error)
+
+// listGCPObjects iterates over the listed objects, skipping the startAfter object versions:
+// the query start offset is inclusive, the start object was processed by the previous batch
+func listGCPObjects(it *storage.ObjectIterator, startAfter string) gcpObjects {
+	return func() (*storage.ObjectAttrs, error) {
+		for {
+			objAttrs, err := it.Next()
+			if err == iterator.Done {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("bucket iterator: %v", err)
+			}
+			if startAfter == "" || objAttrs.Name != startAfter {
+				return objAttrs, nil
+			}
+		}
+	}
+}
+
+// RetainGCPBucket marks a gcp bucket as pending deletion after deleteAfter, noop if the bucket doesn't exist or is already pending deletion
+// returns ErrBucketConflict if the bucket is not owned by the owner
+func (svc *GCPService) RetainGCPBucket(ctx context.Context, name string, owner BucketOwner, deleteAfter time.Time) error {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+
+	attrs, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil // bucket doesn't exists, noop
+	}
+	if err != nil {
+		return fmt.Errorf("bucket attrs: %v", err)
+	}
+	if !owner.owns(attrs.Labels) {
+		return ErrBucketConflict
+	}
+	if _, pending := pendingDeletion(attrs.Labels); pending {
+		return nil // keep the original retention period
+	}
+
+	uattrs := storage.BucketAttrsToUpdate{}
+	// stamp the namespace of buckets created before it was part of the ownership, so that they can be re-adopted
+	for k, v := range owner.labels() {
+		uattrs.SetLabel(k, v)
+	}
+	for k, v := range pendingDeletionLabels(deleteAfter) {
+		uattrs.SetLabel(k, v)
+	}
+
+	_, err = bucket.Update(ctx, uattrs)
+	if err != nil {
+		return fmt.Errorf("update: %v", err)
+	}
+
+	return nil
+}
+
+// ReleaseGCPBucket stamps the full ownership on a gcp bucket left behind by its owner, so that a recreated owner
+// with the same namespace and name can re-adopt it, noop if the bucket doesn't exist or is already stamped
+// returns ErrBucketConflict if the bucket is not owned by the owner
+func (svc *GCPService) ReleaseGCPBucket(ctx context.Context, name string, owner BucketOwner) error {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+
+	attrs, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil // bucket doesn't exists, noop
+	}
+	if err != nil {
+		return fmt.Errorf("bucket attrs: %v", err)
+	}
+	if !owner.owns(attrs.Labels) {
+		return ErrBucketConflict
+	}
+
+	uattrs := storage.BucketAttrsToUpdate{}
+	changed := false
+	for k, v := range owner.labels() {
+		if attrs.Labels[k] != v {
+			uattrs.SetLabel(k, v)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err = bucket.Update(ctx, uattrs)
+	if err != nil {
+		return fmt.Errorf("update: %v", err)
+	}
+
+	return nil
+}
+
+// ListPendingDeletionBuckets lists the gcp buckets of the project pending deletion, released by owners of the cluster
+func (svc *GCPService) ListPendingDeletionBuckets(ctx context.Context, clusterID string) ([]PendingDeletionBucket, error) {
+	cl := svc.storageClient
+
+	buckets := cl.Buckets(ctx, os.Getenv("GCP_PROJECT"))
+
+	var pending []PendingDeletionBucket
+	for {
+		attrs, err := buckets.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return nil, fmt.Errorf("buckets iterator: %v", err)
+		}
+
+		deleteAfter, ok := pendingDeletion(attrs.Labels)
+		if !ok || attrs.Labels[ownerClusterLabel] != labelValue(clusterID) {
+			continue
+		}
+		pending = append(pending, PendingDeletionBucket{
+			Name:        attrs.Name,
+			Owner:       BucketOwner{ClusterID: clusterID, UID: attrs.Labels[ownerUIDLabel]},
+			DeleteAfter: deleteAfter,
+		})
+	}
+
+	return pending, nil
+}
+
+// ListOwnedBuckets lists the gcp buckets of the project stamped with the ownership labels of the cluster
+func (svc *GCPService) ListOwnedBuckets(ctx context.Context, clusterID string) ([]OwnedBucket, error) {
+	cl := svc.storageClient
+
+	buckets := cl.Buckets(ctx, os.Getenv("GCP_PROJECT"))
+
+	var owned []OwnedBucket
+	for {
+		attrs, err := buckets.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return nil, fmt.Errorf("buckets iterator: %v", err)
+		}
+
+		if attrs.Labels[ownerUIDLabel] == "" || attrs.Labels[ownerClusterLabel] != labelValue(clusterID) {
+			continue
+		}
+		_, pending := pendingDeletion(attrs.Labels)
+		owned = append(owned, OwnedBucket{
+			Name:            attrs.Name,
+			Owner:           BucketOwner{ClusterID: clusterID, UID: attrs.Labels[ownerUIDLabel]},
+			PendingDeletion: pending,
+			SelfOwned:       attrs.Labels[ownerUIDLabel] == labelValue(attrs.Name),
+			Created:         attrs.Created,
+		})
+	}
+
+	return owned, nil
+}
+
+// ArchiveGCPBucketObjects copies up to maxObjects objects of a gcp bucket, in name order after startAfter, to the archive bucket,
+// the copy failures are reported in the progress.
+// returns ErrBucketNotFound if the bucket doesn't exist, ErrBucketConflict if the bucket is not owned by the owner
+func (svc *GCPService) ArchiveGCPBucketObjects(ctx context.Context, name string, owner BucketOwner, archive BucketArchive, startAfter string, maxObjects int) (*ArchiveProgress, error) {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+
+	attrs, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+	if !owner.owns(attrs.Labels) {
+		return nil, ErrBucketConflict
+	}
+
+	archiveBucket := cl.Bucket(archive.BucketName)
+
+	objects := listGCPObjects(bucket.Objects(ctx, &storage.Query{StartOffset: startAfter}), startAfter)
+	return archiveObjects(objects, maxObjects, func(objAttrs *storage.ObjectAttrs) error {
+		copier := archiveBucket.Object(archive.Prefix + objAttrs.Name).CopierFrom(bucket.Object(objAttrs.Name))
+		copier.StorageClass = archive.StorageClass
+		_, err := copier.Run(ctx)
+		return err
+	})
+}
+
+// archiveObjects archives up to maxObjects listed objects with the archive func,
+// the objects that couldn't be archived are counted as failures without stopping the batch
+func archiveObjects(objects gcpObjects, maxObjects int, archive func(*storage.ObjectAttrs) error) (*ArchiveProgress, error) {
+	progress := &ArchiveProgress{}
+	for {
+		objAttrs, err := objects()
+		if err != nil {
+			return nil, err
+		}
+		if objAttrs == nil {
+			progress.Done = true
+			break
+		}
+		if progress.Objects+progress.Failures >= int64(maxObjects) {
+			break
+		}
+		progress.LastObject = objAttrs.Name
+
+		if err := archive(objAttrs); err != nil {
+			progress.Failures++
+			progress.LastError = fmt.Sprintf("%s: %v", objAttrs.Name, err)
+			continue
+		}
+
+		progress.Objects++
+		progress.Bytes += objAttrs.Size
+	}
+
+	return progress, nil
+}
+
+// backupSourceGenerationKey is the metadata key of the backed up objects holding the source object generation
+const backupSourceGenerationKey = "autobucket-source-generation"
+
+// BackupGCPBucketObjects copies the objects of a gcp bucket to the destination in batches of maxObjects, starting after the startAfter object.
+// The objects unchanged since the base backup, with the same generation or content hash, are copied from the base backup in the destination bucket.
+// Object copy failures are reported in the progress and don't stop the batch
+func (svc *GCPService) BackupGCPBucketObjects(ctx context.Context, name string, dest BackupDestination, startAfter string, maxObjects int) (*BackupProgress, error) {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+	_, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+
+	destBucket := cl.Bucket(dest.BucketName)
 
-	objects := bucket.Objects(ctx, nil)
+	// the start offset is inclusive, the start object was backed up by the previous batch
+	objects := bucket.Objects(ctx, &storage.Query{StartOffset: startAfter})
 
+	progress := &BackupProgress{}
 	for {
 		objAttrs, err := objects.Next()
 		if err != nil {
 			if err == iterator.Done {
+				progress.Done = true
 				break
 			}
-			return fmt.Errorf("bucket iterator: %v", err)
+			return nil, fmt.Errorf("bucket iterator: %v", err)
+		}
+		if startAfter != "" && objAttrs.Name == startAfter {
+			continue
+		}
+		if progress.Objects+progress.Unchanged+progress.Failures >= int64(maxObjects) {
+			break
+		}
+		progress.LastObject = objAttrs.Name
+
+		src := bucket.Object(objAttrs.Name)
+		unchanged := false
+		if dest.BasePrefix != "" {
+			unchanged, err = backupUnchanged(ctx, destBucket.Object(dest.BasePrefix+objAttrs.Name), objAttrs)
+			if err != nil {
+				progress.Failures++
+				progress.LastError = fmt.Sprintf("%s: %v", objAttrs.Name, err)
+				continue
+			}
+			if unchanged {
+				src = destBucket.Object(dest.BasePrefix + objAttrs.Name)
+			}
 		}
 
-		obj := bucket.Object(objAttrs.Name)
-		err = obj.Delete(ctx)
+		copier := destBucket.Object(dest.Prefix + objAttrs.Name).CopierFrom(src)
+		// the destination attributes replace the source ones, keep them and stamp the source generation
+		copier.ContentType = objAttrs.ContentType
+		copier.ContentEncoding = objAttrs.ContentEncoding
+		copier.ContentLanguage = objAttrs.ContentLanguage
+		copier.ContentDisposition = objAttrs.ContentDisposition
+		copier.CacheControl = objAttrs.CacheControl
+		copier.Metadata = map[string]string{}
+		for k, v := range objAttrs.Metadata {
+			copier.Metadata[k] = v
+		}
+		copier.Metadata[backupSourceGenerationKey] = strconv.FormatInt(objAttrs.Generation, 10)
+		_, err = copier.Run(ctx)
 		if err != nil {
-			return fmt.Errorf("bucket obj delete: %v", err)
+			progress.Failures++
+			progress.LastError = fmt.Sprintf("%s: %v", objAttrs.Name, err)
+			continue
 		}
+
+		if unchanged {
+			progress.Unchanged++
+		} else {
+			progress.Objects++
+		}
+		progress.Bytes += objAttrs.Size
 	}
 
-	err = bucket.Delete(ctx)
+	return progress, nil
+}
+
+// backupUnchanged checks if the base backup copy of an object has the same source generation or content hash
+func backupUnchanged(ctx context.Context, base *storage.ObjectHandle, objAttrs *storage.ObjectAttrs) (bool, error) {
+	baseAttrs, err := base.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("delete: %v", err)
+		return false, fmt.Errorf("base backup obj attrs: %v", err)
 	}
 
-	return nil
+	if baseAttrs.Metadata[backupSourceGenerationKey] == strconv.FormatInt(objAttrs.Generation, 10) {
+		return true, nil
+	}
+	// composite objects have no md5 hash
+	if len(objAttrs.MD5) > 0 {
+		return bytes.Equal(baseAttrs.MD5, objAttrs.MD5), nil
+	}
+	return baseAttrs.CRC32C == objAttrs.CRC32C && baseAttrs.Size == objAttrs.Size, nil
+}
+
+// RestoreGCPBucketObjects restores the objects of a backup, or the object versions live at a point in time, into a gcp bucket
+// in batches of maxObjects, starting after the startAfter object. The object names are relative to the backup prefix.
+// Object restore failures are reported in the progress and don't stop the batch, an existing object stops it with the fail conflict policy.
+// returns ErrBucketConflict if the bucket is not owned by the owner, ErrSourceBucketNotFound if the backup bucket doesn't exist
+func (svc *GCPService) RestoreGCPBucketObjects(ctx context.Context, name string, owner BucketOwner, source RestoreSource, conflict RestoreConflictPolicy,
+	startAfter string, maxObjects int) (*RestoreProgress, error) {
+	cl := svc.storageClient
+
+	bucket := cl.Bucket(name)
+
+	attrs, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+	if !owner.owns(attrs.Labels) {
+		return nil, ErrBucketConflict
+	}
+
+	if source.BucketName == "" {
+		return restoreGCPObjectVersions(ctx, bucket, source.PointInTime, conflict, startAfter, maxObjects)
+	}
+
+	sourceBucket := cl.Bucket(source.BucketName)
+	_, err = sourceBucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrSourceBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("source bucket attrs: %v", err)
+	}
+
+	// the start offset is inclusive, the start object was restored by the previous batch
+	query := &storage.Query{Prefix: source.Prefix}
+	if startAfter != "" {
+		query.StartOffset = source.Prefix + startAfter
+	}
+	objects := sourceBucket.Objects(ctx, query)
+
+	progress := &RestoreProgress{}
+	for {
+		objAttrs, err := objects.Next()
+		if err != nil {
+			if err == iterator.Done {
+				progress.Done = true
+				break
+			}
+			return nil, fmt.Errorf("bucket iterator: %v", err)
+		}
+		objName := strings.TrimPrefix(objAttrs.Name, source.Prefix)
+		if startAfter != "" && objName == startAfter {
+			continue
+		}
+		if progress.Objects+progress.Skipped+progress.Failures >= int64(maxObjects) {
+			break
+		}
+
+		restore, err := restoreTargetCheck(ctx, bucket.Object(objName), objAttrs, conflict)
+		if err != nil {
+			progress.Failures++
+			progress.LastError = fmt.Sprintf("%s: %v", objName, err)
+			progress.LastObject = objName
+			continue
+		}
+		if restore == restoreConflict {
+			progress.Conflict = objName
+			return progress, nil
+		}
+		progress.LastObject = objName
+		if restore == restoreSkip {
+			progress.Skipped++
+			continue
+		}
+
+		copier := bucket.Object(objName).CopierFrom(sourceBucket.Object(objAttrs.Name))
+		// the destination attributes replace the source ones, keep them without the backup metadata
+		copier.ContentType = objAttrs.ContentType
+		copier.ContentEncoding = objAttrs.ContentEncoding
+		copier.ContentLanguage = objAttrs.ContentLanguage
+		copier.ContentDisposition = objAttrs.ContentDisposition
+		copier.CacheControl = objAttrs.CacheControl
+		copier.Metadata = map[string]string{}
+		for k, v := range objAttrs.Metadata {
+			if k != backupSourceGenerationKey {
+				copier.Metadata[k] = v
+			}
+		}
+		_, err = copier.Run(ctx)
+		if err != nil {
+			progress.Failures++
+			progress.LastError = fmt.Sprintf("%s: %v", objName, err)
+			continue
+		}
+
+		progress.Objects++
+		progress.Bytes += objAttrs.Size
+	}
+
+	return progress, nil
+}
+
+// restoreGCPObjectVersions restores the object versions of a versioned gcp bucket live at a point in time
+func restoreGCPObjectVersions(ctx context.Context, bucket *storage.BucketHandle, pointInTime time.Time, conflict RestoreConflictPolicy,
+	startAfter string, maxObjects int) (*RestoreProgress, error) {
+	objects := listGCPObjects(bucket.Objects(ctx, &storage.Query{Versions: true, StartOffset: startAfter}), startAfter)
+	return restoreObjectVersions(objects, pointInTime, conflict, maxObjects, func(version *storage.ObjectAttrs) error {
+		_, err := bucket.Object(version.Name).CopierFrom(bucket.Object(version.Name).Generation(version.Generation)).Run(ctx)
+		return err
+	})
+}
+
+// restoreObjectVersions restores with the restore func the listed object versions live at a point in time.
+// The versions of an object are listed together, a batch only stops between objects.
+// The objects created after the point in time are kept
+func restoreObjectVersions(objects gcpObjects, pointInTime time.Time, conflict RestoreConflictPolicy, maxObjects int,
+	restore func(version *storage.ObjectAttrs) error) (*RestoreProgress, error) {
+	progress := &RestoreProgress{}
+	var versions []*storage.ObjectAttrs
+	// restoreVersions restores the version live at the point in time among the versions of an object, returns false to stop the batch
+	restoreVersions := func() bool {
+		if len(versions) == 0 {
+			return true
+		}
+		objName := versions[0].Name
+		restored, live := pointInTimeVersion(versions, pointInTime)
+		versions = nil
+
+		if restored == nil || (live != nil && live.Generation == restored.Generation) {
+			// created after the point in time, or unchanged since
+			progress.Skipped++
+			progress.LastObject = objName
+			return true
+		}
+		if live != nil {
+			switch conflict {
+			case RestoreConflictFail:
+				progress.Conflict = objName
+				return false
+			case RestoreConflictSkip:
+				progress.Skipped++
+				progress.LastObject = objName
+				return true
+			}
+		}
+
+		progress.LastObject = objName
+		if err := restore(restored); err != nil {
+			progress.Failures++
+			progress.LastError = fmt.Sprintf("%s: %v", objName, err)
+			return true
+		}
+		progress.Objects++
+		progress.Bytes += restored.Size
+		return true
+	}
+
+	for {
+		objAttrs, err := objects()
+		if err != nil {
+			return nil, err
+		}
+		if objAttrs == nil {
+			if restoreVersions() {
+				progress.Done = true
+			}
+			break
+		}
+		if len(versions) > 0 && versions[0].Name != objAttrs.Name {
+			if !restoreVersions() {
+				break
+			}
+			if progress.Objects+progress.Skipped+progress.Failures >= int64(maxObjects) {
+				break
+			}
+		}
+		versions = append(versions, objAttrs)
+	}
+
+	return progress, nil
+}
+
+// pointInTimeVersion returns among the versions of an object the version live at the point in time and the live version, nil if none
+func pointInTimeVersion(versions []*storage.ObjectAttrs, pointInTime time.Time) (restored *storage.ObjectAttrs, live *storage.ObjectAttrs) {
+	for _, v := range versions {
+		if !v.Created.After(pointInTime) && (v.Deleted.IsZero() || v.Deleted.After(pointInTime)) {
+			restored = v
+		}
+		if v.Deleted.IsZero() {
+			live = v
+		}
+	}
+	return restored, live
+}
+
+type restoreAction int
+
+const (
+	restoreCopy restoreAction = iota
+	restoreSkip
+	restoreConflict
+)
+
+// restoreTargetCheck decides if a backed up object is restored over the target object, per the conflict policy
+func restoreTargetCheck(ctx context.Context, target *storage.ObjectHandle, objAttrs *storage.ObjectAttrs, conflict RestoreConflictPolicy) (restoreAction, error) {
+	targetAttrs, err := target.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return restoreCopy, nil
+	}
+	if err != nil {
+		return restoreCopy, fmt.Errorf("target obj attrs: %v", err)
+	}
+
+	unchanged := targetAttrs.Size == objAttrs.Size && targetAttrs.CRC32C == objAttrs.CRC32C
+	if len(objAttrs.MD5) > 0 {
+		unchanged = bytes.Equal(targetAttrs.MD5, objAttrs.MD5)
+	}
+	if unchanged {
+		return restoreSkip, nil
+	}
+
+	switch conflict {
+	case RestoreConflictOverwrite:
+		return restoreCopy, nil
+	case RestoreConflictFail:
+		return restoreConflict, nil
+	default:
+		return restoreSkip, nil
+	}
+}
+
+// ReplicateGCPBucketObjects mirrors the objects of a gcp bucket to the destination gcp bucket in batches of maxObjects compared objects,
+// starting after the startAfter object. Both buckets are listed in name order and compared: the new and changed objects are copied,
+// and the destination objects removed from the source are deleted if deleteRemoved is true.
+// Object replication failures are reported in the progress and don't stop the batch.
+// returns ErrBucketConflict if the destination bucket is not owned by the owner
+func (svc *GCPService) ReplicateGCPBucketObjects(ctx context.Context, source string, dest string, owner BucketOwner, deleteRemoved bool,
+	startAfter string, maxObjects int) (*ReplicationProgress, error) {
+	cl := svc.storageClient
+
+	sourceBucket := cl.Bucket(source)
+	_, err := sourceBucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+
+	destBucket := cl.Bucket(dest)
+	attrs, err := destBucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		return nil, ErrBucketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bucket attrs: %v", err)
+	}
+	if !owner.owns(attrs.Labels) {
+		return nil, ErrBucketConflict
+	}
+
+	sourceObjects := listGCPObjects(sourceBucket.Objects(ctx, &storage.Query{StartOffset: startAfter}), startAfter)
+	destObjects := listGCPObjects(destBucket.Objects(ctx, &storage.Query{StartOffset: startAfter}), startAfter)
+	ops := replicationOps{
+		copy: func(objAttrs *storage.ObjectAttrs) error {
+			_, err := destBucket.Object(objAttrs.Name).CopierFrom(sourceBucket.Object(objAttrs.Name)).Run(ctx)
+			return err
+		},
+		delete: func(name string) error {
+			err := destBucket.Object(name).Delete(ctx)
+			if err == storage.ErrObjectNotExist {
+				return nil
+			}
+			return err
+		},
+	}
+	return replicateObjects(sourceObjects, destObjects, ops, deleteRemoved, maxObjects)
+}
+
+// replicationOps applies the replication changes to the destination bucket
+type replicationOps struct {
+	// copy copies a new or changed source object
+	copy func(objAttrs *storage.ObjectAttrs) error
+	// delete deletes a destination object removed from the source
+	delete func(name string) error
+}
+
+// replicateObjects compares the name ordered source and destination listings and applies the changes with the ops,
+// it stops after maxObjects compared objects
+func replicateObjects(sourceObjects gcpObjects, destObjects gcpObjects, ops replicationOps, deleteRemoved bool, maxObjects int) (*ReplicationProgress, error) {
+	src, err := sourceObjects()
+	if err != nil {
+		return nil, err
+	}
+	dst, err := destObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &ReplicationProgress{}
+	replicate := func(objAttrs *storage.ObjectAttrs) {
+		if err := ops.copy(objAttrs); err != nil {
+			progress.Failures++
+			progress.LastError = fmt.Sprintf("%s: %v", objAttrs.Name, err)
+			return
+		}
+		progress.Copied++
+		progress.Bytes += objAttrs.Size
+	}
+
+	for src != nil || dst != nil {
+		if progress.Objects >= int64(maxObjects) {
+			return progress, nil
+		}
+		progress.Objects++
+
+		switch {
+		case dst == nil || (src != nil && src.Name < dst.Name):
+			// new object
+			progress.LastObject = src.Name
+			replicate(src)
+			if src, err = sourceObjects(); err != nil {
+				return nil, err
+			}
+		case src == nil || dst.Name < src.Name:
+			// removed object
+			progress.LastObject = dst.Name
+			if deleteRemoved {
+				if err := ops.delete(dst.Name); err != nil {
+					progress.Failures++
+					progress.LastError = fmt.Sprintf("%s: %v", dst.Name, err)
+				} else {
+					progress.Deleted++
+				}
+			}
+			if dst, err = destObjects(); err != nil {
+				return nil, err
+			}
+		default:
+			progress.LastObject = src.Name
+			if !sameGCPObjectContent(src, dst) {
+				replicate(src)
+			}
+			if src, err = sourceObjects(); err != nil {
+				return nil, err
+			}
+			if dst, err = destObjects(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	progress.Done = true
+	return progress, nil
+}
+
+// sameGCPObjectContent checks if two objects have the same content hash, composite objects have no md5 hash
+func sameGCPObjectContent(a *storage.ObjectAttrs, b *storage.ObjectAttrs) bool {
+	if len(a.MD5) > 0 && len(b.MD5) > 0 {
+		return bytes.Equal(a.MD5, b.MD5)
+	}
+	return a.Size == b.Size && a.CRC32C == b.CRC32C
 }
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// listedObjects lists the objects like a query starting at startAfter, without the startAfter object
+func listedObjects(objects []*storage.ObjectAttrs, startAfter string) gcpObjects {
+	i := 0
+	return func() (*storage.ObjectAttrs, error) {
+		for ; i < len(objects); i++ {
+			if objects[i].Name > startAfter {
+				i++
+				return objects[i-1], nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+func object(name string, md5 string, size int64) *storage.ObjectAttrs {
+	return &storage.ObjectAttrs{Name: name, MD5: []byte(md5), Size: size}
+}
+
+// replicationRecorder records the changes applied by a replication
+type replicationRecorder struct {
+	copied  []string
+	deleted []string
+	fail    string
+}
+
+func (r *replicationRecorder) ops() replicationOps {
+	return replicationOps{
+		copy: func(objAttrs *storage.ObjectAttrs) error {
+			if objAttrs.Name == r.fail {
+				return errors.New("copy failed")
+			}
+			r.copied = append(r.copied, objAttrs.Name)
+			return nil
+		},
+		delete: func(name string) error {
+			if name == r.fail {
+				return errors.New("delete failed")
+			}
+			r.deleted = append(r.deleted, name)
+			return nil
+		},
+	}
+}
+
+var _ = Describe("Bucket replication", func() {
+	source := []*storage.ObjectAttrs{
+		object("a", "1", 1),
+		object("b", "2", 2),
+		object("d", "4", 4),
+		object("e", "5", 5),
+	}
+	dest := []*storage.ObjectAttrs{
+		object("b", "2", 2),
+		object("c", "3", 3),
+		object("d", "0", 4),
+		object("f", "6", 6),
+	}
+
+	DescribeTable("comparison of the source and destination objects",
+		func(deleteRemoved bool, fail string, copied []string, deleted []string, expected ReplicationProgress) {
+			recorder := &replicationRecorder{fail: fail}
+			progress, err := replicateObjects(listedObjects(source, ""), listedObjects(dest, ""), recorder.ops(), deleteRemoved, 100)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.copied).To(Equal(copied))
+			Expect(recorder.deleted).To(Equal(deleted))
+			Expect(*progress).To(Equal(expected))
+		},
+		Entry("new and changed objects copied, removed objects kept", false, "",
+			[]string{"a", "d", "e"}, []string(nil),
+			ReplicationProgress{Objects: 6, Copied: 3, Bytes: 10, LastObject: "f", Done: true}),
+		Entry("removed objects deleted", true, "",
+			[]string{"a", "d", "e"}, []string{"c", "f"},
+			ReplicationProgress{Objects: 6, Copied: 3, Deleted: 2, Bytes: 10, LastObject: "f", Done: true}),
+		Entry("copy failure reported", true, "d",
+			[]string{"a", "e"}, []string{"c", "f"},
+			ReplicationProgress{Objects: 6, Copied: 2, Deleted: 2, Bytes: 6, Failures: 1, LastError: "d: copy failed", LastObject: "f", Done: true}),
+		Entry("delete failure reported", true, "c",
+			[]string{"a", "d", "e"}, []string{"f"},
+			ReplicationProgress{Objects: 6, Copied: 3, Deleted: 1, Bytes: 10, Failures: 1, LastError: "c: delete failed", LastObject: "f", Done: true}),
+	)
+
+	DescribeTable("resumed batches",
+		func(maxObjects int, batches int) {
+			recorder := &replicationRecorder{}
+			startAfter := ""
+			n := 0
+			for {
+				n++
+				progress, err := replicateObjects(listedObjects(source, startAfter), listedObjects(dest, startAfter), recorder.ops(), true, maxObjects)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(progress.Objects).To(BeNumerically("<=", maxObjects))
+				if progress.Done {
+					break
+				}
+				Expect(progress.LastObject > startAfter).To(BeTrue())
+				startAfter = progress.LastObject
+			}
+			Expect(n).To(Equal(batches))
+			Expect(recorder.copied).To(Equal([]string{"a", "d", "e"}))
+			Expect(recorder.deleted).To(Equal([]string{"c", "f"}))
+		},
+		Entry("one object per batch", 1, 6),
+		Entry("two objects per batch", 2, 3),
+		Entry("four objects per batch", 4, 2),
+		Entry("all the objects in a batch", 6, 1),
+		Entry("more objects per batch than objects", 10, 1),
+	)
+
+	It("Should stop on a listing error", func() {
+		failing := func() (*storage.ObjectAttrs, error) { return nil, errors.New("bucket iterator: failed") }
+		_, err := replicateObjects(listedObjects(source, ""), failing, (&replicationRecorder{}).ops(), true, 100)
+		Expect(err).To(MatchError("bucket iterator: failed"))
+	})
+})
+
+var _ = Describe("Bucket emptying", func() {
+	objects := []*storage.ObjectAttrs{
+		object("a", "", 1),
+		object("b", "", 2),
+		object("c", "", 3),
+		object("d", "", 4),
+	}
+
+	DescribeTable("deletion of the object versions",
+		func(listErr error, deleteErrs map[string]error, cancel bool, expected EmptyProgress, expectedErr string) {
+			ctx, cancelCtx := context.WithCancel(context.Background())
+			defer cancelCtx()
+			if cancel {
+				cancelCtx()
+			}
+
+			listed := listedObjects(objects, "")
+			if listErr != nil {
+				listed = func() (*storage.ObjectAttrs, error) { return nil, listErr }
+			}
+			var mu sync.Mutex
+			var deleted []string
+			progress, err := deleteObjects(ctx, listed, func(objAttrs *storage.ObjectAttrs) error {
+				if err := deleteErrs[objAttrs.Name]; err != nil {
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				deleted = append(deleted, objAttrs.Name)
+				return nil
+			}, 2)
+
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expectedErr))
+			}
+			Expect(*progress).To(Equal(expected))
+			Expect(deleted).To(HaveLen(int(expected.Objects)))
+		},
+		Entry("all the objects deleted", nil, nil, false,
+			EmptyProgress{Objects: 4, Bytes: 10, Done: true}, ""),
+		Entry("objects already deleted ignored", nil, map[string]error{"b": storage.ErrObjectNotExist}, false,
+			EmptyProgress{Objects: 3, Bytes: 8, Done: true}, ""),
+		Entry("delete failure", nil, map[string]error{"c": errors.New("forbidden")}, false,
+			EmptyProgress{Objects: 3, Bytes: 7}, "bucket obj delete: forbidden"),
+		Entry("listing failure", errors.New("bucket iterator: failed"), nil, false,
+			EmptyProgress{}, "bucket iterator: failed"),
+		Entry("context done, resumed by a later call", errors.New("bucket iterator: context canceled"), nil, true,
+			EmptyProgress{}, ""),
+	)
+})
+
+var _ = Describe("Bucket archive", func() {
+	objects := []*storage.ObjectAttrs{
+		object("a", "", 1),
+		object("b", "", 2),
+		object("c", "", 3),
+	}
+
+	DescribeTable("copy of the objects to the archive bucket",
+		func(maxObjects int, fail string, archived []string, expected ArchiveProgress) {
+			var copied []string
+			progress, err := archiveObjects(listedObjects(objects, ""), maxObjects, func(objAttrs *storage.ObjectAttrs) error {
+				if objAttrs.Name == fail {
+					return errors.New("copy failed")
+				}
+				copied = append(copied, objAttrs.Name)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(copied).To(Equal(archived))
+			Expect(*progress).To(Equal(expected))
+		},
+		Entry("all the objects archived", 10, "", []string{"a", "b", "c"},
+			ArchiveProgress{Objects: 3, Bytes: 6, LastObject: "c", Done: true}),
+		Entry("copy failure reported without stopping the batch", 10, "b", []string{"a", "c"},
+			ArchiveProgress{Objects: 2, Bytes: 4, Failures: 1, LastError: "b: copy failed", LastObject: "c", Done: true}),
+		Entry("failures counted in the batch size", 2, "a", []string{"b"},
+			ArchiveProgress{Objects: 1, Bytes: 2, Failures: 1, LastError: "a: copy failed", LastObject: "b"}),
+	)
+
+	It("Should stop on a listing error", func() {
+		failing := func() (*storage.ObjectAttrs, error) { return nil, errors.New("bucket iterator: failed") }
+		_, err := archiveObjects(failing, 10, func(*storage.ObjectAttrs) error { return nil })
+		Expect(err).To(MatchError("bucket iterator: failed"))
+	})
+})
+
+var _ = Describe("Point in time restore", func() {
+	pointInTime := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	version := func(name string, generation int64, created time.Time, deleted time.Time) *storage.ObjectAttrs {
+		return &storage.ObjectAttrs{Name: name, Generation: generation, Created: created, Deleted: deleted, Size: generation}
+	}
+	generation := func(v *storage.ObjectAttrs) int64 {
+		if v == nil {
+			return 0
+		}
+		return v.Generation
+	}
+
+	DescribeTable("version live at the point in time",
+		func(versions []*storage.ObjectAttrs, restored int64, live int64) {
+			r, l := pointInTimeVersion(versions, pointInTime)
+			Expect(generation(r)).To(Equal(restored))
+			Expect(generation(l)).To(Equal(live))
+		},
+		Entry("unchanged since", []*storage.ObjectAttrs{version("a", 1, day(1), time.Time{})}, int64(1), int64(1)),
+		Entry("overwritten after", []*storage.ObjectAttrs{version("a", 1, day(1), day(12)), version("a", 2, day(12), time.Time{})}, int64(1), int64(2)),
+		Entry("overwritten before", []*storage.ObjectAttrs{version("a", 1, day(1), day(5)), version("a", 2, day(5), time.Time{})}, int64(2), int64(2)),
+		Entry("deleted after", []*storage.ObjectAttrs{version("a", 1, day(1), day(12))}, int64(1), int64(0)),
+		Entry("deleted before", []*storage.ObjectAttrs{version("a", 1, day(1), day(5))}, int64(0), int64(0)),
+		Entry("created after", []*storage.ObjectAttrs{version("a", 1, day(12), time.Time{})}, int64(0), int64(1)),
+		Entry("created at the point in time", []*storage.ObjectAttrs{version("a", 1, pointInTime, time.Time{})}, int64(1), int64(1)),
+		Entry("deleted at the point in time", []*storage.ObjectAttrs{version("a", 1, day(1), pointInTime)}, int64(0), int64(0)),
+	)
+
+	// versions listed per object: a unchanged, b overwritten after, c deleted after, d created after
+	versions := []*storage.ObjectAttrs{
+		version("a", 1, day(1), time.Time{}),
+		version("b", 2, day(1), day(12)),
+		version("b", 3, day(12), time.Time{}),
+		version("c", 4, day(1), day(12)),
+		version("d", 5, day(12), time.Time{}),
+	}
+
+	DescribeTable("restore of the object versions",
+		func(conflict RestoreConflictPolicy, restored []int64, expected RestoreProgress) {
+			var generations []int64
+			progress, err := restoreObjectVersions(listedObjects(versions, ""), pointInTime, conflict, 100, func(v *storage.ObjectAttrs) error {
+				generations = append(generations, v.Generation)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(generations).To(Equal(restored))
+			Expect(*progress).To(Equal(expected))
+		},
+		Entry("overwrite", RestoreConflictOverwrite, []int64{2, 4},
+			RestoreProgress{Objects: 2, Skipped: 2, Bytes: 6, LastObject: "d", Done: true}),
+		Entry("skip", RestoreConflictSkip, []int64{4},
+			RestoreProgress{Objects: 1, Skipped: 3, Bytes: 4, LastObject: "d", Done: true}),
+		Entry("fail", RestoreConflictFail, []int64(nil),
+			RestoreProgress{Skipped: 1, LastObject: "a", Conflict: "b"}),
+	)
+
+	It("Should not split the versions of an object across batches", func() {
+		var generations []int64
+		restore := func(v *storage.ObjectAttrs) error {
+			generations = append(generations, v.Generation)
+			return nil
+		}
+		startAfter := ""
+		var lastObjects []string
+		for {
+			progress, err := restoreObjectVersions(listedObjects(versions, startAfter), pointInTime, RestoreConflictOverwrite, 1, restore)
+			Expect(err).NotTo(HaveOccurred())
+			if progress.Done {
+				break
+			}
+			startAfter = progress.LastObject
+			lastObjects = append(lastObjects, startAfter)
+		}
+		Expect(lastObjects).To(Equal([]string{"a", "b", "c"}))
+		Expect(generations).To(Equal([]int64{2, 4}))
+	})
+
+	It("Should report the restore failures without stopping the batch", func() {
+		progress, err := restoreObjectVersions(listedObjects(versions, ""), pointInTime, RestoreConflictOverwrite, 100, func(v *storage.ObjectAttrs) error {
+			if v.Name == "b" {
+				return errors.New("copy failed")
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*progress).To(Equal(RestoreProgress{Objects: 1, Skipped: 2, Bytes: 4, Failures: 1, LastError: "b: copy failed", LastObject: "d", Done: true}))
+	})
+})
@@ -4,8 +4,11 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
+
+	services "github.com/didil/autobucket-operator/services"
 )
 
 // GCPSvc is an autogenerated mock type for the GCPSvc type
@@ -13,13 +16,13 @@ type GCPSvc struct {
 	mock.Mock
 }
 
-// CreateBucket provides a mock function with given fields: ctx, name
-func (_m *GCPSvc) CreateBucket(ctx context.Context, name string) error {
-	ret := _m.Called(ctx, name)
+// CreateBucket provides a mock function with given fields: ctx, name, attrs, owner
+func (_m *GCPSvc) CreateBucket(ctx context.Context, name string, attrs services.BucketAttrs, owner services.BucketOwner) error {
+	ret := _m.Called(ctx, name, attrs, owner)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, name)
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketAttrs, services.BucketOwner) error); ok {
+		r0 = rf(ctx, name, attrs, owner)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -27,16 +30,323 @@ func (_m *GCPSvc) CreateBucket(ctx context.Context, name string) error {
 	return r0
 }
 
-// DeleteGCPBucket provides a mock function with given fields: ctx, name
-func (_m *GCPSvc) DeleteGCPBucket(ctx context.Context, name string) error {
+// AdoptBucket provides a mock function with given fields: ctx, name, owner
+func (_m *GCPSvc) AdoptBucket(ctx context.Context, name string, owner services.BucketOwner) (*services.BucketAttrs, error) {
+	ret := _m.Called(ctx, name, owner)
+
+	var r0 *services.BucketAttrs
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner) *services.BucketAttrs); ok {
+		r0 = rf(ctx, name, owner)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.BucketAttrs)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, services.BucketOwner) error); ok {
+		r1 = rf(ctx, name, owner)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetBucketAttrs provides a mock function with given fields: ctx, name
+func (_m *GCPSvc) GetBucketAttrs(ctx context.Context, name string) (*services.BucketAttrs, error) {
 	ret := _m.Called(ctx, name)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+	var r0 *services.BucketAttrs
+	if rf, ok := ret.Get(0).(func(context.Context, string) *services.BucketAttrs); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.BucketAttrs)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetBucketSize provides a mock function with given fields: ctx, name
+func (_m *GCPSvc) GetBucketSize(ctx context.Context, name string) (int64, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
 		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GrantBucketAccess provides a mock function with given fields: ctx, name, member, role
+func (_m *GCPSvc) GrantBucketAccess(ctx context.Context, name string, member string, role string) error {
+	ret := _m.Called(ctx, name, member, role)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, name, member, role)
 	} else {
 		r0 = ret.Error(0)
 	}
 
 	return r0
 }
+
+// RevokeBucketAccess provides a mock function with given fields: ctx, name, member, role
+func (_m *GCPSvc) RevokeBucketAccess(ctx context.Context, name string, member string, role string) error {
+	ret := _m.Called(ctx, name, member, role)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, name, member, role)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteGCPBucket provides a mock function with given fields: ctx, name, owner
+func (_m *GCPSvc) DeleteGCPBucket(ctx context.Context, name string, owner services.BucketOwner) error {
+	ret := _m.Called(ctx, name, owner)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner) error); ok {
+		r0 = rf(ctx, name, owner)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RetainGCPBucket provides a mock function with given fields: ctx, name, owner, deleteAfter
+func (_m *GCPSvc) RetainGCPBucket(ctx context.Context, name string, owner services.BucketOwner, deleteAfter time.Time) error {
+	ret := _m.Called(ctx, name, owner, deleteAfter)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner, time.Time) error); ok {
+		r0 = rf(ctx, name, owner, deleteAfter)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReleaseGCPBucket provides a mock function with given fields: ctx, name, owner
+func (_m *GCPSvc) ReleaseGCPBucket(ctx context.Context, name string, owner services.BucketOwner) error {
+	ret := _m.Called(ctx, name, owner)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner) error); ok {
+		r0 = rf(ctx, name, owner)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListPendingDeletionBuckets provides a mock function with given fields: ctx, clusterID
+func (_m *GCPSvc) ListPendingDeletionBuckets(ctx context.Context, clusterID string) ([]services.PendingDeletionBucket, error) {
+	ret := _m.Called(ctx, clusterID)
+
+	var r0 []services.PendingDeletionBucket
+	if rf, ok := ret.Get(0).(func(context.Context, string) []services.PendingDeletionBucket); ok {
+		r0 = rf(ctx, clusterID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]services.PendingDeletionBucket)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, clusterID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ArchiveGCPBucketObjects provides a mock function with given fields: ctx, name, owner, archive, startAfter, maxObjects
+func (_m *GCPSvc) ArchiveGCPBucketObjects(ctx context.Context, name string, owner services.BucketOwner, archive services.BucketArchive, startAfter string, maxObjects int) (*services.ArchiveProgress, error) {
+	ret := _m.Called(ctx, name, owner, archive, startAfter, maxObjects)
+
+	var r0 *services.ArchiveProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner, services.BucketArchive, string, int) *services.ArchiveProgress); ok {
+		r0 = rf(ctx, name, owner, archive, startAfter, maxObjects)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.ArchiveProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, services.BucketOwner, services.BucketArchive, string, int) error); ok {
+		r1 = rf(ctx, name, owner, archive, startAfter, maxObjects)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EmptyGCPBucket provides a mock function with given fields: ctx, name, owner, workers
+func (_m *GCPSvc) EmptyGCPBucket(ctx context.Context, name string, owner services.BucketOwner, workers int) (*services.EmptyProgress, error) {
+	ret := _m.Called(ctx, name, owner, workers)
+
+	var r0 *services.EmptyProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner, int) *services.EmptyProgress); ok {
+		r0 = rf(ctx, name, owner, workers)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.EmptyProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, services.BucketOwner, int) error); ok {
+		r1 = rf(ctx, name, owner, workers)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListOwnedBuckets provides a mock function with given fields: ctx, clusterID
+func (_m *GCPSvc) ListOwnedBuckets(ctx context.Context, clusterID string) ([]services.OwnedBucket, error) {
+	ret := _m.Called(ctx, clusterID)
+
+	var r0 []services.OwnedBucket
+	if rf, ok := ret.Get(0).(func(context.Context, string) []services.OwnedBucket); ok {
+		r0 = rf(ctx, clusterID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]services.OwnedBucket)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, clusterID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BackupGCPBucketObjects provides a mock function with given fields: ctx, name, dest, startAfter, maxObjects
+func (_m *GCPSvc) BackupGCPBucketObjects(ctx context.Context, name string, dest services.BackupDestination, startAfter string, maxObjects int) (*services.BackupProgress, error) {
+	ret := _m.Called(ctx, name, dest, startAfter, maxObjects)
+
+	var r0 *services.BackupProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BackupDestination, string, int) *services.BackupProgress); ok {
+		r0 = rf(ctx, name, dest, startAfter, maxObjects)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.BackupProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, services.BackupDestination, string, int) error); ok {
+		r1 = rf(ctx, name, dest, startAfter, maxObjects)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteGCPObjects provides a mock function with given fields: ctx, name, prefix, workers
+func (_m *GCPSvc) DeleteGCPObjects(ctx context.Context, name string, prefix string, workers int) (*services.EmptyProgress, error) {
+	ret := _m.Called(ctx, name, prefix, workers)
+
+	var r0 *services.EmptyProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int) *services.EmptyProgress); ok {
+		r0 = rf(ctx, name, prefix, workers)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.EmptyProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, int) error); ok {
+		r1 = rf(ctx, name, prefix, workers)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreGCPBucketObjects provides a mock function with given fields: ctx, name, owner, source, conflict, startAfter, maxObjects
+func (_m *GCPSvc) RestoreGCPBucketObjects(ctx context.Context, name string, owner services.BucketOwner, source services.RestoreSource, conflict services.RestoreConflictPolicy, startAfter string, maxObjects int) (*services.RestoreProgress, error) {
+	ret := _m.Called(ctx, name, owner, source, conflict, startAfter, maxObjects)
+
+	var r0 *services.RestoreProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string, services.BucketOwner, services.RestoreSource, services.RestoreConflictPolicy, string, int) *services.RestoreProgress); ok {
+		r0 = rf(ctx, name, owner, source, conflict, startAfter, maxObjects)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.RestoreProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, services.BucketOwner, services.RestoreSource, services.RestoreConflictPolicy, string, int) error); ok {
+		r1 = rf(ctx, name, owner, source, conflict, startAfter, maxObjects)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReplicateGCPBucketObjects provides a mock function with given fields: ctx, source, dest, owner, deleteRemoved, startAfter, maxObjects
+func (_m *GCPSvc) ReplicateGCPBucketObjects(ctx context.Context, source string, dest string, owner services.BucketOwner, deleteRemoved bool, startAfter string, maxObjects int) (*services.ReplicationProgress, error) {
+	ret := _m.Called(ctx, source, dest, owner, deleteRemoved, startAfter, maxObjects)
+
+	var r0 *services.ReplicationProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, services.BucketOwner, bool, string, int) *services.ReplicationProgress); ok {
+		r0 = rf(ctx, source, dest, owner, deleteRemoved, startAfter, maxObjects)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*services.ReplicationProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, services.BucketOwner, bool, string, int) error); ok {
+		r1 = rf(ctx, source, dest, owner, deleteRemoved, startAfter, maxObjects)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}